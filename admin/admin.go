@@ -0,0 +1,220 @@
+// Package admin reports the state of the server and its services to admins.
+package admin
+
+import (
+	"NbirdHttp/api"
+	"NbirdHttp/apierror"
+	"NbirdHttp/auth"
+	"NbirdHttp/events"
+	"NbirdHttp/scheduler"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version is the release the binary was built from, set with
+// -ldflags "-X NbirdHttp/admin.Version=v1.2.3".
+var Version = "dev"
+
+var started = time.Now()
+
+// Overview is the state of the server and each service.
+type Overview struct {
+	StartedAt     time.Time   `json:"started_at"`
+	UptimeSeconds int64       `json:"uptime_seconds"`
+	Build         BuildInfo   `json:"build"`
+	Connections   Connections `json:"connections"`
+	Users         []UserUsage `json:"users"`
+	// Each service's stats, by service name
+	Services map[string]any        `json:"services"`
+	Jobs     []scheduler.JobStatus `json:"jobs"`
+	// Newest first
+	RecentErrors []LogEntry `json:"recent_errors"`
+}
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	// VCS details, when built from a git checkout
+	Revision string `json:"revision,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Modified bool   `json:"modified,omitempty"`
+}
+
+type Connections struct {
+	// Open HTTP connections, including idle keep-alive ones
+	HTTP         int64 `json:"http"`
+	EventStreams int   `json:"event_streams"`
+}
+
+// UserUsage is the disk space a user's data takes in each service. Users
+// with data who never registered, such as punch clock users, are included.
+type UserUsage struct {
+	Name       string `json:"name"`
+	Registered bool   `json:"registered"`
+	Admin      bool   `json:"admin"`
+	// Bytes stored by service name
+	StorageBytes map[string]int64 `json:"storage_bytes"`
+}
+
+var (
+	mu          sync.Mutex
+	stats       = map[string]func(ctx context.Context) (any, error){}
+	userStorage = map[string]func() (map[string]int64, error){}
+)
+
+// RegisterStats adds a section to the overview, named for the service,
+// holding whatever fn returns.
+func RegisterStats(service string, fn func(ctx context.Context) (any, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	stats[service] = fn
+}
+
+// RegisterUserStorage adds a service's per-user disk usage to the
+// overview. fn returns the bytes stored for each user.
+func RegisterUserStorage(service string, fn func() (map[string]int64, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	userStorage[service] = fn
+}
+
+var openConns atomic.Int64
+
+// ConnState counts the server's open connections. Set it as the
+// http.Server's ConnState.
+func ConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		openConns.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		openConns.Add(-1)
+	}
+}
+
+func AdminController() {
+	api.Handle(api.Route{
+		Method:   "GET",
+		Pattern:  "/api/admin/overview",
+		Tag:      "admin",
+		Summary:  "Server uptime, build, connections and each service's usage",
+		SignedIn: true,
+		Response: Overview{},
+		Handler:  handleOverview,
+	})
+}
+
+func handleOverview(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.RequireAdmin(r); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	users, err := userUsage()
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	services, err := serviceStats(r.Context())
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	overview := Overview{
+		StartedAt:     started.UTC(),
+		UptimeSeconds: int64(time.Since(started).Seconds()),
+		Build:         buildInfo(),
+		Connections: Connections{
+			HTTP:         openConns.Load(),
+			EventStreams: events.Default.Subscribers(),
+		},
+		Users:        users,
+		Services:     services,
+		Jobs:         scheduler.Default.Jobs(),
+		RecentErrors: Errors.Recent(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overview)
+}
+
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: Version}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = build.GoVersion
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// Returns every registered user and every user with stored data, by name
+func userUsage() ([]UserUsage, error) {
+	registered, err := auth.Users()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	byName := map[string]*UserUsage{}
+	get := func(name string) *UserUsage {
+		if byName[name] == nil {
+			byName[name] = &UserUsage{Name: name, Admin: auth.IsAdmin(name), StorageBytes: map[string]int64{}}
+		}
+		return byName[name]
+	}
+	for _, name := range registered {
+		get(name).Registered = true
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for service, fn := range userStorage {
+		usage, err := fn()
+		if err != nil {
+			return nil, fmt.Errorf("failed to measure %s storage: %w", service, err)
+		}
+		for name, size := range usage {
+			get(name).StorageBytes[service] = size
+		}
+	}
+
+	users := make([]UserUsage, 0, len(byName))
+	for _, user := range byName {
+		users = append(users, *user)
+	}
+	slices.SortFunc(users, func(a, b UserUsage) int { return strings.Compare(a.Name, b.Name) })
+	return users, nil
+}
+
+func serviceStats(ctx context.Context) (map[string]any, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	services := map[string]any{}
+	for service, fn := range stats {
+		data, err := fn(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s stats: %w", service, err)
+		}
+		services[service] = data
+	}
+	return services, nil
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"NbirdHttp/auth"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorLog(t *testing.T) {
+	errs := NewErrorLog(2)
+	logger := log.New(errs, "", log.LstdFlags)
+	logger.Printf("[INFO] started\n")
+	for i := 1; i <= 3; i++ {
+		logger.Printf("[ERROR] failure %d\n", i)
+	}
+
+	recent := errs.Recent()
+	if len(recent) != 2 {
+		t.Fatalf("got %d errors, want 2: %+v", len(recent), recent)
+	}
+	for i, want := range []string{"failure 3", "failure 2"} {
+		if recent[i].Message != want {
+			t.Errorf("recent[%d] = %q, want %q", i, recent[i].Message, want)
+		}
+	}
+}
+
+func TestOverviewRequiresAdmin(t *testing.T) {
+	auth.AUTH_FILE = filepath.Join(t.TempDir(), ".auth")
+	defer func() { auth.AUTH_FILE = "./auth/.auth" }()
+	os.WriteFile(auth.AUTH_FILE, []byte(fmt.Sprintf("worker,%x\n", sha256.Sum256([]byte("secret")))), 0600)
+	auth.SetAdmins([]string{"boss"})
+	defer auth.SetAdmins(nil)
+
+	tests := []struct {
+		name string
+		auth func(r *http.Request)
+		want int
+	}{
+		{"nobody", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"forged admin", func(r *http.Request) { r.Header.Set("Authorization", "Bearer boss") }, http.StatusUnauthorized},
+		{"worker", func(r *http.Request) { r.SetBasicAuth("worker", "secret") }, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/admin/overview", nil)
+		test.auth(r)
+		w := httptest.NewRecorder()
+		handleOverview(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
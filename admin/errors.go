@@ -0,0 +1,61 @@
+package admin
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogEntry is an error the server logged.
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// ErrorLog is an io.Writer for the log package that remembers the most
+// recent lines logged with [ERROR].
+type ErrorLog struct {
+	mu      sync.Mutex
+	entries []LogEntry // oldest first, at most size long
+	size    int
+}
+
+// NewErrorLog returns an ErrorLog that remembers the last size errors.
+func NewErrorLog(size int) *ErrorLog {
+	return &ErrorLog{size: size}
+}
+
+// Errors remembers the errors the server logs for the overview. main sends
+// the log output through it.
+var Errors = NewErrorLog(50)
+
+// Write records p if it is an error. The log package writes each line with
+// one call.
+func (l *ErrorLog) Write(p []byte) (int, error) {
+	line := string(p)
+	i := strings.Index(line, "[ERROR]")
+	if i < 0 {
+		return len(p), nil
+	}
+	// Drop the date and time the log package prefixed
+	message := strings.TrimSpace(line[i+len("[ERROR]"):])
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, LogEntry{Time: time.Now(), Message: message})
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+	return len(p), nil
+}
+
+// Recent returns the remembered errors, newest first.
+func (l *ErrorLog) Recent() []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	recent := make([]LogEntry, len(l.entries))
+	for i, entry := range l.entries {
+		recent[len(recent)-1-i] = entry
+	}
+	return recent
+}
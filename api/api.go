@@ -0,0 +1,175 @@
+// Package api keeps a registry of the server's /api/ routes so they can be
+// described in an OpenAPI document alongside being served.
+//
+// Routes are mounted under a version prefix, /api/v1/... or /api/v2/...
+// The unversioned /api/... paths predate versioning and remain as aliases of
+// v1, marked deprecated in favour of the versioned paths.
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Route describes an API endpoint. Request and Response hold a zero value of
+// the body's Go type (e.g. Book{} or []Sprint{}) and are used only for the
+// generated schema.
+type Route struct {
+	// API version the route belongs to, defaults to 1
+	Version int
+	Method  string
+	// Unversioned pattern, e.g. /api/books/{id}
+	Pattern string
+	// Service the route belongs to, used to group routes in the docs
+	Tag         string
+	Summary     string
+	Description string
+	Params      []Param
+	// Identifies the user with an `Authorization: Bearer <username>` header
+	BearerAuth bool
+	// Needs a signed in user, proven by the session cookie login sets or
+	// HTTP Basic credentials
+	SignedIn bool
+
+	Request      any
+	RequestTypes []string // defaults to application/json
+
+	Status       int // defaults to 200
+	Response     any
+	ResponseType string // defaults to application/json
+
+	Handler http.HandlerFunc
+}
+
+// Param is a query parameter or header a route reads.
+type Param struct {
+	Name        string
+	In          string // "query" or "header"
+	Description string
+	Required    bool
+}
+
+var (
+	mu     sync.Mutex
+	routes []Route
+	// Latest version registered for each "METHOD pattern"
+	latest = map[string]int{}
+)
+
+var versionPrefix = regexp.MustCompile(`^/api/v[0-9]+/`)
+
+// Path returns the pattern the route is served at, e.g. /api/v1/books/{id}.
+func (route Route) Path() string {
+	return versioned(route.Pattern, route.Version)
+}
+
+func versioned(path string, version int) string {
+	return fmt.Sprintf("/api/v%d/%s", version, strings.TrimPrefix(path, "/api/"))
+}
+
+// Unversioned strips the version prefix from an /api/ path, so
+// /api/v2/books/1 becomes /api/books/1.
+func Unversioned(path string) string {
+	if prefix := versionPrefix.FindString(path); prefix != "" {
+		return "/api/" + path[len(prefix):]
+	}
+	return path
+}
+
+// Deprecated reports whether a newer version of the route is registered.
+func (route Route) Deprecated() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return latest[route.Method+" "+route.Pattern] > route.Version
+}
+
+// Handle registers the route on http.DefaultServeMux and records it for the
+// OpenAPI document. Version 1 routes are also served at their unversioned
+// pattern.
+func Handle(route Route) {
+	if route.Version == 0 {
+		route.Version = 1
+	}
+	if route.Status == 0 {
+		route.Status = http.StatusOK
+	}
+	if route.Response != nil && route.ResponseType == "" {
+		route.ResponseType = "application/json"
+	}
+	if route.Request != nil && len(route.RequestTypes) == 0 {
+		route.RequestTypes = []string{"application/json"}
+	}
+
+	http.Handle(route.Method+" "+route.Path(), deprecation(route))
+	if route.Version == 1 {
+		http.Handle(route.Method+" "+route.Pattern, alias(route))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	routes = append(routes, route)
+	key := route.Method + " " + route.Pattern
+	latest[key] = max(latest[key], route.Version)
+}
+
+// Marks responses deprecated once a newer version of the route is registered,
+// linking to its successor
+func deprecation(route Route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		successor := latest[route.Method+" "+route.Pattern]
+		mu.Unlock()
+		if successor > route.Version {
+			markDeprecated(w, versioned(Unversioned(r.URL.Path), successor))
+		}
+		route.Handler(w, r)
+	})
+}
+
+// Serves an unversioned path as v1, pointing clients at the versioned path
+func alias(route Route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		markDeprecated(w, versioned(r.URL.Path, route.Version))
+		route.Handler(w, r)
+	})
+}
+
+// Sets the Deprecation header (RFC 9745) and a Link to the replacement
+func markDeprecated(w http.ResponseWriter, successor string) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+}
+
+// Routes returns every registered route ordered by path and method.
+func Routes() []Route {
+	mu.Lock()
+	defer mu.Unlock()
+
+	sorted := slices.Clone(routes)
+	slices.SortStableFunc(sorted, func(a, b Route) int {
+		if c := strings.Compare(a.Pattern, b.Pattern); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Method, b.Method); c != 0 {
+			return c
+		}
+		return a.Version - b.Version
+	})
+	return sorted
+}
+
+// Returns the names of the {wildcards} in a route pattern
+func pathParams(pattern string) []string {
+	var names []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			name = strings.TrimSuffix(name, "}")
+			names = append(names, strings.TrimSuffix(name, "..."))
+		}
+	}
+	return names
+}
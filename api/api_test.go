@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnversioned(t *testing.T) {
+	tests := map[string]string{
+		"/api/v1/books/1":   "/api/books/1",
+		"/api/v2/isbn/123":  "/api/isbn/123",
+		"/api/books":        "/api/books",
+		"/api/vintage/shop": "/api/vintage/shop",
+		"/index.html":       "/index.html",
+	}
+	for path, want := range tests {
+		if got := Unversioned(path); got != want {
+			t.Errorf("Unversioned(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestVersionedRoutes(t *testing.T) {
+	Handle(Route{
+		Method:  "GET",
+		Pattern: "/api/test-versions/{id}",
+		Handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("v1")) },
+	})
+	Handle(Route{
+		Version: 2,
+		Method:  "GET",
+		Pattern: "/api/test-versions/{id}",
+		Handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("v2")) },
+	})
+
+	tests := []struct {
+		path, body, link string
+	}{
+		{"/api/test-versions/7", "v1", `</api/v1/test-versions/7>; rel="successor-version"`},
+		{"/api/v1/test-versions/7", "v1", `</api/v2/test-versions/7>; rel="successor-version"`},
+		{"/api/v2/test-versions/7", "v2", ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+
+		if rr.Body.String() != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.path, rr.Body.String(), tt.body)
+		}
+		if got := rr.Header().Get("Link"); got != tt.link {
+			t.Errorf("%s: Link = %q, want %q", tt.path, got, tt.link)
+		}
+		if deprecated := rr.Header().Get("Deprecation") == "true"; deprecated != (tt.link != "") {
+			t.Errorf("%s: Deprecation = %q", tt.path, rr.Header().Get("Deprecation"))
+		}
+	}
+}
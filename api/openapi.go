@@ -0,0 +1,152 @@
+package api
+
+import (
+	"NbirdHttp/apierror"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Version of the API described by the OpenAPI document
+const Version = "1.0.0"
+
+type errorEnvelope struct {
+	Error apierror.Error `json:"error"`
+}
+
+// OpenAPI returns an OpenAPI 3 document describing every registered route.
+func OpenAPI() map[string]any {
+	b := &schemaBuilder{components: map[string]schema{}}
+	errorResponse := schema{
+		"description": "Error",
+		"content": schema{
+			"application/json": schema{"schema": b.schemaOf(errorEnvelope{})},
+		},
+	}
+
+	paths := map[string]schema{}
+	for _, route := range Routes() {
+		item, ok := paths[route.Path()]
+		if !ok {
+			item = schema{}
+			paths[route.Path()] = item
+		}
+		item[strings.ToLower(route.Method)] = b.operation(route, errorResponse)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":   "NbirdHttp API",
+			"version": Version,
+			"description": "Every v1 path is also served without its version prefix, " +
+				"e.g. /api/books for /api/v1/books. Those aliases respond with a " +
+				"Deprecation header and a Link to the versioned path.",
+		},
+		"paths": paths,
+		"components": schema{
+			"schemas": b.components,
+			"securitySchemes": schema{
+				"bearerUser": schema{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "The username of the logged in user",
+				},
+				"session": schema{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        "nbird_session",
+					"description": "Set by /api/v1/auth/login",
+				},
+				"basicPassword": schema{
+					"type":        "http",
+					"scheme":      "basic",
+					"description": "The user's username and password",
+				},
+			},
+		},
+	}
+}
+
+func (b *schemaBuilder) operation(route Route, errorResponse schema) schema {
+	var params []schema
+	for _, name := range pathParams(route.Pattern) {
+		params = append(params, schema{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   schema{"type": "string"},
+		})
+	}
+	for _, p := range route.Params {
+		params = append(params, schema{
+			"name":        p.Name,
+			"in":          p.In,
+			"description": p.Description,
+			"required":    p.Required,
+			"schema":      schema{"type": "string"},
+		})
+	}
+
+	success := schema{"description": http.StatusText(route.Status)}
+	if route.Response != nil {
+		success["content"] = schema{
+			route.ResponseType: schema{"schema": b.schemaOf(route.Response)},
+		}
+	}
+
+	op := schema{
+		"summary":   route.Summary,
+		"tags":      []string{route.Tag},
+		"responses": schema{strconv.Itoa(route.Status): success, "default": errorResponse},
+	}
+	if route.Description != "" {
+		op["description"] = route.Description
+	}
+	if route.Deprecated() {
+		op["deprecated"] = true
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if route.BearerAuth {
+		op["security"] = []schema{{"bearerUser": []string{}}}
+	}
+	if route.SignedIn {
+		op["security"] = []schema{{"session": []string{}}, {"basicPassword": []string{}}}
+	}
+	if route.Request != nil {
+		content := schema{}
+		for _, ctype := range route.RequestTypes {
+			content[ctype] = schema{"schema": b.schemaOf(route.Request)}
+		}
+		op["requestBody"] = schema{"required": true, "content": content}
+	}
+	return op
+}
+
+// DocsController serves the OpenAPI document and points to the docs page.
+func DocsController() {
+	Handle(Route{
+		Method:   "GET",
+		Pattern:  "/api/openapi.json",
+		Tag:      "docs",
+		Summary:  "OpenAPI document describing every API route",
+		Response: map[string]any{},
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(OpenAPI())
+		},
+	})
+	Handle(Route{
+		Method:  "GET",
+		Pattern: "/api/docs",
+		Tag:     "docs",
+		Summary: "Redirects to the interactive API docs page",
+		Status:  http.StatusFound,
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/api-docs.html", http.StatusFound)
+		},
+	})
+}
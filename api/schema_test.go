@@ -0,0 +1,60 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testAuthor struct {
+	Name string `json:"name"`
+}
+
+type testBook struct {
+	ID      int         `json:"id"`
+	Title   string      `json:"title"`
+	Pages   *int        `json:"pages"`
+	Author  *testAuthor `json:"author"`
+	Tags    []string    `json:"tags,omitempty"`
+	Added   time.Time   `json:"added"`
+	private string
+	Skipped string `json:"-"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	b := &schemaBuilder{components: map[string]schema{}}
+
+	ref := b.schemaOf([]testBook{})
+	want := schema{"type": "array", "items": schema{"$ref": "#/components/schemas/testBook"}}
+	if !reflect.DeepEqual(ref, want) {
+		t.Fatalf("schemaOf([]testBook) = %v, want %v", ref, want)
+	}
+
+	book := b.components["testBook"]
+	properties := book["properties"].(schema)
+	if len(properties) != 6 {
+		t.Errorf("got %d properties, want 6: %v", len(properties), properties)
+	}
+	if got := properties["pages"]; !reflect.DeepEqual(got, schema{"type": "integer", "nullable": true}) {
+		t.Errorf("pages = %v", got)
+	}
+	if got := properties["author"].(schema)["nullable"]; got != true {
+		t.Errorf("author should be nullable, got %v", properties["author"])
+	}
+	if got := properties["added"]; !reflect.DeepEqual(got, schema{"type": "string", "format": "date-time"}) {
+		t.Errorf("added = %v", got)
+	}
+	if want := []string{"id", "title", "added"}; !reflect.DeepEqual(book["required"], want) {
+		t.Errorf("required = %v, want %v", book["required"], want)
+	}
+	if _, ok := b.components["testAuthor"]; !ok {
+		t.Error("nested struct was not added to the components")
+	}
+}
+
+func TestPathParams(t *testing.T) {
+	got := pathParams("/api/books/{id}/notes/{rest...}")
+	if want := []string{"id", "rest"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pathParams = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+// Package apierror defines the error responses shared by every /api/ route:
+// a JSON envelope {"error": {"code", "message", "details"}} carrying a
+// machine-readable code alongside the message shown to users.
+package apierror
+
+import (
+	"NbirdHttp/negotiate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type Code string
+
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeValidationFailed Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeConflict         Code = "conflict"
+	CodeTooManyRequests  Code = "too_many_requests"
+	CodeInternal         Code = "internal"
+	CodeUpstreamFailed   Code = "upstream_failed"
+)
+
+type Error struct {
+	Status  int    `json:"-"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// WithDetails returns a copy of e carrying extra machine-readable context,
+// such as the fields that failed validation.
+func (e *Error) WithDetails(details any) *Error {
+	detailed := *e
+	detailed.Details = details
+	return &detailed
+}
+
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+func Validation(message string) *Error {
+	return New(http.StatusBadRequest, CodeValidationFailed, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, CodeConflict, message)
+}
+
+func TooManyRequests(message string) *Error {
+	return New(http.StatusTooManyRequests, CodeTooManyRequests, message)
+}
+
+func UpstreamFailed(message string) *Error {
+	return New(http.StatusBadGateway, CodeUpstreamFailed, message)
+}
+
+// Internal is reported in place of any error that isn't an *Error, so
+// causes like SQL errors are logged rather than shown to clients.
+func Internal() *Error {
+	return New(http.StatusInternalServerError, CodeInternal, "Internal server error.")
+}
+
+// Write responds with err. Errors other than *Error are logged and reported
+// as internal errors. Clients that prefer text/plain over JSON get just the
+// message.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		log.Printf("[ERROR] %s %s: %v\n", r.Method, r.URL.Path, err)
+		apiErr = Internal()
+	}
+
+	if negotiate.Preferred(r, "application/json", "text/plain") == "text/plain" {
+		http.Error(w, apiErr.Message, apiErr.Status)
+		return
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(map[string]*Error{"error": apiErr})
+}
+
+// UnmatchedRoutes wraps mux so /api/ requests that match no API route get
+// an error envelope instead of the mux's plain text 404 and 405 pages or the
+// static site.
+func UnmatchedRoutes(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		h, pattern := mux.Handler(r)
+		if strings.Contains(pattern, "/api/") {
+			// Served through the mux so the route's path values are set
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// Let the mux decide between 404 and 405 without sending anything
+		rec := &statusRecorder{header: http.Header{}, status: http.StatusNotFound}
+		if pattern == "" {
+			h.ServeHTTP(rec, r)
+		}
+		if rec.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", rec.header.Get("Allow"))
+			Write(w, r, New(http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+				fmt.Sprintf("Method %s is not allowed on %s.", r.Method, r.URL.Path)))
+			return
+		}
+		Write(w, r, NotFound(fmt.Sprintf("No API route matches %s.", r.URL.Path)))
+	})
+}
+
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *statusRecorder) Header() http.Header         { return rec.header }
+func (rec *statusRecorder) Write(p []byte) (int, error) { return len(p), nil }
+func (rec *statusRecorder) WriteHeader(code int)        { rec.status = code }
@@ -0,0 +1,115 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		accept     string
+		wantStatus int
+		wantCode   Code
+		wantBody   string
+	}{
+		{"api error", NotFound("Book not found"), "", http.StatusNotFound, CodeNotFound, ""},
+		{"wrapped api error", errors.Join(Conflict("Already punched in.")), "", http.StatusConflict, CodeConflict, ""},
+		{"internal error hidden", errors.New("sql: database is locked"), "", http.StatusInternalServerError, CodeInternal, ""},
+		{"plain text", Validation("Title is required"), "text/plain", http.StatusBadRequest, "", "Title is required\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/books/1", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			Write(rr, req, tt.err)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" {
+				if rr.Body.String() != tt.wantBody {
+					t.Errorf("body = %q, want %q", rr.Body.String(), tt.wantBody)
+				}
+				return
+			}
+
+			var envelope struct {
+				Error Error `json:"error"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+				t.Fatalf("invalid JSON body: %v", err)
+			}
+			if envelope.Error.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", envelope.Error.Code, tt.wantCode)
+			}
+			if strings.Contains(envelope.Error.Message, "sql") {
+				t.Errorf("internal error leaked to client: %q", envelope.Error.Message)
+			}
+		})
+	}
+}
+
+func TestWithDetails(t *testing.T) {
+	base := Validation("Title and author are required")
+	detailed := base.WithDetails(map[string][]string{"fields": {"title", "author"}})
+
+	if base.Details != nil {
+		t.Errorf("WithDetails modified the original error")
+	}
+
+	rr := httptest.NewRecorder()
+	Write(rr, httptest.NewRequest("POST", "/api/books", nil), detailed)
+	want := `{"error":{"code":"validation_failed","message":"Title and author are required","details":{"fields":["title","author"]}}}` + "\n"
+	if rr.Body.String() != want {
+		t.Errorf("body = %s, want %s", rr.Body.String(), want)
+	}
+}
+
+func TestUnmatchedRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/books", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /api/books/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PathValue("id")))
+	})
+	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("static"))
+	})
+	handler := UnmatchedRoutes(mux)
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantCode   Code
+	}{
+		{"GET", "/api/books", http.StatusOK, ""},
+		{"GET", "/index.html", http.StatusOK, ""},
+		{"GET", "/api/nope", http.StatusNotFound, CodeNotFound},
+		{"DELETE", "/api/books", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rr.Code, tt.wantStatus)
+		}
+		if tt.wantCode != "" && !strings.Contains(rr.Body.String(), string(tt.wantCode)) {
+			t.Errorf("%s %s: body = %s, want code %q", tt.method, tt.path, rr.Body.String(), tt.wantCode)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/books/42", nil))
+	if rr.Body.String() != "42" {
+		t.Errorf("path value = %q, want %q", rr.Body.String(), "42")
+	}
+}
@@ -1,25 +1,173 @@
 package auth
 
 import (
+	"NbirdHttp/api"
+	"NbirdHttp/apierror"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Visible for testing
 var AUTH_FILE = "./auth/.auth"
 
+// Users allowed to use the admin routes, set from the config file
+var admins []string
+
 var errUsernameTaken = errors.New("user already exists")
 var errUserNotFound = errors.New("user not found")
 
+// Cookie holding the session login starts, and how long a session lasts
+const (
+	sessionCookie = "nbird_session"
+	sessionTTL    = 7 * 24 * time.Hour
+)
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// Sessions are only kept in memory, so a restart signs everyone out
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[string]session{}
+)
+
+// Form fields accepted by register and login
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
 func AuthController() {
-	http.HandleFunc("POST /api/auth/register", registerHandler)
-	http.HandleFunc("POST /api/auth/login", loginHandler)
+	api.Handle(api.Route{
+		Method:       "POST",
+		Pattern:      "/api/auth/register",
+		Tag:          "auth",
+		Summary:      "Register a new user",
+		Request:      credentials{},
+		RequestTypes: []string{"application/x-www-form-urlencoded"},
+		Status:       http.StatusCreated,
+		Response:     "",
+		ResponseType: "text/plain",
+		Handler:      registerHandler,
+	})
+	api.Handle(api.Route{
+		Method:       "POST",
+		Pattern:      "/api/auth/login",
+		Tag:          "auth",
+		Summary:      "Check a user's password and start a session",
+		Description:  "Sets the session cookie that routes needing a signed in user accept, for 7 days.",
+		Request:      credentials{},
+		RequestTypes: []string{"application/x-www-form-urlencoded"},
+		Response:     "",
+		ResponseType: "text/plain",
+		Handler:      loginHandler,
+	})
+	api.Handle(api.Route{
+		Method:  "POST",
+		Pattern: "/api/auth/logout",
+		Tag:     "auth",
+		Summary: "End the session login started",
+		Status:  http.StatusNoContent,
+		Handler: logoutHandler,
+	})
+}
+
+// RequestUser returns the username a request is made on behalf of, or "" if
+// it doesn't name one. QuickPen sends `Authorization: Bearer <username>`
+// and Punch Clock sends a `user` query parameter. Anyone can send any name,
+// so routes that guard other users' data use VerifiedUser instead.
+func RequestUser(r *http.Request) string {
+	if user, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && user != "" {
+		return user
+	}
+	return r.URL.Query().Get("user")
+}
+
+// VerifiedUser returns the user a request proves it is made by, with the
+// session cookie login sets or HTTP Basic credentials, or "" if it proves
+// nothing.
+func VerifiedUser(r *http.Request) string {
+	if uname, pswd, ok := r.BasicAuth(); ok {
+		if authenticated, _ := authenticate(uname, pswd); authenticated {
+			return uname
+		}
+		return ""
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		return sessionUser(cookie.Value)
+	}
+	return ""
+}
+
+// RequireUser returns the verified user a request is made by, or an error
+// to respond with if it doesn't prove one.
+func RequireUser(r *http.Request) (string, error) {
+	user := VerifiedUser(r)
+	if user == "" {
+		return "", apierror.Unauthorized("Sign in required")
+	}
+	return user, nil
+}
+
+// SetAdmins sets the users allowed to use the admin routes.
+func SetAdmins(users []string) {
+	admins = users
+}
+
+// RequireAdmin returns the verified user a request is made by if they are
+// an admin, or an error to respond with if not.
+func RequireAdmin(r *http.Request) (string, error) {
+	user, err := RequireUser(r)
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(admins, user) {
+		return "", apierror.Forbidden("Admin access required")
+	}
+	return user, nil
+}
+
+// Starts a session for user, returning its token
+func newSession(user string) string {
+	token := make([]byte, 32)
+	rand.Read(token)
+	id := hex.EncodeToString(token)
+
+	now := time.Now()
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for id, s := range sessions {
+		if now.After(s.expires) {
+			delete(sessions, id)
+		}
+	}
+	sessions[id] = session{user: user, expires: now.Add(sessionTTL)}
+	return id
+}
+
+// Returns the user of the session with the token, or "" if it has ended
+func sessionUser(token string) string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s, ok := sessions[token]
+	if !ok || time.Now().After(s.expires) {
+		return ""
+	}
+	return s.user
 }
 
 // Returns the line [uname, pswd] in auth file where uname matches
@@ -88,10 +236,10 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	if err := createUser(uname, pswd); err != nil {
 		log.Printf("[ERROR] %v\n", err)
 		if err == errUsernameTaken {
-			http.Error(w, fmt.Sprintf("Username `%s` is already taken. Please try a different one.", uname), http.StatusBadRequest)
+			apierror.Write(w, r, apierror.Conflict(fmt.Sprintf("Username `%s` is already taken. Please try a different one.", uname)))
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
@@ -107,17 +255,36 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
 		if err == errUserNotFound {
-			http.Error(w, fmt.Sprintf("User `%s` has not been created.", uname), http.StatusUnauthorized)
+			apierror.Write(w, r, apierror.Unauthorized(fmt.Sprintf("User `%s` has not been created.", uname)))
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 	if !authenticated {
-		http.Error(w, fmt.Sprintf("Incorrect password for `%s`.", uname), http.StatusUnauthorized)
+		apierror.Write(w, r, apierror.Unauthorized(fmt.Sprintf("Incorrect password for `%s`.", uname)))
 		return
 	}
 
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    newSession(uname),
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "Login successful.")
 }
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		sessionsMu.Lock()
+		delete(sessions, cookie.Value)
+		sessionsMu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	w.WriteHeader(http.StatusNoContent)
+}
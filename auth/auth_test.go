@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"NbirdHttp/apierror"
 	"crypto/sha256"
 	"fmt"
 	"net/http"
@@ -106,7 +107,7 @@ func TestRegisterHandler(t *testing.T) {
 		expectedBody string
 	}{
 		{"user1", "pass1", http.StatusCreated, "User `user1` registered successfully.\n"},
-		{"user1", "pass2", http.StatusBadRequest, "Username `user1` is already taken. Please try a different one.\n"},
+		{"user1", "pass2", http.StatusConflict, `{"error":{"code":"conflict","message":"Username ` + "`user1`" + ` is already taken. Please try a different one."}}` + "\n"},
 	}
 
 	for _, test := range tests {
@@ -144,8 +145,8 @@ func TestLoginHandler(t *testing.T) {
 		expectedBody string
 	}{
 		{"user1", "pass1", http.StatusOK, "Login successful.\n"},
-		{"user1", "wrongpass", http.StatusUnauthorized, "Incorrect password for `user1`.\n"},
-		{"user2", "pass2", http.StatusUnauthorized, "User `user2` has not been created.\n"},
+		{"user1", "wrongpass", http.StatusUnauthorized, `{"error":{"code":"unauthorized","message":"Incorrect password for ` + "`user1`" + `."}}` + "\n"},
+		{"user2", "pass2", http.StatusUnauthorized, `{"error":{"code":"unauthorized","message":"User ` + "`user2`" + ` has not been created."}}` + "\n"},
 	}
 
 	for i, test := range tests {
@@ -183,3 +184,74 @@ func equal(a, b []string) bool {
 	}
 	return true
 }
+
+func TestRequireAdmin(t *testing.T) {
+	setupTestAuthFile(fmt.Sprintf("admin,%x\nuser1,%x\n", sha256.Sum256([]byte("pass1")), sha256.Sum256([]byte("pass2"))))
+	defer teardownTestAuthFile()
+	SetAdmins([]string{"admin"})
+	defer SetAdmins(nil)
+
+	tests := []struct {
+		name     string
+		auth     func(r *http.Request)
+		expected int
+	}{
+		{"admin password", func(r *http.Request) { r.SetBasicAuth("admin", "pass1") }, 0},
+		{"admin session", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: newSession("admin")})
+		}, 0},
+		{"user password", func(r *http.Request) { r.SetBasicAuth("user1", "pass2") }, http.StatusForbidden},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("admin", "pass2") }, http.StatusUnauthorized},
+		{"forged bearer name", func(r *http.Request) { r.Header.Set("Authorization", "Bearer admin") }, http.StatusUnauthorized},
+		{"forged user parameter", func(r *http.Request) { r.URL.RawQuery = "user=admin" }, http.StatusUnauthorized},
+		{"unknown session", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "admin"})
+		}, http.StatusUnauthorized},
+		{"nothing", func(r *http.Request) {}, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/api/admin/jobs", nil)
+		test.auth(req)
+		rr := httptest.NewRecorder()
+		user, err := RequireAdmin(req)
+		if err != nil {
+			apierror.Write(rr, req, err)
+		}
+		if test.expected == 0 && (err != nil || user != "admin") {
+			t.Errorf("%s: expected admin to be allowed, got %q, %v", test.name, user, err)
+		}
+		if test.expected != 0 && rr.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expected, rr.Code)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	setupTestAuthFile(fmt.Sprintf("user1,%x\n", sha256.Sum256([]byte("pass1"))))
+	defer teardownTestAuthFile()
+
+	req := httptest.NewRequest("POST", "/api/auth/login", nil)
+	req.Form = map[string][]string{"username": {"user1"}, "password": {"pass1"}}
+	rr := httptest.NewRecorder()
+	loginHandler(rr, req)
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("login set cookies %+v, want an HttpOnly session cookie", cookies)
+	}
+
+	req = httptest.NewRequest("GET", "/api/books", nil)
+	req.AddCookie(cookies[0])
+	if user := VerifiedUser(req); user != "user1" {
+		t.Errorf("VerifiedUser() = %q with the session, want user1", user)
+	}
+
+	rr = httptest.NewRecorder()
+	logoutHandler(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("logout status = %d, want 204", rr.Code)
+	}
+	if user := VerifiedUser(req); user != "" {
+		t.Errorf("VerifiedUser() = %q after logout, want none", user)
+	}
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+var errUsernameRequired = errors.New("username is required")
+
+// Users returns the registered usernames in the order they registered.
+func Users() ([]string, error) {
+	records, err := readUsers()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]string, len(records))
+	for i, record := range records {
+		users[i] = record[0]
+	}
+	return users, nil
+}
+
+// IsAdmin reports whether user may use the admin routes.
+func IsAdmin(user string) bool {
+	return slices.Contains(admins, user)
+}
+
+// AddUser registers a user, as the register route does.
+func AddUser(uname, pswd string) error {
+	if uname == "" {
+		return errUsernameRequired
+	}
+	return createUser(uname, pswd)
+}
+
+// DeleteUser removes a user. Their punch clock and sprints are kept.
+func DeleteUser(uname string) error {
+	records, err := readUsers()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(records, func(record []string) bool { return record[0] == uname })
+	if i < 0 {
+		return errUserNotFound
+	}
+	return writeUsers(slices.Delete(records, i, i+1))
+}
+
+// SetPassword changes a user's password.
+func SetPassword(uname, pswd string) error {
+	records, err := readUsers()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(records, func(record []string) bool { return record[0] == uname })
+	if i < 0 {
+		return errUserNotFound
+	}
+	records[i][1] = hashPassword(pswd)
+	return writeUsers(records)
+}
+
+// Returns every [uname, pswd] line in the auth file
+func readUsers() ([][]string, error) {
+	file, err := os.Open(AUTH_FILE)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return csv.NewReader(file).ReadAll()
+}
+
+// Replaces the auth file with records
+func writeUsers(records [][]string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(AUTH_FILE), ".auth-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := csv.NewWriter(tmp)
+	writer.WriteAll(records)
+	if err := writer.Error(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), AUTH_FILE)
+}
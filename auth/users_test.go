@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestManageUsers(t *testing.T) {
+	setupTestAuthFile("")
+	defer teardownTestAuthFile()
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := AddUser(user, user+"-password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AddUser("bob", "again"); err != errUsernameTaken {
+		t.Errorf("adding bob twice: err = %v, want errUsernameTaken", err)
+	}
+	if err := AddUser("", "password"); err != errUsernameRequired {
+		t.Errorf("adding no username: err = %v, want errUsernameRequired", err)
+	}
+
+	if err := DeleteUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteUser("bob"); err != errUserNotFound {
+		t.Errorf("deleting bob twice: err = %v, want errUserNotFound", err)
+	}
+	users, err := Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(users, []string{"alice", "carol"}) {
+		t.Errorf("users = %v", users)
+	}
+
+	if err := SetPassword("carol", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := authenticate("carol", "changed"); !ok {
+		t.Error("carol can't log in with the new password")
+	}
+	if ok, _ := authenticate("alice", "alice-password"); !ok {
+		t.Error("alice's password changed")
+	}
+	if err := SetPassword("bob", "changed"); err != errUserNotFound {
+		t.Errorf("changing bob's password: err = %v, want errUserNotFound", err)
+	}
+}
@@ -0,0 +1,286 @@
+// Package backup archives the server's data files and databases and restores
+// them from those archives.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Options configures scheduled backups.
+type Options struct {
+	// Directory archives are written to
+	Dir string `json:"dir"`
+	// Number of archives to keep; older ones are deleted after each backup.
+	// 0 keeps every archive.
+	Keep int `json:"keep"`
+	// Cron schedule for automatic backups, empty to disable them
+	Schedule string `json:"schedule"`
+}
+
+var DefaultOptions = Options{
+	Dir:      "./backups",
+	Keep:     14,
+	Schedule: "0 4 * * *",
+}
+
+// A piece of server data, relative to the server's working directory
+type source struct {
+	path string // slash separated; a file, directory or glob pattern
+	glob bool
+	// Copied with VACUUM INTO so the snapshot is consistent while the
+	// server is writing to it
+	sqlite bool
+}
+
+var sources = []source{
+	{path: "auth/.auth"},
+	{path: "punch/.punch_clock_*", glob: true},
+	{path: "quick-pen/.sprints.d"},
+	{path: "books/data/books.db", sqlite: true},
+	{path: "books/covers"},
+	{path: "webhooks/data/webhooks.db", sqlite: true},
+	{path: "scheduler/data/jobs.json"},
+}
+
+// Reports whether an archived file belongs to the source
+func (s source) contains(name string) bool {
+	if s.glob {
+		ok, _ := path.Match(s.path, name)
+		return ok
+	}
+	return name == s.path || strings.HasPrefix(name, s.path+"/")
+}
+
+// Manifest lists the files in an archive. It is the archive's last entry.
+type Manifest struct {
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+const manifestName = "manifest.json"
+
+// Archives are named for the time they were made so they sort oldest first
+const archivePrefix, archiveSuffix = "nbirdhttp-", ".tar.gz"
+
+// Create writes an archive of the data under root to a new timestamped file
+// in dir and returns its path.
+func Create(ctx context.Context, root, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	name := filepath.Join(dir, archivePrefix+now.Format("2006-01-02-150405")+archiveSuffix)
+	// Written under a temporary name so a failed backup never looks complete
+	tmp, err := os.CreateTemp(dir, ".backup-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// SQLite snapshots are staged here before being archived
+	staging, err := os.MkdirTemp("", "nbirdhttp-backup-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	manifest := Manifest{CreatedAt: now.UTC()}
+
+	for _, src := range sources {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		files, err := src.files(root)
+		if err != nil {
+			return "", fmt.Errorf("backing up %s: %w", src.path, err)
+		}
+		for _, file := range files {
+			diskPath := filepath.Join(root, filepath.FromSlash(file))
+			if src.sqlite {
+				diskPath = filepath.Join(staging, strings.ReplaceAll(file, "/", "_"))
+				if err := snapshotSQLite(ctx, filepath.Join(root, filepath.FromSlash(file)), diskPath); err != nil {
+					return "", fmt.Errorf("backing up %s: %w", file, err)
+				}
+			}
+			entry, err := addFile(tw, file, diskPath)
+			if err != nil {
+				return "", fmt.Errorf("backing up %s: %w", file, err)
+			}
+			manifest.Files = append(manifest.Files, entry)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0644, Size: int64(len(data)), ModTime: now})
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		return "", fmt.Errorf("writing archive: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Returns the slash separated paths of the source's files under root
+func (s source) files(root string) ([]string, error) {
+	if s.glob {
+		matches, err := filepath.Glob(filepath.Join(root, filepath.FromSlash(s.path)))
+		if err != nil {
+			return nil, err
+		}
+		var files []string
+		for _, match := range matches {
+			rel, _ := filepath.Rel(root, match)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		slices.Sort(files)
+		return files, nil
+	}
+
+	info, err := os.Stat(filepath.Join(root, filepath.FromSlash(s.path)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{s.path}, nil
+	}
+
+	var files []string
+	err = fs.WalkDir(os.DirFS(root), s.path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, name)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Copies a live SQLite database to dst as a consistent snapshot
+func snapshotSQLite(ctx context.Context, src, dst string) error {
+	db, err := sql.Open("sqlite", src)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, "VACUUM INTO ?", dst)
+	return err
+}
+
+// Writes the file at diskPath to the archive as name
+func addFile(tw *tar.Writer, name, diskPath string) (ManifestFile, error) {
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ManifestFile{}, err
+	}
+
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()})
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	hash := sha256.New()
+	// A file that grew while being copied is cut at its size when opened
+	n, err := io.Copy(tw, io.TeeReader(io.LimitReader(f, info.Size()), hash))
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	if n != info.Size() {
+		return ManifestFile{}, fmt.Errorf("file shrank while being copied")
+	}
+	return ManifestFile{Path: name, Size: n, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// Prune deletes all but the newest keep archives in dir and returns the
+// paths it removed. keep <= 0 removes nothing.
+func Prune(dir string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	archives, err := List(dir)
+	if err != nil || len(archives) <= keep {
+		return nil, err
+	}
+
+	var removed []string
+	for _, archive := range archives[:len(archives)-keep] {
+		if err := os.Remove(archive); err != nil {
+			return removed, err
+		}
+		removed = append(removed, archive)
+	}
+	return removed, nil
+}
+
+// List returns the paths of the archives in dir, oldest first.
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, archivePrefix) && strings.HasSuffix(name, archiveSuffix) {
+			archives = append(archives, filepath.Join(dir, name))
+		}
+	}
+	return archives, nil
+}
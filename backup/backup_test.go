@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, root, name, data string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, root, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Creates the books database with one title
+func writeBooksDB(t *testing.T, root, title string) {
+	t.Helper()
+	os.MkdirAll(filepath.Join(root, "books", "data"), 0755)
+	db, err := sql.Open("sqlite", filepath.Join(root, "books", "data", "books.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec("DROP TABLE IF EXISTS books; CREATE TABLE books (title TEXT); INSERT INTO books VALUES (?)", title)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func booksTitle(t *testing.T, root string) string {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(root, "books", "data", "books.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var title string
+	if err := db.QueryRow("SELECT title FROM books").Scan(&title); err != nil {
+		t.Fatal(err)
+	}
+	return title
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "auth/.auth", "alice:hash\n")
+	writeFile(t, root, "punch/.punch_clock_alice", "in\n")
+	writeFile(t, root, "quick-pen/.sprints.d/alice", "[]")
+	writeFile(t, root, "books/covers/1.jpg", "jpeg")
+	writeFile(t, root, "unrelated.txt", "not backed up")
+	writeBooksDB(t, root, "Dune")
+
+	archive, err := Create(context.Background(), root, filepath.Join(root, "backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := Validate(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 5 {
+		t.Errorf("archived %d files, want 5: %+v", len(manifest.Files), manifest.Files)
+	}
+
+	// Change the data, then restore it from the archive
+	writeFile(t, root, "auth/.auth", "mallory:hash\n")
+	writeFile(t, root, "punch/.punch_clock_mallory", "in\n")
+	writeFile(t, root, "books/data/books.db-wal", "stale journal")
+	writeBooksDB(t, root, "Emma")
+
+	aside, err := Restore(archive, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, root, "auth/.auth"); got != "alice:hash\n" {
+		t.Errorf("auth = %q", got)
+	}
+	if got := booksTitle(t, root); got != "Dune" {
+		t.Errorf("book title = %q, want Dune", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "punch", ".punch_clock_mallory")); !os.IsNotExist(err) {
+		t.Errorf("punch clock not in the archive was left in place")
+	}
+	if _, err := os.Stat(filepath.Join(root, "books", "data", "books.db-wal")); !os.IsNotExist(err) {
+		t.Errorf("journal of the replaced database was left in place")
+	}
+	if got := readFile(t, root, "unrelated.txt"); got != "not backed up" {
+		t.Errorf("unrelated file = %q", got)
+	}
+	if got := readFile(t, aside, "auth/.auth"); got != "mallory:hash\n" {
+		t.Errorf("replaced auth file = %q", got)
+	}
+}
+
+// Writes an archive holding the given files and no other checks
+func writeArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nbirdhttp-test.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(data))
+	}
+	tw.Close()
+	gz.Close()
+	return path
+}
+
+func TestValidateRejects(t *testing.T) {
+	manifest := `{"files":[{"path":"auth/.auth","size":4,"sha256":"0000"}]}`
+	tests := map[string]map[string]string{
+		"missing manifest": {"auth/.auth": "data"},
+		"wrong checksum":   {"auth/.auth": "data", "manifest.json": manifest},
+		"escaping path":    {"../auth/.auth": "data", "manifest.json": manifest},
+		"unknown file":     {"etc/passwd": "data", "manifest.json": manifest},
+		"corrupt database": {"books/data/books.db": "not sqlite", "manifest.json": `{"files":[]}`},
+	}
+	for name, files := range tests {
+		if _, err := Validate(writeArchive(t, files)); err == nil {
+			t.Errorf("%s: archive was accepted", name)
+		}
+	}
+
+	root := t.TempDir()
+	writeFile(t, root, "auth/.auth", "live")
+	if _, err := Restore(writeArchive(t, tests["wrong checksum"]), root); err == nil {
+		t.Fatal("invalid archive was restored")
+	}
+	if got := readFile(t, root, "auth/.auth"); got != "live" {
+		t.Errorf("live data changed to %q", got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"nbirdhttp-2026-01-01-040000.tar.gz",
+		"nbirdhttp-2026-01-02-040000.tar.gz",
+		"nbirdhttp-2026-01-03-040000.tar.gz",
+		"notes.txt",
+	}
+	for _, name := range names {
+		writeFile(t, dir, name, "")
+	}
+
+	removed, err := Prune(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || !strings.HasSuffix(removed[0], names[0]) {
+		t.Errorf("removed %v, want the oldest archive", removed)
+	}
+	archives, _ := List(dir)
+	if len(archives) != 2 {
+		t.Errorf("%d archives left, want 2", len(archives))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("non-archive file was removed: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package backup
+
+import (
+	"NbirdHttp/scheduler"
+	"context"
+	"log"
+)
+
+// ScheduleBackups registers a job that backs up the server's data on
+// opts.Schedule and prunes old archives.
+func ScheduleBackups(opts Options) {
+	if opts.Schedule == "" {
+		return
+	}
+	scheduler.Register("backup.create", opts.Schedule, func(ctx context.Context) error {
+		archive, err := Create(ctx, ".", opts.Dir)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] backup: wrote %s\n", archive)
+
+		removed, err := Prune(opts.Dir, opts.Keep)
+		for _, old := range removed {
+			log.Printf("[INFO] backup: removed %s\n", old)
+		}
+		return err
+	})
+}
@@ -0,0 +1,254 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Validate checks an archive is complete and uncorrupted: every file is one
+// the server stores, matches the size and checksum in the manifest, and every
+// database passes SQLite's integrity check.
+func Validate(archive string) (*Manifest, error) {
+	var manifest *Manifest
+	seen := map[string]ManifestFile{}
+
+	err := readArchive(archive, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name == manifestName {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(r).Decode(manifest); err != nil {
+				return fmt.Errorf("reading manifest: %w", err)
+			}
+			return nil
+		}
+		if manifest != nil {
+			return fmt.Errorf("%s comes after the manifest", hdr.Name)
+		}
+		src, err := sourceOf(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[hdr.Name]; ok {
+			return fmt.Errorf("%s is in the archive twice", hdr.Name)
+		}
+
+		hash := sha256.New()
+		var body io.Reader = io.TeeReader(r, hash)
+		if src.sqlite {
+			if err := checkSQLite(body); err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+		}
+		// The tar reader fails unless the entry is exactly hdr.Size long
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return err
+		}
+		seen[hdr.Name] = ManifestFile{Path: hdr.Name, Size: hdr.Size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest == nil {
+		return nil, errors.New("archive has no manifest")
+	}
+	for _, want := range manifest.Files {
+		got, ok := seen[want.Path]
+		if !ok {
+			return nil, fmt.Errorf("%s is in the manifest but missing from the archive", want.Path)
+		}
+		if got != want {
+			return nil, fmt.Errorf("%s does not match the manifest", want.Path)
+		}
+		delete(seen, want.Path)
+	}
+	for name := range seen {
+		return nil, fmt.Errorf("%s is not in the manifest", name)
+	}
+	return manifest, nil
+}
+
+// Returns the source an archived file belongs to, rejecting names that
+// could escape the data directories
+func sourceOf(name string) (source, error) {
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name || strings.HasPrefix(name, "../") {
+		return source{}, fmt.Errorf("unsafe path %q in archive", name)
+	}
+	for _, src := range sources {
+		if src.contains(name) {
+			return src, nil
+		}
+	}
+	return source{}, fmt.Errorf("unexpected file %q in archive", name)
+}
+
+// Copies a database to a temporary file and runs SQLite's integrity check
+func checkSQLite(r io.Reader) error {
+	tmp, err := os.CreateTemp("", "nbirdhttp-restore-*.db")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("not a valid database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("database is corrupt: %s", result)
+	}
+	return nil
+}
+
+// Calls fn with each regular file in the archive
+func readArchive(archive string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected %q entry %s in archive", hdr.Typeflag, hdr.Name)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Restore validates the archive, then replaces the data under root with its
+// contents. The data being replaced is moved to a .pre-restore-<time>
+// directory under root rather than deleted, and its path returned, or "" if
+// there was nothing to replace. The server must be stopped while restoring.
+func Restore(archive, root string) (string, error) {
+	manifest, err := Validate(archive)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive: %w", err)
+	}
+
+	stamp := time.Now().Format("2006-01-02-150405")
+	staging := filepath.Join(root, ".restore-"+stamp)
+	aside := filepath.Join(root, ".pre-restore-"+stamp)
+	defer os.RemoveAll(staging)
+
+	// Unpack everything before touching the live data
+	err = readArchive(archive, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name == manifestName {
+			return nil
+		}
+		dst := filepath.Join(staging, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(dst, hdr.ModTime, hdr.ModTime)
+	})
+	if err != nil {
+		return "", fmt.Errorf("unpacking archive: %w", err)
+	}
+
+	movedAside := ""
+	for _, src := range sources {
+		live, err := src.livePaths(root)
+		if err != nil {
+			return movedAside, err
+		}
+		for _, name := range live {
+			if err := move(filepath.Join(root, name), filepath.Join(aside, name)); err != nil {
+				return movedAside, fmt.Errorf("moving aside %s: %w", name, err)
+			}
+			movedAside = aside
+		}
+	}
+	for _, file := range manifest.Files {
+		name := filepath.FromSlash(file.Path)
+		if err := move(filepath.Join(staging, name), filepath.Join(root, name)); err != nil {
+			return movedAside, fmt.Errorf("restoring %s: %w", file.Path, err)
+		}
+	}
+	return movedAside, nil
+}
+
+// Returns the paths under root, relative to it, that restoring the source
+// replaces. A database's journal files go with it; they would otherwise be
+// replayed over the restored copy.
+func (s source) livePaths(root string) ([]string, error) {
+	if s.glob {
+		files, err := s.files(root)
+		for i, file := range files {
+			files[i] = filepath.FromSlash(file)
+		}
+		return files, err
+	}
+
+	candidates := []string{s.path}
+	if s.sqlite {
+		candidates = append(candidates, s.path+"-wal", s.path+"-shm", s.path+"-journal")
+	}
+	var paths []string
+	for _, candidate := range candidates {
+		name := filepath.FromSlash(candidate)
+		if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+			paths = append(paths, name)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+func move(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
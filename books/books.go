@@ -1,7 +1,16 @@
 package books
 
 import (
+	"NbirdHttp/admin"
+	"NbirdHttp/api"
+	"NbirdHttp/apierror"
+	"NbirdHttp/auth"
+	"NbirdHttp/lifecycle"
+	"NbirdHttp/scheduler"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,20 +18,61 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Book struct {
-	ID         int      `json:"id"`
-	Title      string   `json:"title"`
-	Author     string   `json:"author"`
-	Genre      *string  `json:"genre"`
-	ReadStatus string   `json:"read_status"`
-	CoverImage *string  `json:"cover_image"`
-	IsSigned   bool     `json:"is_signed"`
-	Tags       []string `json:"tags"`
-	CreatedAt  string   `json:"created_at"`
+	ID     int     `json:"id"`
+	Title  string  `json:"title"`
+	Author string  `json:"author"`
+	Genre  *string `json:"genre"`
+	// Details from an ISBN lookup, or null if unknown
+	ISBN        *string  `json:"isbn"`
+	Subtitle    *string  `json:"subtitle"`
+	Publisher   *string  `json:"publisher"`
+	PublishDate *string  `json:"publish_date"`
+	Pages       *int     `json:"pages"`
+	Language    *string  `json:"language"`
+	Description *string  `json:"description"`
+	ReadStatus  string   `json:"read_status"`
+	CoverImage  *string  `json:"cover_image"`
+	IsSigned    bool     `json:"is_signed"`
+	Tags        []string `json:"tags"`
+	CreatedAt   string   `json:"created_at"`
+	LibraryID   int      `json:"library_id"`
+	// 1 to 5 stars, or null if unrated
+	Rating *int `json:"rating"`
+	// With a q= search, the best matching text as HTML, with the matching
+	// words in <mark> tags
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// Fields accepted when creating or updating a book
+type bookInput struct {
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	Genre      string `json:"genre,omitempty"`
+	ReadStatus string `json:"read_status,omitempty"`
+	IsSigned   bool   `json:"is_signed,omitempty"`
+	// JSON array of tags, e.g. ["fantasy","favorite"]
+	Tags string `json:"tags,omitempty"`
+	// Uploaded image file, or the path from an ISBN lookup
+	CoverImage string `json:"cover_image,omitempty"`
+	// Library to add the book to, or move it to (default the user's own)
+	LibraryID int `json:"library_id,omitempty"`
+	// 1 to 5 stars; 0 clears the rating
+	Rating int `json:"rating,omitempty"`
+	// ISBN-10 or ISBN-13, saved as the ISBN-13; a library holds one book
+	// per ISBN
+	ISBN        string `json:"isbn,omitempty"`
+	Subtitle    string `json:"subtitle,omitempty"`
+	Publisher   string `json:"publisher,omitempty"`
+	PublishDate string `json:"publish_date,omitempty"`
+	Pages       int    `json:"pages,omitempty"`
+	Language    string `json:"language,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
 var coversDir string
@@ -34,139 +84,346 @@ func init() {
 	}
 }
 
+// Query parameters of the book listings
+var listParams = []api.Param{
+	{Name: "library", In: "query", Description: "Only books in this library"},
+	{Name: "q", In: "query", Description: `Words to search for in any order; "quoted words" match a phrase and tolk* matches a prefix`},
+	{Name: "search", In: "query", Description: "Matches part of the title or author (deprecated, use q)"},
+	{Name: "genre", In: "query"},
+	{Name: "read_status", In: "query"},
+	{Name: "is_signed", In: "query", Description: "true or false"},
+	{Name: "tag", In: "query"},
+	{Name: "isbn", In: "query", Description: "ISBN-10 or ISBN-13, with or without dashes"},
+	{Name: "publisher", In: "query"},
+	{Name: "language", In: "query"},
+	{Name: "sort", In: "query", Description: "Comma separated keys, each descending with a leading -, e.g. -rating,title. " +
+		"Keys: title, author, created_at, read_status, rating, pages, and relevance with q"},
+	{Name: "fields", In: "query", Description: "Comma separated fields to include in each book, e.g. id,title,author (default all)"},
+}
+
 func BooksController() {
+	if err := Migrate(context.Background()); err != nil {
+		log.Printf("[ERROR] Failed to migrate books database: %v\n", err)
+	}
+
 	// Serve cover images
 	http.HandleFunc("GET /books/covers/", serveCoverImage)
 
 	// API routes
-	http.HandleFunc("GET /api/books", handleListBooks)
-	http.HandleFunc("GET /api/books/{id}", handleGetBook)
-	http.HandleFunc("POST /api/books", handleCreateBook)
-	http.HandleFunc("PUT /api/books/{id}", handleUpdateBook)
-	http.HandleFunc("DELETE /api/books/{id}", handleDeleteBook)
-	http.HandleFunc("GET /api/books/meta/tags", handleGetTags)
-	http.HandleFunc("GET /api/books/meta/genres", handleGetGenres)
+	api.Handle(api.Route{
+		Method:  "GET",
+		Pattern: "/api/books",
+		Tag:     "books",
+		Summary: "List books in the user's libraries, newest first, optionally filtered",
+		Description: "With q, books are searched by title, author, genre and tags, most relevant first, " +
+			"and each has a snippet of the text that matched.",
+		SignedIn: true,
+		Params:   listParams,
+		Response: []Book{},
+		Handler:  handleListBooks,
+	})
+	api.Handle(api.Route{
+		Version: 2,
+		Method:  "GET",
+		Pattern: "/api/books",
+		Tag:     "books",
+		Summary: "List a page of books in the user's libraries, newest first, optionally filtered",
+		Description: "Responds with a page of at most limit books and the total matching the filters. " +
+			"Pass meta.next_cursor back as cursor, with the same sort, for the next page. " +
+			"With q, books are searched by title, author, genre and tags, most relevant first, " +
+			"and each has a snippet of the text that matched.",
+		SignedIn: true,
+		Params: append(listParams,
+			api.Param{Name: "limit", In: "query", Description: fmt.Sprintf("Books per page (default %d, at most %d)", defaultPageSize, maxPageSize)},
+			api.Param{Name: "cursor", In: "query", Description: "next_cursor from the previous page"},
+		),
+		Response: BookPage{},
+		Handler:  handleListBooksV2,
+	})
+	api.Handle(api.Route{
+		Method:   "GET",
+		Pattern:  "/api/books/{id}",
+		Tag:      "books",
+		Summary:  "Get a book",
+		SignedIn: true,
+		Response: Book{},
+		Handler:  handleGetBook,
+	})
+	api.Handle(api.Route{
+		Method:   "POST",
+		Pattern:  "/api/books",
+		Tag:      "books",
+		Summary:  "Add a book",
+		SignedIn: true,
+		Description: "Send multipart/form-data to upload a cover image, or JSON with the cover path returned by an ISBN lookup. " +
+			"Responds with a conflict if the library already has a book with the ISBN.",
+		Request:      bookInput{},
+		RequestTypes: []string{"multipart/form-data", "application/json"},
+		Status:       http.StatusCreated,
+		Response:     Book{},
+		Handler:      handleCreateBook,
+	})
+	api.Handle(api.Route{
+		Method:       "PUT",
+		Pattern:      "/api/books/{id}",
+		Tag:          "books",
+		Summary:      "Update a book; omitted fields keep their values",
+		Description:  "Book details (isbn, subtitle, publisher, publish_date, pages, language, description) sent empty are cleared.",
+		SignedIn:     true,
+		Request:      bookInput{},
+		RequestTypes: []string{"multipart/form-data"},
+		Response:     Book{},
+		Handler:      handleUpdateBook,
+	})
+	api.Handle(api.Route{
+		Method:   "DELETE",
+		Pattern:  "/api/books/{id}",
+		Tag:      "books",
+		Summary:  "Delete a book and its cover image, unless another book uses it",
+		SignedIn: true,
+		Status:   http.StatusNoContent,
+		Handler:  handleDeleteBook,
+	})
+	api.Handle(api.Route{
+		Method:   "GET",
+		Pattern:  "/api/books/meta/tags",
+		Tag:      "books",
+		Summary:  "Every tag used by a book, sorted",
+		SignedIn: true,
+		Response: []string{},
+		Handler:  handleGetTags,
+	})
+	api.Handle(api.Route{
+		Method:   "GET",
+		Pattern:  "/api/books/meta/genres",
+		Tag:      "books",
+		Summary:  "Every genre used by a book, sorted",
+		SignedIn: true,
+		Response: []string{},
+		Handler:  handleGetGenres,
+	})
+
+	api.Handle(api.Route{
+		Method:  "POST",
+		Pattern: "/api/books/import/isbn",
+		Tag:     "books",
+		Summary: "Add the books with a list of ISBNs, such as a shelf's, in the background",
+		Description: fmt.Sprintf("Accepts up to %d ISBNs and looks them up one at a time, adding each book found to the library. ", maxImportISBNs) +
+			"ISBNs that are invalid, repeated or already in the library are skipped. Poll the import for its progress and " +
+			"a result per ISBN, or follow the book.import.progress and book.import.done events. " +
+			"Responds with a conflict if the user already has an import running.",
+		SignedIn: true,
+		Request:  isbnImportInput{},
+		Status:   http.StatusAccepted,
+		Response: ISBNImport{},
+		Handler:  handleImportISBNs,
+	})
+	api.Handle(api.Route{
+		Method:      "GET",
+		Pattern:     "/api/books/import/isbn/{id}",
+		Tag:         "books",
+		Summary:     "Get an ISBN import's progress and results",
+		Description: "Imports are kept for a day after they finish.",
+		SignedIn:    true,
+		Response:    ISBNImport{},
+		Handler:     handleGetImport,
+	})
+
+	// Libraries
+	api.Handle(api.Route{
+		Method:   "GET",
+		Pattern:  "/api/books/libraries",
+		Tag:      "books",
+		Summary:  "The libraries the user is a member of, their own first",
+		SignedIn: true,
+		Response: []Library{},
+		Handler:  handleListLibraries,
+	})
+	api.Handle(api.Route{
+		Method:   "POST",
+		Pattern:  "/api/books/libraries",
+		Tag:      "books",
+		Summary:  "Create a shared library owned by the user",
+		SignedIn: true,
+		Request:  libraryInput{},
+		Status:   http.StatusCreated,
+		Response: Library{},
+		Handler:  handleCreateLibrary,
+	})
+	api.Handle(api.Route{
+		Method:      "DELETE",
+		Pattern:     "/api/books/libraries/{id}",
+		Tag:         "books",
+		Summary:     "Delete an empty shared library",
+		Description: "Only the owner can delete a library, and only once its books are moved or deleted.",
+		SignedIn:    true,
+		Status:      http.StatusNoContent,
+		Handler:     handleDeleteLibrary,
+	})
+	api.Handle(api.Route{
+		Method:      "POST",
+		Pattern:     "/api/books/libraries/{id}/members",
+		Tag:         "books",
+		Summary:     "Add a registered user to a shared library",
+		Description: "Only the owner can add members. Members can see, add, edit and delete the library's books.",
+		SignedIn:    true,
+		Request:     memberInput{},
+		Response:    Library{},
+		Handler:     handleAddMember,
+	})
+	api.Handle(api.Route{
+		Method:      "DELETE",
+		Pattern:     "/api/books/libraries/{id}/members/{user}",
+		Tag:         "books",
+		Summary:     "Remove a member from a shared library",
+		Description: "Owners can remove any other member; members can remove themselves to leave.",
+		SignedIn:    true,
+		Status:      http.StatusNoContent,
+		Handler:     handleRemoveMember,
+	})
 
 	// ISBN lookup
-	http.HandleFunc("GET /api/isbn/{isbn}", handleISBNLookup)
+	api.Handle(api.Route{
+		Method:  "GET",
+		Pattern: "/api/isbn/{isbn}",
+		Tag:     "books",
+		Summary: "Look up a book's details by ISBN",
+		Description: "Asks the configured metadata providers, Open Library by default, for the book and saves its cover, " +
+			"returning a preview to create the book from. Lookups are cached for 30 days, and ISBNs no provider knows for a day.",
+		Response: ISBNResponse{},
+		Handler:  handleISBNLookup,
+	})
+
+	// Cached lookups
+	api.Handle(api.Route{
+		Method:   "GET",
+		Pattern:  "/api/admin/isbn-cache",
+		Tag:      "admin",
+		Summary:  "Cached ISBN lookups, most recent first",
+		SignedIn: true,
+		Response: []CachedLookup{},
+		Handler:  handleListCachedLookups,
+	})
+	api.Handle(api.Route{
+		Method:      "DELETE",
+		Pattern:     "/api/admin/isbn-cache",
+		Tag:         "admin",
+		Summary:     "Purge cached ISBN lookups",
+		Description: "The next lookup of each ISBN asks the providers again. Covers are kept until the weekly clean up finds them unused.",
+		SignedIn:    true,
+		Params: []api.Param{
+			{Name: "expired", In: "query", Description: "true to only purge lookups that have expired"},
+		},
+		Response: PurgeResult{},
+		Handler:  handlePurgeLookups,
+	})
+	api.Handle(api.Route{
+		Method:   "DELETE",
+		Pattern:  "/api/admin/isbn-cache/{isbn}",
+		Tag:      "admin",
+		Summary:  "Purge an ISBN's cached lookup",
+		SignedIn: true,
+		Status:   http.StatusNoContent,
+		Handler:  handlePurgeLookup,
+	})
+
+	lifecycle.OnShutdown("books: close database", func(ctx context.Context) error {
+		return DB.Close()
+	})
+	// Registered after the database is, so imports stop before it closes
+	lifecycle.OnShutdown("books: stop isbn imports", stopISBNImports)
+
+	scheduler.Register("books.remove-unused-covers", "30 3 * * 0", removeUnusedCovers)
+	scheduler.Register("books.expire-isbn-lookups", "15 3 * * *", expireLookups)
+	admin.RegisterStats("books", func(ctx context.Context) (any, error) {
+		return GetStats(ctx)
+	})
 }
 
-func serveCoverImage(w http.ResponseWriter, r *http.Request) {
-	filename := strings.TrimPrefix(r.URL.Path, "/books/covers/")
-	if filename == "" {
-		http.Error(w, "Filename required", http.StatusBadRequest)
-		return
+// Deletes cover images no book or cached lookup refers to, such as those
+// saved by ISBN lookups that have expired. Covers from the last day are kept
+// in case their book is still being filled in.
+func removeUnusedCovers(ctx context.Context) error {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT cover_image FROM books WHERE cover_image IS NOT NULL
+		UNION SELECT cover_image FROM isbn_cache WHERE cover_image IS NOT NULL AND expires_at > ?
+	`, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to query covers: %w", err)
 	}
+	defer rows.Close()
 
-	filePath := filepath.Join(coversDir, filename)
-	http.ServeFile(w, r, filePath)
-}
-
-func handleListBooks(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()
-	search := query.Get("search")
-	genre := query.Get("genre")
-	readStatus := query.Get("read_status")
-	isSignedStr := query.Get("is_signed")
-	tag := query.Get("tag")
-
-	sql := "SELECT * FROM books WHERE 1=1"
-	var args []interface{}
-
-	if search != "" {
-		sql += " AND (title LIKE ? OR author LIKE ?)"
-		searchPattern := "%" + search + "%"
-		args = append(args, searchPattern, searchPattern)
-	}
-	if genre != "" {
-		sql += " AND genre = ?"
-		args = append(args, genre)
-	}
-	if readStatus != "" {
-		sql += " AND read_status = ?"
-		args = append(args, readStatus)
-	}
-	if isSignedStr != "" {
-		isSigned := 0
-		if isSignedStr == "true" {
-			isSigned = 1
+	used := map[string]bool{}
+	for rows.Next() {
+		var coverImage string
+		if err := rows.Scan(&coverImage); err != nil {
+			return fmt.Errorf("failed to scan cover: %w", err)
 		}
-		sql += " AND is_signed = ?"
-		args = append(args, isSigned)
+		used[strings.TrimPrefix(coverImage, "/books/covers/")] = true
 	}
-	if tag != "" {
-		sql += " AND tags LIKE ?"
-		args = append(args, `%"`+tag+`"%`)
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query covers: %w", err)
 	}
 
-	sql += " ORDER BY created_at DESC"
-
-	rows, err := DB.Query(sql, args...)
+	entries, err := os.ReadDir(coversDir)
 	if err != nil {
-		log.Printf("[ERROR] Failed to query books: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
-	defer rows.Close()
-
-	books := make([]Book, 0)
-	for rows.Next() {
-		var book Book
-		var tagsJSON string
-		var isSignedInt int
-		err := rows.Scan(
-			&book.ID, &book.Title, &book.Author, &book.Genre,
-			&book.ReadStatus, &book.CoverImage, &isSignedInt,
-			&tagsJSON, &book.CreatedAt,
-		)
-		if err != nil {
-			log.Printf("[ERROR] Failed to scan book: %v\n", err)
-			continue
+	removed := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-
-		book.IsSigned = isSignedInt == 1
-		if err := json.Unmarshal([]byte(tagsJSON), &book.Tags); err != nil {
-			book.Tags = []string{}
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || used[entry.Name()] || time.Since(info.ModTime()) < 24*time.Hour {
+			continue
 		}
-
-		// Update cover image path to use /books/covers/ prefix
-		if book.CoverImage != nil && *book.CoverImage != "" {
-			if !strings.HasPrefix(*book.CoverImage, "/books/covers/") {
-				// Extract filename from path
-				filename := filepath.Base(*book.CoverImage)
-				newPath := "/books/covers/" + filename
-				book.CoverImage = &newPath
-			}
+		if err := os.Remove(filepath.Join(coversDir, entry.Name())); err != nil {
+			log.Printf("[WARN] Failed to remove unused cover %s: %v\n", entry.Name(), err)
+			continue
 		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("[INFO] Removed %d unused cover images\n", removed)
+	}
+	return nil
+}
 
-		books = append(books, book)
+func serveCoverImage(w http.ResponseWriter, r *http.Request) {
+	filename := strings.TrimPrefix(r.URL.Path, "/books/covers/")
+	if filename == "" {
+		http.Error(w, "Filename required", http.StatusBadRequest)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(books)
+	filePath := filepath.Join(coversDir, filename)
+	http.ServeFile(w, r, filePath)
 }
 
-func handleGetBook(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
+// Qualified so they can be selected from books joined with its search index
+const bookColumns = "books.id, books.title, books.author, books.genre, books.read_status, books.cover_image, " +
+	"books.is_signed, books.tags, books.created_at, books.library_id, books.rating, " +
+	"books.isbn, books.subtitle, books.publisher, books.publish_date, books.pages, books.language, books.description"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
 
+// Scans a row of bookColumns, followed by any extra columns into extra
+func scanBook(row scanner, extra ...any) (Book, error) {
 	var book Book
 	var tagsJSON string
 	var isSignedInt int
-	err := DB.QueryRow(
-		"SELECT * FROM books WHERE id = ?", id,
-	).Scan(
+	err := row.Scan(append([]any{
 		&book.ID, &book.Title, &book.Author, &book.Genre,
 		&book.ReadStatus, &book.CoverImage, &isSignedInt,
-		&tagsJSON, &book.CreatedAt,
-	)
+		&tagsJSON, &book.CreatedAt, &book.LibraryID, &book.Rating,
+		&book.ISBN, &book.Subtitle, &book.Publisher, &book.PublishDate,
+		&book.Pages, &book.Language, &book.Description,
+	}, extra...)...)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			http.Error(w, "Book not found", http.StatusNotFound)
-			return
-		}
-		log.Printf("[ERROR] Failed to get book: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return book, err
 	}
 
 	book.IsSigned = isSignedInt == 1
@@ -174,21 +431,71 @@ func handleGetBook(w http.ResponseWriter, r *http.Request) {
 		book.Tags = []string{}
 	}
 
-	// Update cover image path
+	// Update cover image path to use /books/covers/ prefix
 	if book.CoverImage != nil && *book.CoverImage != "" {
 		if !strings.HasPrefix(*book.CoverImage, "/books/covers/") {
+			// Extract filename from path
 			filename := filepath.Base(*book.CoverImage)
 			newPath := "/books/covers/" + filename
 			book.CoverImage = &newPath
 		}
 	}
+	return book, nil
+}
+
+// Returns a book in one of the user's libraries. Books in other libraries
+// are reported as not found, the same as ones that don't exist.
+func getBook(ctx context.Context, id any, user string) (Book, error) {
+	book, err := scanBook(DB.QueryRowContext(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE id = ? AND "+memberOf, id, user,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return book, apierror.NotFound("Book not found")
+	}
+	if err != nil {
+		return book, fmt.Errorf("failed to get book: %w", err)
+	}
+	return book, nil
+}
+
+// Parses a rating of 1 to 5 stars. Empty or 0 is no rating.
+func parseRating(s string) (*int, error) {
+	if s == "" || s == "0" {
+		return nil, nil
+	}
+	rating, err := strconv.Atoi(s)
+	if err != nil || rating < 1 || rating > 5 {
+		return nil, apierror.Validation("Rating must be from 1 to 5 stars").WithDetails(map[string]string{"field": "rating"})
+	}
+	return &rating, nil
+}
+
+func handleGetBook(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	book, err := getBook(r.Context(), r.PathValue("id"), user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(book)
 }
 
 func handleCreateBook(w http.ResponseWriter, r *http.Request) {
-	var title, author, genre, readStatus, isSignedStr, tagsStr, existingCover string
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var title, author, genre, readStatus, isSignedStr, tagsStr, existingCover, libraryIDStr, ratingStr string
+	var details bookDetails
 
 	// Check content type
 	contentType := r.Header.Get("Content-Type")
@@ -196,7 +503,7 @@ func handleCreateBook(w http.ResponseWriter, r *http.Request) {
 		// Handle JSON request (from ISBN preview)
 		var jsonData map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.BadRequest("Invalid JSON"))
 			return
 		}
 
@@ -225,10 +532,25 @@ func handleCreateBook(w http.ResponseWriter, r *http.Request) {
 		if val, ok := jsonData["cover_image"].(string); ok {
 			existingCover = val
 		}
+		if val, ok := jsonData["library_id"].(float64); ok {
+			libraryIDStr = strconv.Itoa(int(val))
+		}
+		if val, ok := jsonData["rating"].(float64); ok {
+			ratingStr = strconv.FormatFloat(val, 'f', -1, 64)
+		}
+		details, err = parseDetails(func(name string) string {
+			switch val := jsonData[name].(type) {
+			case string:
+				return val
+			case float64:
+				return strconv.FormatFloat(val, 'f', -1, 64)
+			}
+			return ""
+		})
 	} else {
 		// Parse multipart form (max 10MB)
 		if err := r.ParseMultipartForm(10 << 20); err != nil {
-			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.BadRequest("Failed to parse form"))
 			return
 		}
 
@@ -239,10 +561,33 @@ func handleCreateBook(w http.ResponseWriter, r *http.Request) {
 		isSignedStr = r.FormValue("is_signed")
 		tagsStr = r.FormValue("tags")
 		existingCover = r.FormValue("cover_image")
+		libraryIDStr = r.FormValue("library_id")
+		ratingStr = r.FormValue("rating")
+		details, err = parseDetails(r.FormValue)
+	}
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
 	}
 
 	if title == "" || author == "" {
-		http.Error(w, "Title and author are required", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Validation("Title and author are required").WithDetails(map[string][]string{"fields": {"title", "author"}}))
+		return
+	}
+
+	rating, err := parseRating(ratingStr)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	libraryID, err := targetLibrary(r.Context(), libraryIDStr, user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if err := checkISBN(r.Context(), details.ISBN, libraryID, 0); err != nil {
+		apierror.Write(w, r, err)
 		return
 	}
 
@@ -258,7 +603,7 @@ func handleCreateBook(w http.ResponseWriter, r *http.Request) {
 			ext := filepath.Ext(header.Filename)
 			allowedExts := regexp.MustCompile(`(?i)\.(jpeg|jpg|png|gif|webp)$`)
 			if !allowedExts.MatchString(ext) {
-				http.Error(w, "Invalid file type. Allowed: jpeg, jpg, png, gif, webp", http.StatusBadRequest)
+				apierror.Write(w, r, apierror.Validation("Invalid file type. Allowed: jpeg, jpg, png, gif, webp"))
 				return
 			}
 
@@ -266,7 +611,7 @@ func handleCreateBook(w http.ResponseWriter, r *http.Request) {
 			mimeType := header.Header.Get("Content-Type")
 			allowedMimes := regexp.MustCompile(`(?i)^image/(jpeg|jpg|png|gif|webp)$`)
 			if !allowedMimes.MatchString(mimeType) {
-				http.Error(w, "Invalid MIME type", http.StatusBadRequest)
+				apierror.Write(w, r, apierror.Validation("Invalid MIME type"))
 				return
 			}
 
@@ -277,15 +622,13 @@ func handleCreateBook(w http.ResponseWriter, r *http.Request) {
 			// Save file
 			dst, err := os.Create(filePath)
 			if err != nil {
-				log.Printf("[ERROR] Failed to create file: %v\n", err)
-				http.Error(w, "Failed to save file", http.StatusInternalServerError)
+				apierror.Write(w, r, fmt.Errorf("failed to create file: %w", err))
 				return
 			}
 			defer dst.Close()
 
 			if _, err := io.Copy(dst, file); err != nil {
-				log.Printf("[ERROR] Failed to save file: %v\n", err)
-				http.Error(w, "Failed to save file", http.StatusInternalServerError)
+				apierror.Write(w, r, fmt.Errorf("failed to save file: %w", err))
 				return
 			}
 
@@ -337,52 +680,30 @@ func handleCreateBook(w http.ResponseWriter, r *http.Request) {
 
 	// Insert book
 	result, err := DB.Exec(`
-		INSERT INTO books (title, author, genre, read_status, cover_image, is_signed, tags)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, title, author, genrePtr, readStatus, coverImage, isSigned, tagsJSON)
+		INSERT INTO books (title, author, genre, read_status, cover_image, is_signed, tags, library_id, rating,
+			isbn, subtitle, publisher, publish_date, pages, language, description)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, title, author, genrePtr, readStatus, coverImage, isSigned, tagsJSON, libraryID, rating,
+		details.ISBN, details.Subtitle, details.Publisher, details.PublishDate, details.Pages, details.Language, details.Description)
 	if err != nil {
-		log.Printf("[ERROR] Failed to insert book: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, isbnConflict(r.Context(), fmt.Errorf("failed to insert book: %w", err), details.ISBN, libraryID, 0))
 		return
 	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
-		log.Printf("[ERROR] Failed to get last insert ID: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, fmt.Errorf("failed to get last insert ID: %w", err))
 		return
 	}
 
 	// Fetch created book
-	var book Book
-	var tagsJSONResult string
-	var isSignedInt int
-	err = DB.QueryRow(
-		"SELECT * FROM books WHERE id = ?", id,
-	).Scan(
-		&book.ID, &book.Title, &book.Author, &book.Genre,
-		&book.ReadStatus, &book.CoverImage, &isSignedInt,
-		&tagsJSONResult, &book.CreatedAt,
-	)
+	book, err := getBook(r.Context(), id, user)
 	if err != nil {
-		log.Printf("[ERROR] Failed to fetch created book: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, fmt.Errorf("failed to fetch created book: %w", err))
 		return
 	}
 
-	book.IsSigned = isSignedInt == 1
-	if err := json.Unmarshal([]byte(tagsJSONResult), &book.Tags); err != nil {
-		book.Tags = []string{}
-	}
-
-	// Update cover image path
-	if book.CoverImage != nil && *book.CoverImage != "" {
-		if !strings.HasPrefix(*book.CoverImage, "/books/covers/") {
-			filename := filepath.Base(*book.CoverImage)
-			newPath := "/books/covers/" + filename
-			book.CoverImage = &newPath
-		}
-	}
+	publish(r.Context(), "book.created", book.LibraryID, book)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -390,37 +711,25 @@ func handleCreateBook(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleUpdateBook(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-
-	// Check if book exists
-	var existing Book
-	var tagsJSON string
-	var isSignedInt int
-	err := DB.QueryRow(
-		"SELECT * FROM books WHERE id = ?", id,
-	).Scan(
-		&existing.ID, &existing.Title, &existing.Author, &existing.Genre,
-		&existing.ReadStatus, &existing.CoverImage, &isSignedInt,
-		&tagsJSON, &existing.CreatedAt,
-	)
+	user, err := auth.RequireUser(r)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			http.Error(w, "Book not found", http.StatusNotFound)
-			return
-		}
-		log.Printf("[ERROR] Failed to get book: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
+	id := r.PathValue("id")
 
-	existing.IsSigned = isSignedInt == 1
-	if err := json.Unmarshal([]byte(tagsJSON), &existing.Tags); err != nil {
-		existing.Tags = []string{}
+	// Check the book exists and the user can edit it
+	existing, err := getBook(r.Context(), id, user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
 	}
+	tagsJSONBytes, _ := json.Marshal(existing.Tags)
+	tagsJSON := string(tagsJSONBytes)
 
 	// Parse multipart form
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.BadRequest("Failed to parse form"))
 		return
 	}
 
@@ -431,6 +740,38 @@ func handleUpdateBook(w http.ResponseWriter, r *http.Request) {
 	isSignedStr := r.FormValue("is_signed")
 	tagsStr := r.FormValue("tags")
 
+	rating := existing.Rating
+	if ratingStr := r.FormValue("rating"); ratingStr != "" {
+		rating, err = parseRating(ratingStr)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+	}
+
+	libraryID := existing.LibraryID
+	if libraryIDStr := r.FormValue("library_id"); libraryIDStr != "" {
+		libraryID, err = targetLibrary(r.Context(), libraryIDStr, user)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+	}
+
+	details, err := parseDetails(r.FormValue)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	details.keep(existing, func(name string) bool {
+		_, ok := r.Form[name]
+		return ok
+	})
+	if err := checkISBN(r.Context(), details.ISBN, libraryID, existing.ID); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
 	// Use existing values if not provided
 	if title == "" {
 		title = existing.Title
@@ -457,28 +798,20 @@ func handleUpdateBook(w http.ResponseWriter, r *http.Request) {
 		defer file.Close()
 
 		// Delete old cover if exists
-		if existing.CoverImage != nil && *existing.CoverImage != "" {
-			oldPath := strings.TrimPrefix(*existing.CoverImage, "/books/covers/")
-			if oldPath != *existing.CoverImage {
-				oldFilePath := filepath.Join(coversDir, oldPath)
-				if err := os.Remove(oldFilePath); err != nil && !os.IsNotExist(err) {
-					log.Printf("[WARN] Failed to remove old cover: %v\n", err)
-				}
-			}
-		}
+		removeCover(r.Context(), existing.CoverImage, existing.ID)
 
 		// Validate file
 		ext := filepath.Ext(header.Filename)
 		allowedExts := regexp.MustCompile(`(?i)\.(jpeg|jpg|png|gif|webp)$`)
 		if !allowedExts.MatchString(ext) {
-			http.Error(w, "Invalid file type", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.Validation("Invalid file type. Allowed: jpeg, jpg, png, gif, webp"))
 			return
 		}
 
 		mimeType := header.Header.Get("Content-Type")
 		allowedMimes := regexp.MustCompile(`(?i)^image/(jpeg|jpg|png|gif|webp)$`)
 		if !allowedMimes.MatchString(mimeType) {
-			http.Error(w, "Invalid MIME type", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.Validation("Invalid MIME type"))
 			return
 		}
 
@@ -488,15 +821,13 @@ func handleUpdateBook(w http.ResponseWriter, r *http.Request) {
 
 		dst, err := os.Create(filePath)
 		if err != nil {
-			log.Printf("[ERROR] Failed to create file: %v\n", err)
-			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			apierror.Write(w, r, fmt.Errorf("failed to create file: %w", err))
 			return
 		}
 		defer dst.Close()
 
 		if _, err := io.Copy(dst, file); err != nil {
-			log.Printf("[ERROR] Failed to save file: %v\n", err)
-			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			apierror.Write(w, r, fmt.Errorf("failed to save file: %w", err))
 			return
 		}
 
@@ -527,7 +858,7 @@ func handleUpdateBook(w http.ResponseWriter, r *http.Request) {
 		isSigned = (isSignedStr == "true" || isSignedStr == "1")
 	}
 
-	isSignedInt = 0
+	isSignedInt := 0
 	if isSigned {
 		isSignedInt = 1
 	}
@@ -541,94 +872,81 @@ func handleUpdateBook(w http.ResponseWriter, r *http.Request) {
 			read_status = ?,
 			cover_image = ?,
 			is_signed = ?,
-			tags = ?
+			tags = ?,
+			library_id = ?,
+			rating = ?,
+			isbn = ?,
+			subtitle = ?,
+			publisher = ?,
+			publish_date = ?,
+			pages = ?,
+			language = ?,
+			description = ?
 		WHERE id = ?
-	`, title, author, genrePtr, readStatus, coverImage, isSignedInt, tagsJSONResult, id)
+	`, title, author, genrePtr, readStatus, coverImage, isSignedInt, tagsJSONResult, libraryID, rating,
+		details.ISBN, details.Subtitle, details.Publisher, details.PublishDate, details.Pages, details.Language, details.Description, id)
 	if err != nil {
-		log.Printf("[ERROR] Failed to update book: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, isbnConflict(r.Context(), fmt.Errorf("failed to update book: %w", err), details.ISBN, libraryID, existing.ID))
 		return
 	}
 
 	// Fetch updated book
-	var book Book
-	var tagsJSONFinal string
-	var isSignedIntFinal int
-	err = DB.QueryRow(
-		"SELECT * FROM books WHERE id = ?", id,
-	).Scan(
-		&book.ID, &book.Title, &book.Author, &book.Genre,
-		&book.ReadStatus, &book.CoverImage, &isSignedIntFinal,
-		&tagsJSONFinal, &book.CreatedAt,
-	)
+	book, err := getBook(r.Context(), id, user)
 	if err != nil {
-		log.Printf("[ERROR] Failed to fetch updated book: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, fmt.Errorf("failed to fetch updated book: %w", err))
 		return
 	}
 
-	book.IsSigned = isSignedIntFinal == 1
-	if err := json.Unmarshal([]byte(tagsJSONFinal), &book.Tags); err != nil {
-		book.Tags = []string{}
-	}
-
-	// Update cover image path
-	if book.CoverImage != nil && *book.CoverImage != "" {
-		if !strings.HasPrefix(*book.CoverImage, "/books/covers/") {
-			filename := filepath.Base(*book.CoverImage)
-			newPath := "/books/covers/" + filename
-			book.CoverImage = &newPath
-		}
+	if book.LibraryID != existing.LibraryID {
+		// Members of the old library see it go
+		publish(r.Context(), "book.deleted", existing.LibraryID, map[string]string{"id": id})
 	}
+	publish(r.Context(), "book.updated", book.LibraryID, book)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(book)
 }
 
 func handleDeleteBook(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
 	id := r.PathValue("id")
 
-	// Get book to check existence and get cover image path
-	var coverImage *string
-	err := DB.QueryRow("SELECT cover_image FROM books WHERE id = ?", id).Scan(&coverImage)
+	// Get book to check the user can delete it and get cover image path
+	book, err := getBook(r.Context(), id, user)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			http.Error(w, "Book not found", http.StatusNotFound)
-			return
-		}
-		log.Printf("[ERROR] Failed to get book: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
-	// Delete cover image if exists
-	if coverImage != nil && *coverImage != "" {
-		// Extract filename from path
-		filename := strings.TrimPrefix(*coverImage, "/books/covers/")
-		if filename != *coverImage {
-			filePath := filepath.Join(coversDir, filename)
-			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-				log.Printf("[WARN] Failed to remove cover image: %v\n", err)
-			}
-		}
-	}
+	// Delete cover image if no other book uses it
+	removeCover(r.Context(), book.CoverImage, book.ID)
 
 	// Delete book
 	_, err = DB.Exec("DELETE FROM books WHERE id = ?", id)
 	if err != nil {
-		log.Printf("[ERROR] Failed to delete book: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, fmt.Errorf("failed to delete book: %w", err))
 		return
 	}
 
+	publish(r.Context(), "book.deleted", book.LibraryID, map[string]string{"id": id})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func handleGetTags(w http.ResponseWriter, r *http.Request) {
-	rows, err := DB.Query("SELECT tags FROM books")
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	rows, err := DB.Query("SELECT tags FROM books WHERE "+memberOf, user)
 	if err != nil {
-		log.Printf("[ERROR] Failed to query tags: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, fmt.Errorf("failed to query tags: %w", err))
 		return
 	}
 	defer rows.Close()
@@ -669,10 +987,15 @@ func handleGetTags(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleGetGenres(w http.ResponseWriter, r *http.Request) {
-	rows, err := DB.Query("SELECT DISTINCT genre FROM books WHERE genre IS NOT NULL")
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	rows, err := DB.Query("SELECT DISTINCT genre FROM books WHERE genre IS NOT NULL AND "+memberOf, user)
 	if err != nil {
-		log.Printf("[ERROR] Failed to query genres: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, fmt.Errorf("failed to query genres: %w", err))
 		return
 	}
 	defer rows.Close()
@@ -0,0 +1,163 @@
+package books
+
+import (
+	"NbirdHttp/auth"
+	"NbirdHttp/lookup"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Points the package at a new database and covers directory for the test,
+// with alice, bob and carol registered, each with the password secret
+func setup(t *testing.T) {
+	t.Helper()
+	db, err := openDB(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDB, oldCovers := DB, coversDir
+	DB, coversDir = db, t.TempDir()
+	t.Cleanup(func() {
+		db.Close()
+		DB, coversDir = oldDB, oldCovers
+	})
+	if err := Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	auth.AUTH_FILE = filepath.Join(t.TempDir(), ".auth")
+	t.Cleanup(func() { auth.AUTH_FILE = "./auth/.auth" })
+	hash := sha256.Sum256([]byte("secret"))
+	users := fmt.Sprintf("alice,%x\nbob,%x\ncarol,%x\n", hash, hash, hash)
+	if err := os.WriteFile(auth.AUTH_FILE, []byte(users), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns a request signed in as user, or anonymous if user is empty. A body
+// is sent as JSON.
+func newRequest(user, method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if user != "" {
+		r.SetBasicAuth(user, "secret")
+	}
+	return r
+}
+
+// Returns a multipart form request signed in as user, as the book form
+// sends
+func newFormRequest(user, method, target string, fields map[string]string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	form.Close()
+	r := httptest.NewRequest(method, target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.SetBasicAuth(user, "secret")
+	return r
+}
+
+// Serves the request with handler, setting the path values given as name,
+// value pairs
+func serve(handler http.HandlerFunc, r *http.Request, pathValues ...string) *httptest.ResponseRecorder {
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		r.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, r)
+	return rr
+}
+
+func decode[T any](t *testing.T, rr *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rr.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %s: %v", rr.Body, err)
+	}
+	return v
+}
+
+// Adds a book as user from JSON fields, as the ISBN preview does
+func createBook(t *testing.T, user string, fields map[string]any) Book {
+	t.Helper()
+	body, _ := json.Marshal(fields)
+	rr := serve(handleCreateBook, newRequest(user, "POST", "/api/books", string(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create book %v: status = %d, body = %s", fields, rr.Code, rr.Body)
+	}
+	return decode[Book](t, rr)
+}
+
+// A provider knowing the books in records by ISBN-13, failing with errs[isbn]
+// for those in errs
+type fakeProvider struct {
+	name    string
+	records map[string]*lookup.Record
+	errs    map[string]error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) Lookup(ctx context.Context, isbn string) (*lookup.Record, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	if err := p.errs[isbn]; err != nil {
+		return nil, err
+	}
+	if record, ok := p.records[isbn]; ok {
+		found := *record
+		return &found, nil
+	}
+	return nil, lookup.ErrNotFound
+}
+
+func (p *fakeProvider) lookups() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// Makes ISBN lookups ask the fake providers for the test. It returns the URL of a
+// cover image to give records, and the number of times it has been fetched.
+func setLookup(t *testing.T, fakes ...lookup.MetadataProvider) (coverURL string, fetches func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(bytes.Repeat([]byte{0xff}, 2000))
+	}))
+	t.Cleanup(server.Close)
+
+	oldProviders, oldClient := providers, lookupClient
+	SetLookup(fakes, server.Client())
+	t.Cleanup(func() { SetLookup(oldProviders, oldClient) })
+	return server.URL + "/cover.jpg", func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
@@ -0,0 +1,264 @@
+package books
+
+import (
+	"NbirdHttp/apierror"
+	"NbirdHttp/auth"
+	"NbirdHttp/isbn"
+	"NbirdHttp/lookup"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How long ISBN lookups are cached: books that were found for a month,
+// ISBNs no provider knew for a day, in case they are catalogued soon, and
+// books missing details a request failed to fetch for an hour
+const (
+	lookupTTL   = 30 * 24 * time.Hour
+	notFoundTTL = 24 * time.Hour
+	partialTTL  = time.Hour
+)
+
+// CachedLookup is an ISBN lookup kept to answer the next without asking the
+// providers again.
+type CachedLookup struct {
+	ISBN  string `json:"isbn"`
+	Found bool   `json:"found"`
+	Title string `json:"title,omitempty"`
+	// The saved cover, or null if there is none
+	CoverImage *string   `json:"cover_image"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type PurgeResult struct {
+	Purged int64 `json:"purged"`
+}
+
+// Returns the cached lookup of an ISBN and whether there is one that hasn't
+// expired. The record is nil if no provider knew the ISBN.
+func cachedLookup(ctx context.Context, isbn string) (record *lookup.Record, cover *string, ok bool, err error) {
+	var recordJSON *string
+	err = DB.QueryRowContext(ctx,
+		"SELECT record, cover_image FROM isbn_cache WHERE isbn = ? AND expires_at > ?",
+		isbn, time.Now().UnixMilli(),
+	).Scan(&recordJSON, &cover)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to read cached lookup: %w", err)
+	}
+	if recordJSON == nil {
+		return nil, nil, true, nil
+	}
+	if err := json.Unmarshal([]byte(*recordJSON), &record); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to decode cached lookup: %w", err)
+	}
+	return record, cover, true, nil
+}
+
+// Caches a lookup of an ISBN, with a nil record if no provider knew it
+func cacheLookup(ctx context.Context, isbn string, record *lookup.Record, cover *string) error {
+	var recordJSON *string
+	ttl := notFoundTTL
+	if record != nil {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		s := string(data)
+		recordJSON = &s
+		ttl = lookupTTL
+		if len(record.Warnings) > 0 {
+			ttl = partialTTL
+		}
+	}
+	now := time.Now()
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO isbn_cache (isbn, record, cover_image, fetched_at, expires_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (isbn) DO UPDATE SET
+			record = excluded.record,
+			cover_image = excluded.cover_image,
+			fetched_at = excluded.fetched_at,
+			expires_at = excluded.expires_at
+	`, isbn, recordJSON, cover, now.UnixMilli(), now.Add(ttl).UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to cache lookup: %w", err)
+	}
+	return nil
+}
+
+// Covers downloaded for an ISBN are saved under a name made from it, so
+// every lookup and book of the ISBN shares the one file
+func coverFilename(isbn string) string {
+	return isbn + ".jpg"
+}
+
+func coverExists(path string) bool {
+	filename := strings.TrimPrefix(path, "/books/covers/")
+	if filename == path {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(coversDir, filename))
+	return err == nil
+}
+
+// Returns a cover already downloaded for the ISBN-13, including one saved
+// under the names older lookups used: <isbn>-<unix time>.jpg, and either
+// with the ISBN-10 it was looked up by
+func existingCover(isbn13 string) *string {
+	names := []string{isbn13}
+	if isbn10, ok := isbn.To10(isbn13); ok {
+		names = append(names, isbn10)
+	}
+	for _, name := range names {
+		path := "/books/covers/" + coverFilename(name)
+		if coverExists(path) {
+			return &path
+		}
+	}
+	for _, name := range names {
+		matches, _ := filepath.Glob(filepath.Join(coversDir, name+"-*.jpg"))
+		if len(matches) > 0 {
+			// The newest, as the names sort by time
+			path := "/books/covers/" + filepath.Base(matches[len(matches)-1])
+			return &path
+		}
+	}
+	return nil
+}
+
+// Deletes a book's cover image, unless another book or a cached lookup
+// uses it too
+func removeCover(ctx context.Context, cover *string, bookID int) {
+	if cover == nil || *cover == "" {
+		return
+	}
+	filename := strings.TrimPrefix(*cover, "/books/covers/")
+	if filename == *cover {
+		return
+	}
+
+	var used bool
+	err := DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM books WHERE cover_image = ? AND id != ?)
+			OR EXISTS (SELECT 1 FROM isbn_cache WHERE cover_image = ? AND expires_at > ?)
+	`, *cover, bookID, *cover, time.Now().UnixMilli()).Scan(&used)
+	if err != nil {
+		log.Printf("[WARN] Failed to check whether cover %s is used: %v\n", filename, err)
+		return
+	}
+	if used {
+		return
+	}
+	if err := os.Remove(filepath.Join(coversDir, filename)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[WARN] Failed to remove cover image: %v\n", err)
+	}
+}
+
+// Deletes cached lookups that have expired
+func expireLookups(ctx context.Context) error {
+	result, err := DB.ExecContext(ctx, "DELETE FROM isbn_cache WHERE expires_at <= ?", time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to expire cached lookups: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("[INFO] Removed %d expired ISBN lookups\n", n)
+	}
+	return nil
+}
+
+func handleListCachedLookups(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.RequireAdmin(r); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	rows, err := DB.QueryContext(r.Context(), `
+		SELECT isbn, record IS NOT NULL, COALESCE(json_extract(record, '$.title'), ''), cover_image, fetched_at, expires_at
+		FROM isbn_cache ORDER BY fetched_at DESC
+	`)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to query cached lookups: %w", err))
+		return
+	}
+	defer rows.Close()
+
+	lookups := make([]CachedLookup, 0)
+	for rows.Next() {
+		var l CachedLookup
+		var fetchedAt, expiresAt int64
+		if err := rows.Scan(&l.ISBN, &l.Found, &l.Title, &l.CoverImage, &fetchedAt, &expiresAt); err != nil {
+			apierror.Write(w, r, fmt.Errorf("failed to scan cached lookup: %w", err))
+			return
+		}
+		l.FetchedAt = time.UnixMilli(fetchedAt).UTC()
+		l.ExpiresAt = time.UnixMilli(expiresAt).UTC()
+		lookups = append(lookups, l)
+	}
+	if err := rows.Err(); err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to query cached lookups: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lookups)
+}
+
+func handlePurgeLookups(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireAdmin(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	query := "DELETE FROM isbn_cache"
+	var args []any
+	if r.URL.Query().Get("expired") == "true" {
+		query += " WHERE expires_at <= ?"
+		args = append(args, time.Now().UnixMilli())
+	}
+	result, err := DB.ExecContext(r.Context(), query, args...)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to purge cached lookups: %w", err))
+		return
+	}
+	purged, _ := result.RowsAffected()
+	log.Printf("[INFO] %s purged %d cached ISBN lookups\n", user, purged)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PurgeResult{Purged: purged})
+}
+
+func handlePurgeLookup(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.RequireAdmin(r); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	isbn, err := normalizeISBN(r.PathValue("isbn"))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	result, err := DB.ExecContext(r.Context(), "DELETE FROM isbn_cache WHERE isbn = ?", isbn)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to purge cached lookup: %w", err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, r, apierror.NotFound("ISBN lookup not cached"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,251 @@
+package books
+
+import (
+	"NbirdHttp/lookup"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	duneISBN13 = "9780441172719"
+	duneISBN10 = "0441172717"
+)
+
+// Looks the ISBN up through the ISBN route, returning the status and, for a
+// book found, the response
+func lookupRoute(t *testing.T, isbn string) (int, ISBNResponse) {
+	t.Helper()
+	rr := serve(handleISBNLookup, newRequest("alice", "GET", "/api/isbn/"+isbn, ""), "isbn", isbn)
+	if rr.Code != http.StatusOK {
+		return rr.Code, ISBNResponse{}
+	}
+	return rr.Code, decode[ISBNResponse](t, rr)
+}
+
+// Returns how long the ISBN's cached lookup is kept for, and its cover
+func cacheEntry(t *testing.T, isbn string) (time.Duration, *string) {
+	t.Helper()
+	var fetchedAt, expiresAt int64
+	var cover *string
+	err := DB.QueryRow("SELECT fetched_at, expires_at, cover_image FROM isbn_cache WHERE isbn = ?", isbn).
+		Scan(&fetchedAt, &expiresAt, &cover)
+	if err != nil {
+		t.Fatalf("cached lookup of %s: %v", isbn, err)
+	}
+	return time.Duration(expiresAt-fetchedAt) * time.Millisecond, cover
+}
+
+func writeCover(t *testing.T, name string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(coversDir, name), []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookupCacheTTL(t *testing.T) {
+	setup(t)
+	first := &fakeProvider{name: "first", errs: map[string]error{"9780141439587": errors.New("unavailable")}}
+	second := &fakeProvider{name: "second", records: map[string]*lookup.Record{
+		duneISBN13:      {Title: "Dune"},
+		"9780141439587": {Title: "Emma"},
+	}}
+	setLookup(t, first, second)
+
+	tests := []struct {
+		name    string
+		isbn    string
+		want    int
+		partial bool
+		ttl     time.Duration
+	}{
+		{"found", duneISBN13, http.StatusOK, false, 30 * 24 * time.Hour},
+		{"not found", "9780593098233", http.StatusNotFound, false, 24 * time.Hour},
+		{"found with warnings", "9780141439587", http.StatusOK, true, time.Hour},
+	}
+	for _, tt := range tests {
+		code, res := lookupRoute(t, tt.isbn)
+		if code != tt.want || res.Cached || res.Partial != tt.partial {
+			t.Fatalf("%s: status = %d, cached %v, partial %v", tt.name, code, res.Cached, res.Partial)
+		}
+		if ttl, _ := cacheEntry(t, tt.isbn); ttl != tt.ttl {
+			t.Errorf("%s: cached for %v, want %v", tt.name, ttl, tt.ttl)
+		}
+
+		// Answered from the cache until it expires
+		calls := second.lookups()
+		if code, res := lookupRoute(t, tt.isbn); code != tt.want || (code == http.StatusOK && !res.Cached) {
+			t.Errorf("%s: second lookup status = %d, cached %v", tt.name, code, res.Cached)
+		}
+		if second.lookups() != calls {
+			t.Errorf("%s: second lookup asked the providers", tt.name)
+		}
+		DB.Exec("UPDATE isbn_cache SET expires_at = ? WHERE isbn = ?", time.Now().UnixMilli()-1, tt.isbn)
+		if _, res := lookupRoute(t, tt.isbn); res.Cached || second.lookups() != calls+1 {
+			t.Errorf("%s: expired lookup was answered from the cache", tt.name)
+		}
+	}
+}
+
+func TestExistingCover(t *testing.T) {
+	defer func(dir string) { coversDir = dir }(coversDir)
+
+	tests := []struct {
+		name  string
+		files []string
+		isbn  string
+		want  string
+	}{
+		{"none", nil, duneISBN13, ""},
+		{"isbn-13", []string{duneISBN13 + ".jpg"}, duneISBN13, duneISBN13 + ".jpg"},
+		{"isbn-10", []string{duneISBN10 + ".jpg"}, duneISBN13, duneISBN10 + ".jpg"},
+		{"isbn-13 before isbn-10", []string{duneISBN10 + ".jpg", duneISBN13 + ".jpg"}, duneISBN13, duneISBN13 + ".jpg"},
+		{"old name", []string{duneISBN13 + "-1700000000.jpg"}, duneISBN13, duneISBN13 + "-1700000000.jpg"},
+		{"newest old name", []string{duneISBN13 + "-1700000000.jpg", duneISBN13 + "-1700000100.jpg"}, duneISBN13, duneISBN13 + "-1700000100.jpg"},
+		{"old isbn-10 name", []string{duneISBN10 + "-1700000000.jpg"}, duneISBN13, duneISBN10 + "-1700000000.jpg"},
+		{"new name before old", []string{duneISBN13 + "-1700000000.jpg", duneISBN10 + ".jpg"}, duneISBN13, duneISBN10 + ".jpg"},
+		{"other isbn", []string{"9780141439587.jpg", "9780141439587-1700000000.jpg"}, duneISBN13, ""},
+		{"979 has no isbn-10", []string{"1234567890.jpg"}, "9791234567896", ""},
+	}
+	for _, tt := range tests {
+		coversDir = t.TempDir()
+		for _, name := range tt.files {
+			writeCover(t, name)
+		}
+		got := existingCover(tt.isbn)
+		if tt.want == "" {
+			if got != nil {
+				t.Errorf("%s: existingCover = %s, want none", tt.name, *got)
+			}
+			continue
+		}
+		if got == nil || *got != "/books/covers/"+tt.want {
+			t.Errorf("%s: existingCover = %v, want /books/covers/%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLookupReusesCover(t *testing.T) {
+	setup(t)
+	provider := &fakeProvider{name: "fake", records: map[string]*lookup.Record{duneISBN13: {Title: "Dune"}}}
+	coverURL, fetches := setLookup(t, provider)
+	provider.records[duneISBN13].Covers = []string{coverURL}
+
+	for _, name := range []string{duneISBN13 + "-1700000000.jpg", duneISBN10 + ".jpg"} {
+		coversDir = t.TempDir()
+		writeCover(t, name)
+		DB.Exec("DELETE FROM isbn_cache")
+
+		code, res := lookupRoute(t, duneISBN13)
+		if code != http.StatusOK {
+			t.Fatalf("%s: status = %d", name, code)
+		}
+		if res.CoverImage == nil || *res.CoverImage != "/books/covers/"+name {
+			t.Errorf("%s: cover = %v, want the one already saved", name, res.CoverImage)
+		}
+		if _, cached := cacheEntry(t, duneISBN13); cached == nil || *cached != "/books/covers/"+name {
+			t.Errorf("%s: cached cover = %v, want the one already saved", name, cached)
+		}
+	}
+	if fetches() != 0 {
+		t.Errorf("covers fetched %d times, want none", fetches())
+	}
+
+	// Without one, the cover is downloaded under the ISBN-13
+	coversDir = t.TempDir()
+	DB.Exec("DELETE FROM isbn_cache")
+	if _, res := lookupRoute(t, duneISBN13); res.CoverImage == nil || *res.CoverImage != "/books/covers/"+duneISBN13+".jpg" || fetches() != 1 {
+		t.Errorf("cover = %v after %d fetches, want it downloaded once", res.CoverImage, fetches())
+	}
+
+	// and fetched again once it has been deleted
+	os.Remove(filepath.Join(coversDir, duneISBN13+".jpg"))
+	if _, res := lookupRoute(t, duneISBN13); !res.Cached || res.CoverImage == nil || fetches() != 2 {
+		t.Errorf("cover = %v after %d fetches, want the deleted cover downloaded again", res.CoverImage, fetches())
+	}
+}
+
+func TestCoverSizeLimit(t *testing.T) {
+	setup(t)
+	coverURL, _ := setLookup(t)
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(bytes.Repeat([]byte{0xff}, maxCoverSize+1))
+	}))
+	defer large.Close()
+
+	// A cover over the limit is skipped for the next one
+	got := saveCover(context.Background(), duneISBN13, []string{large.URL + "/cover.jpg", coverURL})
+	if got == nil || *got != "/books/covers/"+duneISBN13+".jpg" {
+		t.Fatalf("cover = %v, want the one under the limit", got)
+	}
+	if info, err := os.Stat(filepath.Join(coversDir, duneISBN13+".jpg")); err != nil || info.Size() != 2000 {
+		t.Errorf("saved cover: %v, %v", info, err)
+	}
+
+	os.Remove(filepath.Join(coversDir, duneISBN13+".jpg"))
+	if got := saveCover(context.Background(), duneISBN13, []string{large.URL + "/cover.jpg"}); got != nil {
+		t.Errorf("cover = %s, want none", *got)
+	}
+}
+
+func TestRemoveCover(t *testing.T) {
+	setup(t)
+	ctx := context.Background()
+	cover := "/books/covers/" + duneISBN13 + ".jpg"
+	book := createBook(t, "alice", map[string]any{"title": "Dune", "author": "Frank Herbert", "cover_image": cover})
+
+	tests := []struct {
+		name   string
+		before func()
+		kept   bool
+	}{
+		{"only the book uses it", func() {}, false},
+		{"another book uses it", func() {
+			createBook(t, "bob", map[string]any{"title": "Dune", "author": "Frank Herbert", "cover_image": cover})
+		}, true},
+		{"a cached lookup uses it", func() {
+			cacheLookup(ctx, duneISBN13, &lookup.Record{Title: "Dune"}, &cover)
+		}, true},
+		{"an expired cached lookup uses it", func() {
+			cacheLookup(ctx, duneISBN13, &lookup.Record{Title: "Dune"}, &cover)
+			DB.Exec("UPDATE isbn_cache SET expires_at = ?", time.Now().UnixMilli()-1)
+		}, false},
+	}
+	for _, tt := range tests {
+		DB.Exec("DELETE FROM books WHERE id != ?", book.ID)
+		DB.Exec("DELETE FROM isbn_cache")
+		writeCover(t, duneISBN13+".jpg")
+		tt.before()
+
+		removeCover(ctx, &cover, book.ID)
+		_, err := os.Stat(filepath.Join(coversDir, duneISBN13+".jpg"))
+		if kept := err == nil; kept != tt.kept {
+			t.Errorf("%s: kept = %v, want %v", tt.name, kept, tt.kept)
+		}
+	}
+
+	// Deleting the book keeps the cover a cached lookup still shows
+	writeCover(t, duneISBN13+".jpg")
+	cacheLookup(ctx, duneISBN13, &lookup.Record{Title: "Dune"}, &cover)
+	id := strconv.Itoa(book.ID)
+	serve(handleDeleteBook, newRequest("alice", "DELETE", "/api/books/"+id, ""), "id", id)
+	if _, err := os.Stat(filepath.Join(coversDir, duneISBN13+".jpg")); err != nil {
+		t.Errorf("cover removed with the book: %v", err)
+	}
+
+	// Covers elsewhere are never touched
+	outside := filepath.Join(t.TempDir(), "cover.jpg")
+	os.WriteFile(outside, []byte("jpeg"), 0644)
+	removeCover(ctx, &outside, 0)
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("cover outside the covers directory removed: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package books
 
 import (
+	"NbirdHttp/migrate"
+	"context"
 	"database/sql"
 	"log"
 	"os"
@@ -11,36 +13,153 @@ import (
 
 var DB *sql.DB
 
+var dbPath = filepath.Join(".", "books", "data", "books.db")
+
 func init() {
-	dataDir := filepath.Join(".", "books", "data")
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 		log.Printf("[ERROR] Failed to create data directory: %v\n", err)
 		return
 	}
 
-	dbPath := filepath.Join(dataDir, "books.db")
 	var err error
-	DB, err = sql.Open("sqlite", dbPath)
+	DB, err = openDB(dbPath)
 	if err != nil {
 		log.Printf("[ERROR] Failed to open database: %v\n", err)
 		return
 	}
+}
 
-	// Create books table
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS books (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			title TEXT NOT NULL,
-			author TEXT NOT NULL,
-			genre TEXT,
-			read_status TEXT DEFAULT 'unread',
-			cover_image TEXT,
-			is_signed INTEGER DEFAULT 0,
-			tags TEXT DEFAULT '[]',
-			created_at TEXT DEFAULT (datetime('now'))
-		)
-	`)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create books table: %v\n", err)
+// Opens the books database. Requests and background imports write at the
+// same time, so a write waits a while for another to finish rather than
+// failing straight away as locked.
+func openDB(path string) (*sql.DB, error) {
+	return sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+}
+
+// Migrations brings the books database up to date. Append new migrations
+// to the end; never edit one that has shipped.
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create books table",
+		// Databases from before migrations already have the table
+		SQL: `
+			CREATE TABLE IF NOT EXISTS books (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				title TEXT NOT NULL,
+				author TEXT NOT NULL,
+				genre TEXT,
+				read_status TEXT DEFAULT 'unread',
+				cover_image TEXT,
+				is_signed INTEGER DEFAULT 0,
+				tags TEXT DEFAULT '[]',
+				created_at TEXT DEFAULT (datetime('now'))
+			)
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "add libraries",
+		SQL: `
+			CREATE TABLE libraries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				owner TEXT NOT NULL,
+				personal INTEGER NOT NULL DEFAULT 0,
+				created_at TEXT DEFAULT (datetime('now'))
+			);
+			CREATE UNIQUE INDEX libraries_personal ON libraries (owner) WHERE personal = 1;
+			CREATE TABLE library_members (
+				library_id INTEGER NOT NULL REFERENCES libraries (id),
+				user TEXT NOT NULL,
+				PRIMARY KEY (library_id, user)
+			);
+			CREATE INDEX library_members_user ON library_members (user);
+			ALTER TABLE books ADD COLUMN library_id INTEGER REFERENCES libraries (id);
+			CREATE INDEX books_library ON books (library_id);
+		`,
+		Func: migrateUnowned,
+	},
+	{
+		Version: 3,
+		Name:    "add full-text search",
+		// The index keeps its own copy of each book, with tags as plain text
+		// rather than JSON so snippets read well
+		SQL: `
+			CREATE VIRTUAL TABLE books_fts USING fts5 (
+				title, author, genre, tags,
+				tokenize = 'unicode61 remove_diacritics 2'
+			);
+			INSERT INTO books_fts (rowid, title, author, genre, tags)
+			SELECT id, title, author, genre,
+				(SELECT group_concat(value, ', ') FROM json_each(CASE WHEN json_valid(tags) THEN tags ELSE '[]' END))
+			FROM books;
+			CREATE TRIGGER books_fts_insert AFTER INSERT ON books BEGIN
+				INSERT INTO books_fts (rowid, title, author, genre, tags)
+				VALUES (new.id, new.title, new.author, new.genre,
+					(SELECT group_concat(value, ', ') FROM json_each(CASE WHEN json_valid(new.tags) THEN new.tags ELSE '[]' END)));
+			END;
+			CREATE TRIGGER books_fts_update AFTER UPDATE ON books BEGIN
+				DELETE FROM books_fts WHERE rowid = old.id;
+				INSERT INTO books_fts (rowid, title, author, genre, tags)
+				VALUES (new.id, new.title, new.author, new.genre,
+					(SELECT group_concat(value, ', ') FROM json_each(CASE WHEN json_valid(new.tags) THEN new.tags ELSE '[]' END)));
+			END;
+			CREATE TRIGGER books_fts_delete AFTER DELETE ON books BEGIN
+				DELETE FROM books_fts WHERE rowid = old.id;
+			END;
+		`,
+	},
+	{
+		Version: 4,
+		Name:    "add ratings",
+		SQL:     "ALTER TABLE books ADD COLUMN rating INTEGER",
+	},
+	{
+		Version: 5,
+		Name:    "add book details",
+		// NULLs are distinct, so any number of books can have no ISBN
+		SQL: `
+			ALTER TABLE books ADD COLUMN isbn TEXT;
+			ALTER TABLE books ADD COLUMN subtitle TEXT;
+			ALTER TABLE books ADD COLUMN publisher TEXT;
+			ALTER TABLE books ADD COLUMN publish_date TEXT;
+			ALTER TABLE books ADD COLUMN pages INTEGER;
+			ALTER TABLE books ADD COLUMN language TEXT;
+			ALTER TABLE books ADD COLUMN description TEXT;
+			CREATE UNIQUE INDEX books_isbn ON books (library_id, isbn);
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "add isbn lookup cache",
+		// A NULL record is an ISBN no provider knew. Times are Unix
+		// milliseconds.
+		SQL: `
+			CREATE TABLE isbn_cache (
+				isbn TEXT PRIMARY KEY,
+				record TEXT,
+				cover_image TEXT,
+				fetched_at INTEGER NOT NULL,
+				expires_at INTEGER NOT NULL
+			);
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "normalize isbns",
+		// Lookups cached under ISBN-10s are fetched again under their
+		// ISBN-13s
+		SQL:  "DELETE FROM isbn_cache WHERE length(isbn) != 13",
+		Func: migrateISBNs,
+	},
+}
+
+// Migrate applies the pending migrations to the books database.
+func Migrate(ctx context.Context) error {
+	applied, err := migrate.Apply(ctx, DB, Migrations, false)
+	for _, m := range applied {
+		log.Printf("[INFO] books: applied migration %d (%s)\n", m.Version, m.Name)
 	}
+	return err
 }
@@ -0,0 +1,136 @@
+package books
+
+import (
+	"NbirdHttp/apierror"
+	"NbirdHttp/isbn"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The bibliographic details of a book beyond its title and author, as an
+// ISBN lookup finds them or as typed in. Nil fields are unknown.
+type bookDetails struct {
+	ISBN        *string
+	Subtitle    *string
+	Publisher   *string
+	PublishDate *string
+	Pages       *int
+	Language    *string
+	Description *string
+}
+
+// Parses the details of a create or update request; value returns a field
+// as sent, or "" if it wasn't
+func parseDetails(value func(name string) string) (bookDetails, error) {
+	text := func(name string) *string {
+		s := strings.TrimSpace(value(name))
+		if s == "" {
+			return nil
+		}
+		return &s
+	}
+	details := bookDetails{
+		Subtitle:    text("subtitle"),
+		Publisher:   text("publisher"),
+		PublishDate: text("publish_date"),
+		Language:    text("language"),
+		Description: text("description"),
+	}
+
+	if s := text("isbn"); s != nil {
+		isbn13, err := isbn.Normalize(*s)
+		if err != nil {
+			return details, apierror.Validation("Invalid ISBN: " + err.Error()).WithDetails(map[string]string{"field": "isbn"})
+		}
+		details.ISBN = &isbn13
+	}
+
+	if s := text("pages"); s != nil {
+		pages, err := strconv.Atoi(*s)
+		if err != nil || pages < 1 {
+			return details, apierror.Validation("Pages must be a whole number above 0").WithDetails(map[string]string{"field": "pages"})
+		}
+		details.Pages = &pages
+	}
+	return details, nil
+}
+
+// Fills the details that weren't sent from the book's current ones; sent
+// reports whether a field was. A detail sent empty is cleared.
+func (d *bookDetails) keep(book Book, sent func(name string) bool) {
+	for _, field := range []struct {
+		name           string
+		value, current **string
+	}{
+		{"isbn", &d.ISBN, &book.ISBN},
+		{"subtitle", &d.Subtitle, &book.Subtitle},
+		{"publisher", &d.Publisher, &book.Publisher},
+		{"publish_date", &d.PublishDate, &book.PublishDate},
+		{"language", &d.Language, &book.Language},
+		{"description", &d.Description, &book.Description},
+	} {
+		if !sent(field.name) {
+			*field.value = *field.current
+		}
+	}
+	if !sent("pages") {
+		d.Pages = book.Pages
+	}
+}
+
+// Reports a conflict if another book in the library has the ISBN, so a
+// book scanned twice is only added once. exceptID is the book being
+// updated, or 0.
+func checkISBN(ctx context.Context, isbn *string, libraryID int, exceptID any) error {
+	if isbn == nil {
+		return nil
+	}
+	id, title, err := findISBN(ctx, *isbn, libraryID, exceptID)
+	if err != nil || id == 0 {
+		return err
+	}
+	return apierror.Conflict(fmt.Sprintf("%q is already in this library with ISBN %s", title, *isbn)).
+		WithDetails(map[string]any{"field": "isbn", "book_id": id})
+}
+
+// Reports a write that failed because another book in the library has the
+// ISBN as the same conflict as checkISBN. The check before the write can't
+// stop two requests adding the ISBN at once; the unique index does.
+func isbnConflict(ctx context.Context, err error, isbn *string, libraryID int, exceptID any) error {
+	if !isUniqueViolation(err) {
+		return err
+	}
+	if conflict := checkISBN(ctx, isbn, libraryID, exceptID); conflict != nil {
+		return conflict
+	}
+	return err
+}
+
+// Reports whether err is a write breaking a unique index
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// Returns the ID and title of the book in the library with the ISBN, other
+// than exceptID, or an ID of 0 if there isn't one
+func findISBN(ctx context.Context, isbn string, libraryID int, exceptID any) (id int, title string, err error) {
+	err = DB.QueryRowContext(ctx,
+		"SELECT id, title FROM books WHERE library_id = ? AND isbn = ? AND id != ?",
+		libraryID, isbn, exceptID,
+	).Scan(&id, &title)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to check ISBN: %w", err)
+	}
+	return id, title, nil
+}
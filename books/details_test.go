@@ -0,0 +1,233 @@
+package books
+
+import (
+	"NbirdHttp/apierror"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestDuplicateISBN(t *testing.T) {
+	setup(t)
+
+	dune := createBook(t, "alice", map[string]any{"title": "Dune", "author": "Frank Herbert", "isbn": "978-0-441-17271-9"})
+	if dune.ISBN == nil || *dune.ISBN != "9780441172719" {
+		t.Fatalf("isbn = %v, want it saved as 9780441172719", dune.ISBN)
+	}
+	emma := createBook(t, "alice", map[string]any{"title": "Emma", "author": "Jane Austen"})
+	shared := decode[Library](t, serve(handleCreateLibrary,
+		newRequest("alice", "POST", "/api/books/libraries", `{"name": "Home"}`)))
+	sharedID := strconv.Itoa(shared.ID)
+	other := createBook(t, "alice", map[string]any{"title": "Dune", "author": "Frank Herbert", "isbn": "0441172717", "library_id": shared.ID})
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		handler http.HandlerFunc
+		id      string
+		want    int
+	}{
+		{
+			name:    "same isbn-13",
+			request: newRequest("alice", "POST", "/api/books", `{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441172719"}`),
+			handler: handleCreateBook,
+			want:    http.StatusConflict,
+		},
+		{
+			name:    "same book's isbn-10",
+			request: newRequest("alice", "POST", "/api/books", `{"title": "Dune", "author": "Frank Herbert", "isbn": "0-441-17271-7"}`),
+			handler: handleCreateBook,
+			want:    http.StatusConflict,
+		},
+		{
+			name:    "form with the same isbn",
+			request: newFormRequest("alice", "POST", "/api/books", map[string]string{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441172719"}),
+			handler: handleCreateBook,
+			want:    http.StatusConflict,
+		},
+		{
+			name:    "another isbn",
+			request: newRequest("alice", "POST", "/api/books", `{"title": "Emma", "author": "Jane Austen", "isbn": "9780141439587"}`),
+			handler: handleCreateBook,
+			want:    http.StatusCreated,
+		},
+		{
+			name:    "another user's library",
+			request: newRequest("bob", "POST", "/api/books", `{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441172719"}`),
+			handler: handleCreateBook,
+			want:    http.StatusCreated,
+		},
+		{
+			name:    "invalid isbn",
+			request: newRequest("alice", "POST", "/api/books", `{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441172710"}`),
+			handler: handleCreateBook,
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "update keeping its own isbn",
+			request: newFormRequest("alice", "PUT", "/", map[string]string{"title": "Dune", "author": "Frank Herbert", "isbn": "0441172717"}),
+			handler: handleUpdateBook,
+			id:      strconv.Itoa(dune.ID),
+			want:    http.StatusOK,
+		},
+		{
+			name:    "update to another book's isbn",
+			request: newFormRequest("alice", "PUT", "/", map[string]string{"title": "Emma", "author": "Jane Austen", "isbn": "9780441172719"}),
+			handler: handleUpdateBook,
+			id:      strconv.Itoa(emma.ID),
+			want:    http.StatusConflict,
+		},
+		{
+			name: "move into a library with the isbn",
+			request: newFormRequest("alice", "PUT", "/", map[string]string{"title": "Dune", "author": "Frank Herbert",
+				"library_id": strconv.Itoa(dune.LibraryID)}),
+			handler: handleUpdateBook,
+			id:      strconv.Itoa(other.ID),
+			want:    http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		rr := serve(tt.handler, tt.request, "id", tt.id)
+		if rr.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d; body = %s", tt.name, rr.Code, tt.want, rr.Body)
+			continue
+		}
+		// Conflicts point to the book already there
+		if rr.Code == http.StatusConflict {
+			body := decode[map[string]apierror.Error](t, rr)
+			details, _ := body["error"].Details.(map[string]any)
+			if details["field"] != "isbn" || details["book_id"] != float64(dune.ID) {
+				t.Errorf("%s: details = %v, want book_id %d", tt.name, details, dune.ID)
+			}
+		}
+	}
+
+	// The shared library's copy stays where it was
+	rr := serve(handleGetBook, newRequest("alice", "GET", "/", ""), "id", strconv.Itoa(other.ID))
+	if book := decode[Book](t, rr); strconv.Itoa(book.LibraryID) != sharedID {
+		t.Errorf("moved book is in library %d, want %s", book.LibraryID, sharedID)
+	}
+}
+
+func TestISBNConflictOnWrite(t *testing.T) {
+	setup(t)
+	ctx := context.Background()
+	dune := createBook(t, "alice", map[string]any{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441172719"})
+
+	// As if another request added the ISBN between the check and the insert
+	_, err := DB.Exec("INSERT INTO books (title, author, tags, library_id, isbn) VALUES ('Dune', 'Frank Herbert', '[]', ?, ?)",
+		dune.LibraryID, *dune.ISBN)
+	if !isUniqueViolation(err) {
+		t.Fatalf("duplicate insert: err = %v, want a unique violation", err)
+	}
+	var apiErr *apierror.Error
+	if !errors.As(isbnConflict(ctx, err, dune.ISBN, dune.LibraryID, 0), &apiErr) || apiErr.Code != apierror.CodeConflict {
+		t.Fatalf("isbnConflict = %v, want a conflict", apiErr)
+	}
+	if details, _ := apiErr.Details.(map[string]any); details["book_id"] != dune.ID {
+		t.Errorf("details = %v, want book_id %d", apiErr.Details, dune.ID)
+	}
+
+	other := errors.New("disk full")
+	if err := isbnConflict(ctx, other, dune.ISBN, dune.LibraryID, 0); err != other || isUniqueViolation(other) {
+		t.Errorf("isbnConflict(other error) = %v, want it unchanged", err)
+	}
+}
+
+func TestUpdateDetails(t *testing.T) {
+	setup(t)
+	dune := createBook(t, "alice", map[string]any{"title": "Dune", "author": "Frank Herbert",
+		"isbn": "9780441172719", "subtitle": "Book One", "publisher": "Ace", "pages": 412})
+	id := strconv.Itoa(dune.ID)
+
+	update := func(fields map[string]string) Book {
+		t.Helper()
+		fields["title"], fields["author"] = "Dune", "Frank Herbert"
+		rr := serve(handleUpdateBook, newFormRequest("alice", "PUT", "/", fields), "id", id)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("update %v: status = %d, body = %s", fields, rr.Code, rr.Body)
+		}
+		return decode[Book](t, rr)
+	}
+
+	// Details left out keep their values
+	book := update(map[string]string{"publisher": "Chilton"})
+	if book.ISBN == nil || book.Subtitle == nil || book.Pages == nil || *book.Pages != 412 ||
+		book.Publisher == nil || *book.Publisher != "Chilton" {
+		t.Errorf("book = %+v, want only the publisher changed", book)
+	}
+
+	// and details sent empty are cleared
+	book = update(map[string]string{"subtitle": "", "pages": "", "isbn": " "})
+	if book.Subtitle != nil || book.Pages != nil || book.ISBN != nil {
+		t.Errorf("subtitle %v, pages %v, isbn %v, want them cleared", book.Subtitle, book.Pages, book.ISBN)
+	}
+	if book.Publisher == nil || *book.Publisher != "Chilton" {
+		t.Errorf("publisher = %v, want it kept", book.Publisher)
+	}
+}
+
+func TestListDetailFilters(t *testing.T) {
+	setup(t)
+
+	dune := createBook(t, "alice", map[string]any{"title": "Dune", "author": "Frank Herbert",
+		"isbn": "9780441172719", "publisher": "Ace", "language": "en"})
+	messiah := createBook(t, "alice", map[string]any{"title": "Dune Messiah", "author": "Frank Herbert",
+		"isbn": "9780593098233", "publisher": "Ace", "language": "en"})
+	dune2 := createBook(t, "alice", map[string]any{"title": "Der Wüstenplanet", "author": "Frank Herbert",
+		"isbn": "9783453317178", "publisher": "Heyne", "language": "de"})
+	legacy := createBook(t, "alice", map[string]any{"title": "Notes", "author": "Anon"})
+	// Saved before ISBNs were checked
+	if _, err := DB.Exec("UPDATE books SET isbn = 'unknown-1' WHERE id = ?", legacy.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Other users' books never match
+	createBook(t, "bob", map[string]any{"title": "Dune", "author": "Frank Herbert",
+		"isbn": "9780441172719", "publisher": "Ace", "language": "en"})
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  []int
+	}{
+		{"isbn-13", url.Values{"isbn": {"9780441172719"}}, []int{dune.ID}},
+		{"isbn-13 with dashes", url.Values{"isbn": {"978-0-441-17271-9"}}, []int{dune.ID}},
+		{"isbn-10", url.Values{"isbn": {"0441172717"}}, []int{dune.ID}},
+		{"isbn-10 with dashes", url.Values{"isbn": {"0-441-17271-7"}}, []int{dune.ID}},
+		{"unknown isbn", url.Values{"isbn": {"9780141439587"}}, nil},
+		{"invalid isbn as saved", url.Values{"isbn": {"unknown-1"}}, []int{legacy.ID}},
+		{"publisher", url.Values{"publisher": {"Ace"}}, []int{messiah.ID, dune.ID}},
+		{"other publisher", url.Values{"publisher": {"Heyne"}}, []int{dune2.ID}},
+		{"unknown publisher", url.Values{"publisher": {"Tor"}}, nil},
+		{"language", url.Values{"language": {"de"}}, []int{dune2.ID}},
+		{"publisher and language", url.Values{"publisher": {"Ace"}, "language": {"en"}}, []int{messiah.ID, dune.ID}},
+		{"publisher and other language", url.Values{"publisher": {"Ace"}, "language": {"de"}}, nil},
+		{"isbn and publisher", url.Values{"isbn": {"0441172717"}, "publisher": {"Ace"}}, []int{dune.ID}},
+		{"search and language", url.Values{"q": {"dune"}, "language": {"en"}, "sort": {"title"}}, []int{dune.ID, messiah.ID}},
+	}
+	for _, tt := range tests {
+		for _, handler := range []http.HandlerFunc{handleListBooks, handleListBooksV2} {
+			rr := serve(handler, newRequest("alice", "GET", "/api/books?"+tt.query.Encode(), ""))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("%s: status = %d, body = %s", tt.name, rr.Code, rr.Body)
+			}
+			var books []Book
+			if rr.Body.Bytes()[0] == '[' {
+				books = decode[[]Book](t, rr)
+			} else {
+				books = decode[BookPage](t, rr).Books
+			}
+			var got []int
+			for _, book := range books {
+				got = append(got, book.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%s: listed %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
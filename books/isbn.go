@@ -1,14 +1,18 @@
 package books
 
 import (
+	"NbirdHttp/apierror"
+	"NbirdHttp/isbn"
+	"NbirdHttp/lookup"
+	"context"
+	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -22,38 +26,17 @@ type ISBNResponse struct {
 	Publisher   *string `json:"publisher"`
 	PublishDate *string `json:"publish_date"`
 	Pages       *int    `json:"pages"`
-}
-
-type OpenLibraryBook struct {
-	Title         string                 `json:"title"`
-	Authors       []OpenLibraryAuthorRef `json:"authors"`
-	Works         []OpenLibraryWorkRef   `json:"works"`
-	Subjects      []interface{}          `json:"subjects"`
-	Publishers    []string               `json:"publishers"`
-	PublishDate   string                 `json:"publish_date"`
-	NumberOfPages *int                   `json:"number_of_pages"`
-}
-
-type OpenLibraryAuthorRef struct {
-	Key string `json:"key"`
-}
-
-type OpenLibraryWorkRef struct {
-	Key string `json:"key"`
-}
-
-type OpenLibraryAuthor struct {
-	Name string `json:"name"`
-}
-
-type OpenLibraryWork struct {
-	Authors  []OpenLibraryWorkAuthor `json:"authors"`
-	Subjects []interface{}           `json:"subjects"`
-}
-
-type OpenLibraryWorkAuthor struct {
-	Author OpenLibraryAuthorRef `json:"author"`
-	Key    string               `json:"key"`
+	Subtitle    *string `json:"subtitle"`
+	// ISO 639 code, e.g. eng or en
+	Language    *string `json:"language"`
+	Description *string `json:"description"`
+	// The providers the details came from, highest priority first
+	Sources []string `json:"sources"`
+	// Whether the details were cached from an earlier lookup
+	Cached bool `json:"cached"`
+	// Whether some optional details couldn't be fetched, such as the
+	// author's name; looking the ISBN up again later may find more
+	Partial bool `json:"partial"`
 }
 
 var genrePatterns = []struct {
@@ -81,26 +64,12 @@ var genrePatterns = []struct {
 	{[]string{"classic"}, "Classics"},
 }
 
-func extractGenre(subjects []interface{}) *string {
-	if len(subjects) == 0 {
-		return nil
-	}
-
+func extractGenre(subjects []string) *string {
 	var subjectStrs []string
-	for _, s := range subjects {
-		var subjectStr string
-		switch v := s.(type) {
-		case string:
-			subjectStr = v
-		case map[string]interface{}:
-			if name, ok := v["name"].(string); ok {
-				subjectStr = name
-			}
-		}
-
-		subjectStr = strings.ToLower(subjectStr)
-		if subjectStr != "" && subjectStr != "fiction" && subjectStr != "general" {
-			subjectStrs = append(subjectStrs, subjectStr)
+	for _, subject := range subjects {
+		subject = strings.ToLower(subject)
+		if subject != "" && subject != "fiction" && subject != "general" {
+			subjectStrs = append(subjectStrs, subject)
 		}
 	}
 
@@ -117,164 +86,231 @@ func extractGenre(subjects []interface{}) *string {
 	return nil
 }
 
-func handleISBNLookup(w http.ResponseWriter, r *http.Request) {
-	isbn := r.PathValue("isbn")
+// Returns an ISBN-10 or ISBN-13 as the ISBN-13 books and cached lookups are
+// saved under, or a validation error saying what's wrong with it
+func normalizeISBN(s string) (string, error) {
+	isbn13, err := isbn.Normalize(s)
+	if err != nil {
+		return "", apierror.Validation("Invalid ISBN: " + err.Error())
+	}
+	return isbn13, nil
+}
 
-	// Clean ISBN (remove dashes and spaces)
-	cleanIsbn := regexp.MustCompile(`[-\s]`).ReplaceAllString(isbn, "")
+// Rewrites books' ISBNs as ISBN-13s. ISBNs that aren't valid, or whose
+// ISBN-13 another book in the library already has, are left as they were.
+func migrateISBNs(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, library_id, isbn FROM books WHERE isbn IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	type bookISBN struct {
+		id, libraryID int
+		isbn          string
+	}
+	var books []bookISBN
+	for rows.Next() {
+		var b bookISBN
+		if err := rows.Scan(&b.id, &b.libraryID, &b.isbn); err != nil {
+			rows.Close()
+			return err
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	// Validate ISBN format
-	isbn10Pattern := regexp.MustCompile(`^\d{9}[\dX]$`)
-	isbn13Pattern := regexp.MustCompile(`^\d{13}$`)
-	if !isbn10Pattern.MatchString(cleanIsbn) && !isbn13Pattern.MatchString(cleanIsbn) {
-		http.Error(w, "Invalid ISBN format", http.StatusBadRequest)
-		return
+	for _, b := range books {
+		isbn13, err := isbn.Normalize(b.isbn)
+		if err != nil {
+			log.Printf("[WARN] books: book %d keeps invalid ISBN %s: %v\n", b.id, b.isbn, err)
+			continue
+		}
+		if isbn13 == b.isbn {
+			continue
+		}
+		var taken bool
+		err = tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM books WHERE library_id = ? AND isbn = ?)", b.libraryID, isbn13,
+		).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			log.Printf("[WARN] books: book %d keeps ISBN %s, as another book in its library has %s\n", b.id, b.isbn, isbn13)
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE books SET isbn = ? WHERE id = ?", isbn13, b.id); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// The providers ISBN lookups ask, highest priority first, and the client
+// covers are downloaded with
+var (
+	lookupClient = lookup.NewClient(lookup.DefaultOptions)
+	providers    = []lookup.MetadataProvider{&lookup.OpenLibrary{Client: lookupClient}}
+)
+
+// How long a lookup may take in all, however many requests it makes
+const maxLookupTime = 30 * time.Second
+
+// The largest cover image downloaded; larger ones are skipped
+const maxCoverSize = 5 << 20
 
-	// Fetch book data from Open Library
-	bookURL := fmt.Sprintf("https://openlibrary.org/isbn/%s.json", cleanIsbn)
-	bookResp, err := http.Get(bookURL)
+// SetLookup sets the providers ISBN lookups ask for books' details, highest
+// priority first, and the client that downloads their covers.
+func SetLookup(p []lookup.MetadataProvider, client *http.Client) {
+	providers = p
+	lookupClient = client
+}
+
+func handleISBNLookup(w http.ResponseWriter, r *http.Request) {
+	cleanIsbn, err := normalizeISBN(r.PathValue("isbn"))
 	if err != nil {
-		log.Printf("[ERROR] Failed to fetch book data: %v\n", err)
-		http.Error(w, "Failed to lookup ISBN", http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
-	defer bookResp.Body.Close()
 
-	if bookResp.StatusCode != http.StatusOK {
-		http.Error(w, "Book not found", http.StatusNotFound)
+	record, coverPath, cached, err := lookupISBN(r.Context(), cleanIsbn)
+	if errors.Is(err, lookup.ErrNotFound) {
+		apierror.Write(w, r, apierror.NotFound("Book not found"))
 		return
 	}
-
-	var bookData OpenLibraryBook
-	if err := json.NewDecoder(bookResp.Body).Decode(&bookData); err != nil {
-		log.Printf("[ERROR] Failed to decode book data: %v\n", err)
-		http.Error(w, "Failed to parse book data", http.StatusInternalServerError)
+	if errors.Is(err, context.DeadlineExceeded) && r.Context().Err() == nil {
+		log.Printf("[ERROR] Looking up ISBN %s took over %v\n", cleanIsbn, maxLookupTime)
+		apierror.Write(w, r, apierror.UpstreamFailed("Looking up the ISBN took too long"))
 		return
 	}
-
-	// Extract author
-	authorName := "Unknown Author"
-	if len(bookData.Authors) > 0 {
-		authorKey := bookData.Authors[0].Key
-		authorURL := fmt.Sprintf("https://openlibrary.org%s.json", authorKey)
-		authorResp, err := http.Get(authorURL)
-		if err == nil {
-			defer authorResp.Body.Close()
-			if authorResp.StatusCode == http.StatusOK {
-				var authorData OpenLibraryAuthor
-				if err := json.NewDecoder(authorResp.Body).Decode(&authorData); err == nil {
-					if authorData.Name != "" {
-						authorName = authorData.Name
-					}
-				}
-			}
-		}
+	if err != nil {
+		log.Printf("[ERROR] Failed to lookup ISBN %s: %v\n", cleanIsbn, err)
+		apierror.Write(w, r, apierror.UpstreamFailed("Failed to lookup ISBN"))
+		return
 	}
 
-	var genre *string
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newISBNResponse(cleanIsbn, record, coverPath, cached))
+}
 
-	// Always fetch work for subjects (and author fallback)
-	if len(bookData.Works) > 0 {
-		workKey := bookData.Works[0].Key
-		workURL := fmt.Sprintf("https://openlibrary.org%s.json", workKey)
-		workResp, err := http.Get(workURL)
-		if err == nil {
-			defer workResp.Body.Close()
-			if workResp.StatusCode == http.StatusOK {
-				var workData OpenLibraryWork
-				if err := json.NewDecoder(workResp.Body).Decode(&workData); err == nil {
-					// Get author from work if not already found
-					if authorName == "Unknown Author" && len(workData.Authors) > 0 {
-						var authorKey string
-						if workData.Authors[0].Author.Key != "" {
-							authorKey = workData.Authors[0].Author.Key
-						} else if workData.Authors[0].Key != "" {
-							authorKey = workData.Authors[0].Key
-						}
+// Looks up an ISBN-13, answering from the cache if it can, and saves its
+// cover. It returns lookup.ErrNotFound if no provider knows the ISBN, and
+// context.DeadlineExceeded if the lookup takes longer than maxLookupTime.
+func lookupISBN(ctx context.Context, isbn13 string) (record *lookup.Record, coverPath *string, cached bool, err error) {
+	record, coverPath, cached, err = cachedLookup(ctx, isbn13)
+	if err != nil {
+		log.Printf("[WARN] %v\n", err)
+	}
+	if cached && record == nil {
+		return nil, nil, true, lookup.ErrNotFound
+	}
 
-						if authorKey != "" {
-							authorURL := fmt.Sprintf("https://openlibrary.org%s.json", authorKey)
-							authorResp, err := http.Get(authorURL)
-							if err == nil {
-								defer authorResp.Body.Close()
-								if authorResp.StatusCode == http.StatusOK {
-									var authorData OpenLibraryAuthor
-									if err := json.NewDecoder(authorResp.Body).Decode(&authorData); err == nil {
-										if authorData.Name != "" {
-											authorName = authorData.Name
-										}
-									}
-								}
-							}
-						}
-					}
+	lookupCtx, cancel := context.WithTimeout(ctx, maxLookupTime)
+	defer cancel()
 
-					// Get genre from work subjects
-					if len(workData.Subjects) > 0 {
-						genre = extractGenre(workData.Subjects)
-					}
-				}
+	if !cached {
+		record, err = lookup.Lookup(lookupCtx, providers, isbn13)
+		if errors.Is(err, lookup.ErrNotFound) {
+			if err := cacheLookup(ctx, isbn13, nil, nil); err != nil {
+				log.Printf("[WARN] %v\n", err)
 			}
+			return nil, nil, false, err
+		}
+		if err != nil {
+			return nil, nil, false, err
 		}
 	}
 
-	// Try book-level subjects if work didn't have any
-	if genre == nil && len(bookData.Subjects) > 0 {
-		genre = extractGenre(bookData.Subjects)
-	}
-
-	// Download cover image
-	var coverPath *string
-	coverURL := fmt.Sprintf("https://covers.openlibrary.org/b/isbn/%s-L.jpg", cleanIsbn)
-	coverResp, err := http.Get(coverURL)
-	if err == nil {
-		defer coverResp.Body.Close()
-		if coverResp.StatusCode == http.StatusOK {
-			body, err := io.ReadAll(coverResp.Body)
-			if err == nil {
-				// Check if it's actually an image (Open Library returns a 1x1 pixel for missing covers)
-				// Only save if larger than 1KB (placeholder images are tiny)
-				if len(body) > 1000 {
-					filename := fmt.Sprintf("%s-%d.jpg", cleanIsbn, time.Now().Unix())
-					filePath := filepath.Join(coversDir, filename)
-
-					if err := os.WriteFile(filePath, body, 0644); err == nil {
-						path := "/books/covers/" + filename
-						coverPath = &path
-					} else {
-						log.Printf("[WARN] Failed to save cover image: %v\n", err)
-					}
-				}
-			}
+	// Cached lookups without a cover had none to download; those whose
+	// cover has since been deleted fetch it again
+	if !cached || (coverPath != nil && !coverExists(*coverPath)) {
+		coverPath = existingCover(isbn13)
+		if coverPath == nil {
+			coverPath = saveCover(lookupCtx, isbn13, record.Covers)
+		}
+		if err := cacheLookup(ctx, isbn13, record, coverPath); err != nil {
+			log.Printf("[WARN] %v\n", err)
 		}
 	}
+	return record, coverPath, cached, nil
+}
 
-	// Build response
+// Builds the preview of a book from what a lookup found
+func newISBNResponse(isbn13 string, record *lookup.Record, coverPath *string, cached bool) ISBNResponse {
 	result := ISBNResponse{
-		ISBN:       cleanIsbn,
-		Title:      bookData.Title,
-		Author:     authorName,
-		Genre:      genre,
+		ISBN:       isbn13,
+		Title:      record.Title,
+		Author:     strings.Join(record.Authors, ", "),
+		Genre:      extractGenre(record.Subjects),
 		CoverImage: coverPath,
+		Sources:    record.Sources,
+		Cached:     cached,
+		Partial:    len(record.Warnings) > 0,
 	}
 
-	if bookData.Title == "" {
+	if result.Title == "" {
 		result.Title = "Unknown Title"
 	}
-
-	if len(bookData.Publishers) > 0 {
-		result.Publisher = &bookData.Publishers[0]
+	if result.Author == "" {
+		result.Author = "Unknown Author"
 	}
 
-	if bookData.PublishDate != "" {
-		result.PublishDate = &bookData.PublishDate
+	optional := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return &s
 	}
-
-	if bookData.NumberOfPages != nil {
-		result.Pages = bookData.NumberOfPages
+	result.Subtitle = optional(record.Subtitle)
+	result.Publisher = optional(record.Publisher)
+	result.PublishDate = optional(record.PublishDate)
+	result.Language = optional(record.Language)
+	result.Description = optional(record.Description)
+	if record.Pages > 0 {
+		result.Pages = &record.Pages
 	}
+	return result
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+// Downloads the first of the cover URLs that has an image to the covers
+// directory as the ISBN's cover, returning its path, or nil if none has.
+// Covers already on the server are used as they are.
+func saveCover(ctx context.Context, isbn string, urls []string) *string {
+	for _, url := range urls {
+		if strings.HasPrefix(url, "/books/covers/") {
+			return &url
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			continue
+		}
+		resp, err := lookupClient.Do(req)
+		if err != nil {
+			log.Printf("[WARN] Failed to fetch cover %s: %v\n", url, err)
+			continue
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxCoverSize+1))
+		resp.Body.Close()
+		if err == nil && len(body) > maxCoverSize {
+			log.Printf("[WARN] Cover %s is larger than %d bytes\n", url, maxCoverSize)
+			continue
+		}
+		// Placeholders for missing covers are tiny, so only save images
+		// larger than 1KB
+		if err != nil || resp.StatusCode != http.StatusOK || len(body) <= 1000 {
+			continue
+		}
+
+		filename := coverFilename(isbn)
+		if err := os.WriteFile(filepath.Join(coversDir, filename), body, 0644); err != nil {
+			log.Printf("[WARN] Failed to save cover image: %v\n", err)
+			return nil
+		}
+		path := "/books/covers/" + filename
+		return &path
+	}
+	return nil
 }
@@ -0,0 +1,381 @@
+package books
+
+import (
+	"NbirdHttp/apierror"
+	"NbirdHttp/auth"
+	"NbirdHttp/events"
+	"NbirdHttp/isbn"
+	"NbirdHttp/lookup"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The most ISBNs one import may have, and how long a finished import's
+// report is kept to be fetched
+const (
+	maxImportISBNs = 100
+	importKeepTime = 24 * time.Hour
+)
+
+// What became of an ISBN in an import
+const (
+	importPending   = "pending"
+	importAdded     = "added"
+	importDuplicate = "duplicate"
+	importInvalid   = "invalid"
+	importNotFound  = "not_found"
+	importFailed    = "failed"
+)
+
+type isbnImportInput struct {
+	// ISBN-10s or ISBN-13s, with or without dashes
+	ISBNs []string `json:"isbns"`
+	// The library to add the books to; the user's own if omitted
+	LibraryID int `json:"library_id,omitempty"`
+}
+
+// ISBNImport is a batch of ISBNs being looked up and added to a library in
+// the background.
+type ISBNImport struct {
+	ID        string `json:"id"`
+	LibraryID int    `json:"library_id"`
+	// running, done, or cancelled if the server shut down first
+	Status string `json:"status"`
+	Total  int    `json:"total"`
+	// ISBNs finished with, whatever became of them
+	Processed int `json:"processed"`
+	Added     int `json:"added"`
+	// Duplicates and invalid ISBNs
+	Skipped int `json:"skipped"`
+	// ISBNs no provider knew, or whose lookup failed
+	Failed     int                `json:"failed"`
+	Results    []ISBNImportResult `json:"results,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	FinishedAt *time.Time         `json:"finished_at"`
+}
+
+// ISBNImportResult is what became of one ISBN in an import.
+type ISBNImportResult struct {
+	// The ISBN as it was sent
+	Input string `json:"input"`
+	// The ISBN-13, if the ISBN is valid
+	ISBN string `json:"isbn,omitempty"`
+	// pending, added, duplicate, invalid, not_found or failed
+	Status string `json:"status"`
+	// The book added, or the one the library already had with the ISBN
+	BookID int    `json:"book_id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ISBNImportProgress is sent as a book.import.progress event each time an
+// ISBN is finished with.
+type ISBNImportProgress struct {
+	// The import's counts, without its results
+	Import ISBNImport       `json:"import"`
+	Result ISBNImportResult `json:"result"`
+}
+
+type isbnImport struct {
+	ISBNImport
+	user string
+}
+
+// Imports are only kept in memory, as one cut short by a restart can be
+// sent again: the books it added are skipped as duplicates
+var (
+	importsMu sync.Mutex
+	imports   = map[string]*isbnImport{}
+
+	importsCtx, stopImports = context.WithCancel(context.Background())
+	importsRunning          sync.WaitGroup
+)
+
+// Returns a copy of the import, with its results if withResults is set.
+// importsMu must be held.
+func (imp *isbnImport) snapshot(withResults bool) ISBNImport {
+	s := imp.ISBNImport
+	s.Results = nil
+	if withResults {
+		s.Results = append([]ISBNImportResult(nil), imp.Results...)
+	}
+	return s
+}
+
+// Counts an ISBN that has been finished with. importsMu must be held.
+func (imp *isbnImport) count(result ISBNImportResult) {
+	imp.Processed++
+	switch result.Status {
+	case importAdded:
+		imp.Added++
+	case importDuplicate, importInvalid:
+		imp.Skipped++
+	case importNotFound, importFailed:
+		imp.Failed++
+	}
+}
+
+// Drops the imports that finished long enough ago. importsMu must be held.
+func pruneImports() {
+	for id, imp := range imports {
+		if imp.FinishedAt != nil && time.Since(*imp.FinishedAt) > importKeepTime {
+			delete(imports, id)
+		}
+	}
+}
+
+// Cancels the imports that are running, waiting for each to finish the
+// ISBN it is on
+func stopISBNImports(ctx context.Context) error {
+	stopImports()
+
+	finished := make(chan struct{})
+	go func() {
+		importsRunning.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func handleImportISBNs(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	var input isbnImportInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid JSON"))
+		return
+	}
+	if len(input.ISBNs) == 0 {
+		apierror.Write(w, r, apierror.Validation("At least one ISBN is required").WithDetails(map[string]string{"field": "isbns"}))
+		return
+	}
+	if len(input.ISBNs) > maxImportISBNs {
+		apierror.Write(w, r, apierror.Validation(fmt.Sprintf("At most %d ISBNs can be imported at once", maxImportISBNs)).
+			WithDetails(map[string]string{"field": "isbns"}))
+		return
+	}
+
+	libraryIDStr := ""
+	if input.LibraryID != 0 {
+		libraryIDStr = strconv.Itoa(input.LibraryID)
+	}
+	libraryID, err := targetLibrary(r.Context(), libraryIDStr, user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	imp := &isbnImport{
+		ISBNImport: ISBNImport{
+			ID:        hex.EncodeToString(id),
+			LibraryID: libraryID,
+			Status:    "running",
+			Total:     len(input.ISBNs),
+			Results:   make([]ISBNImportResult, len(input.ISBNs)),
+			CreatedAt: time.Now().UTC(),
+		},
+		user: user,
+	}
+	// Invalid and repeated ISBNs are skipped straight away
+	seen := map[string]bool{}
+	for i, s := range input.ISBNs {
+		result := ISBNImportResult{Input: s, Status: importPending}
+		isbn13, err := isbn.Normalize(s)
+		switch {
+		case err != nil:
+			result.Status = importInvalid
+			result.Error = "Invalid ISBN: " + err.Error()
+		case seen[isbn13]:
+			result.ISBN = isbn13
+			result.Status = importDuplicate
+			result.Error = "Listed earlier in the import"
+		default:
+			result.ISBN = isbn13
+			seen[isbn13] = true
+		}
+		imp.Results[i] = result
+		if result.Status != importPending {
+			imp.count(result)
+		}
+	}
+
+	importsMu.Lock()
+	pruneImports()
+	for _, other := range imports {
+		if other.user == user && other.Status == "running" {
+			importsMu.Unlock()
+			apierror.Write(w, r, apierror.Conflict("An ISBN import is already running").
+				WithDetails(map[string]string{"import_id": other.ID}))
+			return
+		}
+	}
+	imports[imp.ID] = imp
+	accepted := imp.snapshot(true)
+	importsRunning.Add(1)
+	importsMu.Unlock()
+
+	log.Printf("[INFO] %s started importing %d ISBNs into library %d\n", user, imp.Total, libraryID)
+	go runImport(imp)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(accepted)
+}
+
+func handleGetImport(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	importsMu.Lock()
+	imp, ok := imports[r.PathValue("id")]
+	// Other users' imports are reported as not found
+	ok = ok && imp.user == user
+	var s ISBNImport
+	if ok {
+		s = imp.snapshot(true)
+	}
+	importsMu.Unlock()
+	if !ok {
+		apierror.Write(w, r, apierror.NotFound("Import not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// Adds the import's books one ISBN at a time, so a large import doesn't
+// flood the providers, publishing its progress to the user
+func runImport(imp *isbnImport) {
+	defer importsRunning.Done()
+
+	// Only this goroutine changes the results, so it reads them unlocked
+	for i, result := range imp.Results {
+		if importsCtx.Err() != nil {
+			break
+		}
+		if result.Status != importPending {
+			continue
+		}
+		result = importISBN(importsCtx, imp, result)
+		if result.Status == importFailed && importsCtx.Err() != nil {
+			// Cut short by the shutdown, so it is left pending
+			break
+		}
+
+		importsMu.Lock()
+		imp.Results[i] = result
+		imp.count(result)
+		progress := ISBNImportProgress{Import: imp.snapshot(false), Result: result}
+		importsMu.Unlock()
+		events.Publish("book.import.progress", imp.user, progress)
+	}
+
+	importsMu.Lock()
+	now := time.Now().UTC()
+	imp.FinishedAt = &now
+	imp.Status = "done"
+	if imp.Processed < imp.Total {
+		imp.Status = "cancelled"
+	}
+	done := imp.snapshot(true)
+	importsMu.Unlock()
+
+	log.Printf("[INFO] %s's ISBN import %s %s: %d added, %d skipped, %d failed\n",
+		imp.user, imp.ID, done.Status, done.Added, done.Skipped, done.Failed)
+	events.Publish("book.import.done", imp.user, done)
+}
+
+// Looks up a pending ISBN and adds its book to the import's library, unless
+// the library already has it
+func importISBN(ctx context.Context, imp *isbnImport, result ISBNImportResult) ISBNImportResult {
+	fail := func(message string, err error) ISBNImportResult {
+		// Imports cut short by the shutdown aren't failures
+		if ctx.Err() == nil {
+			log.Printf("[ERROR] Failed to import ISBN %s: %v\n", result.ISBN, err)
+		}
+		result.Status = importFailed
+		result.Error = message
+		return result
+	}
+
+	id, title, err := findISBN(ctx, result.ISBN, imp.LibraryID, 0)
+	if err != nil {
+		return fail("Failed to check the library for the ISBN", err)
+	}
+	if id != 0 {
+		result.Status = importDuplicate
+		result.BookID = id
+		result.Title = title
+		return result
+	}
+
+	record, coverPath, cached, err := lookupISBN(ctx, result.ISBN)
+	if errors.Is(err, lookup.ErrNotFound) {
+		result.Status = importNotFound
+		result.Error = "Book not found"
+		return result
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fail("Looking up the ISBN took too long", err)
+	}
+	if err != nil {
+		return fail("Failed to lookup ISBN", err)
+	}
+
+	preview := newISBNResponse(result.ISBN, record, coverPath, cached)
+	result.Title = preview.Title
+	insert, err := DB.ExecContext(ctx, `
+		INSERT INTO books (title, author, genre, read_status, cover_image, is_signed, tags, library_id,
+			isbn, subtitle, publisher, publish_date, pages, language, description)
+		VALUES (?, ?, ?, 'unread', ?, 0, '[]', ?, ?, ?, ?, ?, ?, ?, ?)
+	`, preview.Title, preview.Author, preview.Genre, preview.CoverImage, imp.LibraryID,
+		preview.ISBN, preview.Subtitle, preview.Publisher, preview.PublishDate, preview.Pages, preview.Language, preview.Description)
+	if err != nil {
+		// Added meanwhile by someone else in the library
+		if isUniqueViolation(err) {
+			if id, _, _ := findISBN(ctx, result.ISBN, imp.LibraryID, 0); id != 0 {
+				result.Status = importDuplicate
+				result.BookID = id
+				return result
+			}
+		}
+		return fail("Failed to add the book", err)
+	}
+	bookID, err := insert.LastInsertId()
+	if err != nil {
+		return fail("Failed to add the book", err)
+	}
+	result.Status = importAdded
+	result.BookID = int(bookID)
+
+	book, err := getBook(ctx, bookID, imp.user)
+	if err != nil {
+		log.Printf("[WARN] Failed to fetch imported book %d: %v\n", bookID, err)
+		return result
+	}
+	publish(ctx, "book.created", book.LibraryID, book)
+	return result
+}
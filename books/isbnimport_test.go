@@ -0,0 +1,260 @@
+package books
+
+import (
+	"NbirdHttp/lookup"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A provider whose lookups wait until release is closed, then find a book,
+// sending each ISBN to started as they begin
+type blockingProvider struct {
+	started chan string
+	release chan struct{}
+}
+
+func (p *blockingProvider) Name() string { return "blocking" }
+
+func (p *blockingProvider) Lookup(ctx context.Context, isbn string) (*lookup.Record, error) {
+	p.started <- isbn
+	select {
+	case <-p.release:
+		return &lookup.Record{Title: "Book " + isbn}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Gives the test imports of its own, stopping those it leaves running
+func setupImports(t *testing.T) {
+	t.Helper()
+	importsMu.Lock()
+	imports = map[string]*isbnImport{}
+	importsMu.Unlock()
+	importsCtx, stopImports = context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		stopISBNImports(context.Background())
+		importsCtx, stopImports = context.WithCancel(context.Background())
+	})
+}
+
+func startImport(t *testing.T, user string, isbns ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(isbnImportInput{ISBNs: isbns})
+	return serve(handleImportISBNs, newRequest(user, "POST", "/api/books/import/isbn", string(body)))
+}
+
+// Returns the ID of the import started
+func importID(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	imp := decode[ISBNImport](t, rr)
+	if imp.ID == "" {
+		t.Fatalf("import: status = %d, body = %s", rr.Code, rr.Body)
+	}
+	return imp.ID
+}
+
+// Waits for the import to finish and returns its report
+func waitImport(t *testing.T, user, id string) ISBNImport {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rr := serve(handleGetImport, newRequest(user, "GET", "/api/books/import/isbn/"+id, ""), "id", id)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("get import: status = %d, body = %s", rr.Code, rr.Body)
+		}
+		imp := decode[ISBNImport](t, rr)
+		if imp.Status != "running" {
+			return imp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("import still running: %+v", imp)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestImportISBNs(t *testing.T) {
+	setup(t)
+	setupImports(t)
+	provider := &fakeProvider{
+		name: "fake",
+		records: map[string]*lookup.Record{
+			duneISBN13:      {Title: "Dune", Authors: []string{"Frank Herbert"}, Publisher: "Ace"},
+			"9780141439587": {Title: "Emma", Authors: []string{"Jane Austen"}},
+		},
+		errs: map[string]error{"9780593098233": errors.New("unavailable")},
+	}
+	setLookup(t, provider)
+	emma := createBook(t, "alice", map[string]any{"title": "Emma", "author": "Jane Austen", "isbn": "9780141439587"})
+
+	res := startImport(t, "alice",
+		"0-441-17271-7",  // added
+		"9780441172719",  // the same book, listed again
+		"978-0141439587", // already in the library
+		"12345",          // invalid
+		"9780441172710",  // wrong check digit
+		"9783453317178",  // not found
+		"9780593098233",  // lookup fails
+	)
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("import: status = %d, body = %s", res.Code, res.Body)
+	}
+	imp := waitImport(t, "alice", importID(t, res))
+
+	want := []struct {
+		status string
+		isbn   string
+	}{
+		{importAdded, duneISBN13},
+		{importDuplicate, duneISBN13},
+		{importDuplicate, "9780141439587"},
+		{importInvalid, ""},
+		{importInvalid, ""},
+		{importNotFound, "9783453317178"},
+		{importFailed, "9780593098233"},
+	}
+	if len(imp.Results) != len(want) {
+		t.Fatalf("results = %+v", imp.Results)
+	}
+	for i, w := range want {
+		if got := imp.Results[i]; got.Status != w.status || got.ISBN != w.isbn {
+			t.Errorf("result %d (%s) = %s %q, want %s %q", i, got.Input, got.Status, got.ISBN, w.status, w.isbn)
+		}
+	}
+	if imp.Status != "done" || imp.Total != 7 || imp.Processed != 7 || imp.Added != 1 || imp.Skipped != 4 || imp.Failed != 2 {
+		t.Errorf("import = %+v, want done with 1 added, 4 skipped and 2 failed", imp)
+	}
+	if imp.FinishedAt == nil {
+		t.Error("finished import has no finished_at")
+	}
+	if id := imp.Results[2].BookID; id != emma.ID {
+		t.Errorf("duplicate of the library's book has book_id %d, want %d", id, emma.ID)
+	}
+
+	added := imp.Results[0]
+	rr := serve(handleGetBook, newRequest("alice", "GET", "/", ""), "id", fmt.Sprint(added.BookID))
+	if book := decode[Book](t, rr); book.Title != "Dune" || book.ISBN == nil || *book.ISBN != duneISBN13 ||
+		book.Publisher == nil || *book.Publisher != "Ace" {
+		t.Errorf("added book = %+v", book)
+	}
+
+	// Imported again, everything found is already there
+	imp = waitImport(t, "alice", importID(t, startImport(t, "alice", duneISBN10, "9780141439587")))
+	if imp.Added != 0 || imp.Skipped != 2 || imp.Results[0].BookID != added.BookID {
+		t.Errorf("second import = %+v, want both skipped", imp)
+	}
+}
+
+func TestImportISBNsValidation(t *testing.T) {
+	setup(t)
+	setupImports(t)
+	setLookup(t, &fakeProvider{name: "fake"})
+	bobs := decode[Library](t, serve(handleCreateLibrary,
+		newRequest("bob", "POST", "/api/books/libraries", `{"name": "Bob's"}`)))
+
+	tooMany := make([]string, maxImportISBNs+1)
+	for i := range tooMany {
+		tooMany[i] = duneISBN13
+	}
+	tooManyJSON, _ := json.Marshal(map[string]any{"isbns": tooMany})
+	tests := []struct {
+		name string
+		user string
+		body string
+		want int
+	}{
+		{"not signed in", "", `{"isbns": ["` + duneISBN13 + `"]}`, http.StatusUnauthorized},
+		{"invalid json", "alice", `{"isbns": `, http.StatusBadRequest},
+		{"no isbns", "alice", `{"isbns": []}`, http.StatusBadRequest},
+		{"too many isbns", "alice", string(tooManyJSON), http.StatusBadRequest},
+		{"another user's library", "alice", fmt.Sprintf(`{"isbns": ["%s"], "library_id": %d}`, duneISBN13, bobs.ID), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rr := serve(handleImportISBNs, newRequest(tt.user, "POST", "/api/books/import/isbn", tt.body))
+		if rr.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d; body = %s", tt.name, rr.Code, tt.want, rr.Body)
+		}
+	}
+}
+
+func TestImportISBNsOnePerUser(t *testing.T) {
+	setup(t)
+	setupImports(t)
+	provider := &blockingProvider{started: make(chan string, 10), release: make(chan struct{})}
+	setLookup(t, provider)
+
+	first := startImport(t, "alice", duneISBN13)
+	id := importID(t, first)
+	<-provider.started
+
+	second := startImport(t, "alice", "9780141439587")
+	if second.Code != http.StatusConflict || !strings.Contains(second.Body.String(), id) {
+		t.Errorf("second import: status = %d, body = %s; want 409 naming %s", second.Code, second.Body, id)
+	}
+	// Other users import alongside
+	bobs := startImport(t, "bob", "9780141439587")
+	if bobs.Code != http.StatusAccepted {
+		t.Errorf("bob's import: status = %d, want 202", bobs.Code)
+	}
+	<-provider.started
+
+	// Each user only sees their own imports
+	for _, tt := range []struct{ user, id string }{{"bob", id}, {"alice", importID(t, bobs)}, {"alice", "0123456789abcdef"}} {
+		rr := serve(handleGetImport, newRequest(tt.user, "GET", "/", ""), "id", tt.id)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s gets import %s: status = %d, want 404", tt.user, tt.id, rr.Code)
+		}
+	}
+	rr := serve(handleGetImport, newRequest("", "GET", "/", ""), "id", id)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("get import signed out: status = %d, want 401", rr.Code)
+	}
+
+	close(provider.release)
+	if imp := waitImport(t, "alice", id); imp.Added != 1 {
+		t.Errorf("first import = %+v, want its book added", imp)
+	}
+	waitImport(t, "bob", importID(t, bobs))
+	if third := startImport(t, "alice", "9780141439587"); third.Code != http.StatusAccepted {
+		t.Errorf("import after the first finished: status = %d, want 202", third.Code)
+	}
+}
+
+func TestStopISBNImports(t *testing.T) {
+	setup(t)
+	setupImports(t)
+	provider := &blockingProvider{started: make(chan string, 10), release: make(chan struct{})}
+	setLookup(t, provider)
+
+	res := startImport(t, "alice", duneISBN13, "9780141439587", "nonsense")
+	<-provider.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := stopISBNImports(ctx); err != nil {
+		t.Fatalf("stopISBNImports: %v", err)
+	}
+
+	imp := waitImport(t, "alice", importID(t, res))
+	if imp.Status != "cancelled" || imp.Processed != 1 || imp.Added != 0 || imp.Failed != 0 {
+		t.Errorf("import = %+v, want cancelled with only the invalid ISBN processed", imp)
+	}
+	for i, want := range []string{importPending, importPending, importInvalid} {
+		if got := imp.Results[i].Status; got != want {
+			t.Errorf("result %d = %s, want %s", i, got, want)
+		}
+	}
+	var count int
+	DB.QueryRow("SELECT COUNT(*) FROM books").Scan(&count)
+	if count != 0 {
+		t.Errorf("%d books added by the cancelled import", count)
+	}
+}
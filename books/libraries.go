@@ -0,0 +1,404 @@
+package books
+
+import (
+	"NbirdHttp/apierror"
+	"NbirdHttp/auth"
+	"NbirdHttp/events"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Library is a collection of books its members can see and edit. Every user
+// has a personal library; shared ones, such as a household's, have the
+// members their owner adds.
+type Library struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	// The user's own library, created the first time they need one
+	Personal bool `json:"personal"`
+	// Including the owner
+	Members   []string `json:"members"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type libraryInput struct {
+	Name string `json:"name"`
+}
+
+type memberInput struct {
+	User string `json:"user"`
+}
+
+// Restricts a query on books to the libraries a user is a member of
+const memberOf = "library_id IN (SELECT library_id FROM library_members WHERE user = ?)"
+
+// Moves the books from before libraries into a shared library with no owner,
+// for an admin to hand to someone with ClaimUnowned
+func migrateUnowned(ctx context.Context, tx *sql.Tx) error {
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM books WHERE library_id IS NULL").Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+	result, err := tx.ExecContext(ctx, "INSERT INTO libraries (name, owner) VALUES ('Shared library', '')")
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE books SET library_id = ? WHERE library_id IS NULL", id)
+	return err
+}
+
+// ClaimUnowned makes user the owner of the libraries with no owner, which
+// hold the books added before every user had their own. It returns the
+// number of libraries claimed.
+func ClaimUnowned(ctx context.Context, user string) (int, error) {
+	if user == "" {
+		return 0, errors.New("a username is required")
+	}
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT OR IGNORE INTO library_members (library_id, user) SELECT id, ? FROM libraries WHERE owner = ''", user)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add member: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE libraries SET owner = ? WHERE owner = ''", user)
+	if err != nil {
+		return 0, fmt.Errorf("failed to set owner: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), tx.Commit()
+}
+
+// Returns the ID of the user's personal library, creating it if need be
+func personalLibrary(ctx context.Context, user string) (int, error) {
+	_, err := DB.ExecContext(ctx,
+		"INSERT OR IGNORE INTO libraries (name, owner, personal) VALUES ('My books', ?, 1)", user)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create personal library: %w", err)
+	}
+	var id int
+	err = DB.QueryRowContext(ctx, "SELECT id FROM libraries WHERE owner = ? AND personal = 1", user).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get personal library: %w", err)
+	}
+	_, err = DB.ExecContext(ctx, "INSERT OR IGNORE INTO library_members (library_id, user) VALUES (?, ?)", id, user)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add library member: %w", err)
+	}
+	return id, nil
+}
+
+// Returns a library the user is a member of. Libraries they aren't in are
+// reported as not found, the same as ones that don't exist.
+func getLibrary(ctx context.Context, id, user string) (Library, error) {
+	var library Library
+	var personal int
+	err := DB.QueryRowContext(ctx,
+		"SELECT id, name, owner, personal, created_at FROM libraries WHERE id = ? AND id IN (SELECT library_id FROM library_members WHERE user = ?)",
+		id, user,
+	).Scan(&library.ID, &library.Name, &library.Owner, &personal, &library.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return library, apierror.NotFound("Library not found")
+	}
+	if err != nil {
+		return library, fmt.Errorf("failed to get library: %w", err)
+	}
+	library.Personal = personal == 1
+	library.Members, err = libraryMembers(ctx, library.ID)
+	return library, err
+}
+
+func libraryMembers(ctx context.Context, id int) ([]string, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT user FROM library_members WHERE library_id = ? ORDER BY user", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query library members: %w", err)
+	}
+	defer rows.Close()
+	members := []string{}
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return nil, fmt.Errorf("failed to scan library member: %w", err)
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// Returns the library a new or moved book goes in: the one named by the
+// request's library_id, or the user's personal library if there is none
+func targetLibrary(ctx context.Context, libraryID, user string) (int, error) {
+	if libraryID == "" || libraryID == "0" {
+		return personalLibrary(ctx, user)
+	}
+	library, err := getLibrary(ctx, libraryID, user)
+	if err != nil {
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			return 0, apierror.Validation("Library not found").WithDetails(map[string]string{"field": "library_id"})
+		}
+		return 0, err
+	}
+	return library.ID, nil
+}
+
+// Sends a book event to every member of the book's library
+func publish(ctx context.Context, topic string, libraryID int, data any) {
+	members, err := libraryMembers(ctx, libraryID)
+	if err != nil {
+		log.Printf("[WARN] Failed to publish %s: %v\n", topic, err)
+		return
+	}
+	for _, member := range members {
+		events.Publish(topic, member, data)
+	}
+}
+
+func handleListLibraries(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if _, err := personalLibrary(r.Context(), user); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	rows, err := DB.QueryContext(r.Context(),
+		"SELECT id FROM library_members JOIN libraries ON libraries.id = library_id WHERE user = ? ORDER BY personal DESC, name",
+		user)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to query libraries: %w", err))
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			apierror.Write(w, r, fmt.Errorf("failed to scan library: %w", err))
+			return
+		}
+		ids = append(ids, strconv.Itoa(id))
+	}
+	rows.Close()
+
+	libraries := []Library{}
+	for _, id := range ids {
+		library, err := getLibrary(r.Context(), id, user)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		libraries = append(libraries, library)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(libraries)
+}
+
+func handleCreateLibrary(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	var input libraryInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid JSON"))
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		apierror.Write(w, r, apierror.Validation("Name is required").WithDetails(map[string]string{"field": "name"}))
+		return
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("INSERT INTO libraries (name, owner) VALUES (?, ?)", input.Name, user)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to insert library: %w", err))
+		return
+	}
+	id, _ := result.LastInsertId()
+	if _, err := tx.Exec("INSERT INTO library_members (library_id, user) VALUES (?, ?)", id, user); err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to add library member: %w", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	library, err := getLibrary(r.Context(), strconv.FormatInt(id, 10), user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(library)
+}
+
+// Returns the library in the request path if the user owns it
+func requireOwner(r *http.Request, user string) (Library, error) {
+	library, err := getLibrary(r.Context(), r.PathValue("id"), user)
+	if err != nil {
+		return library, err
+	}
+	if library.Owner != user {
+		return library, apierror.Forbidden("Only the library's owner can do that")
+	}
+	return library, nil
+}
+
+func handleDeleteLibrary(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	library, err := requireOwner(r, user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if library.Personal {
+		apierror.Write(w, r, apierror.Conflict("Personal libraries can't be deleted"))
+		return
+	}
+	var count int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM books WHERE library_id = ?", library.ID).Scan(&count); err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to count books: %w", err))
+		return
+	}
+	if count > 0 {
+		apierror.Write(w, r, apierror.Conflict(fmt.Sprintf("The library still has %d books; move or delete them first", count)))
+		return
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM library_members WHERE library_id = ?", library.ID); err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to delete library members: %w", err))
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM libraries WHERE id = ?", library.ID); err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to delete library: %w", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAddMember(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	library, err := requireOwner(r, user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if library.Personal {
+		apierror.Write(w, r, apierror.Conflict("Personal libraries can't be shared; create a shared library instead"))
+		return
+	}
+	var input memberInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid JSON"))
+		return
+	}
+	users, err := auth.Users()
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to list users: %w", err))
+		return
+	}
+	if !slices.Contains(users, input.User) {
+		apierror.Write(w, r, apierror.Validation(fmt.Sprintf("User `%s` has not been created.", input.User)).
+			WithDetails(map[string]string{"field": "user"}))
+		return
+	}
+
+	_, err = DB.Exec("INSERT OR IGNORE INTO library_members (library_id, user) VALUES (?, ?)", library.ID, input.User)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to add library member: %w", err))
+		return
+	}
+	library.Members, err = libraryMembers(r.Context(), library.ID)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(library)
+}
+
+// Owners remove members; members remove themselves to leave
+func handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	library, err := getLibrary(r.Context(), r.PathValue("id"), user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	member := r.PathValue("user")
+	if library.Owner != user && member != user {
+		apierror.Write(w, r, apierror.Forbidden("Only the library's owner can remove other members"))
+		return
+	}
+	if member == library.Owner {
+		apierror.Write(w, r, apierror.Conflict("The owner can't leave their library; delete it instead"))
+		return
+	}
+	if !slices.Contains(library.Members, member) {
+		apierror.Write(w, r, apierror.NotFound("Member not found"))
+		return
+	}
+
+	_, err = DB.Exec("DELETE FROM library_members WHERE library_id = ? AND user = ?", library.ID, member)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to remove library member: %w", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
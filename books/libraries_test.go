@@ -0,0 +1,206 @@
+package books
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestLibraryMembers(t *testing.T) {
+	setup(t)
+
+	rr := serve(handleCreateLibrary, newRequest("alice", "POST", "/api/books/libraries", `{"name": "Home"}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create library: status = %d, body = %s", rr.Code, rr.Body)
+	}
+	library := decode[Library](t, rr)
+	if library.Owner != "alice" || !slices.Equal(library.Members, []string{"alice"}) {
+		t.Fatalf("new library = %+v, want owned by alice with her as its only member", library)
+	}
+	libraryID := strconv.Itoa(library.ID)
+	book := createBook(t, "alice", map[string]any{"title": "Dune", "author": "Frank Herbert", "library_id": library.ID})
+	bookID := strconv.Itoa(book.ID)
+
+	// What each request gets before and after bob joins the library
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		request       func() *http.Request
+		pathValues    []string
+		before, after int
+	}{
+		{
+			name:       "get book",
+			handler:    handleGetBook,
+			request:    func() *http.Request { return newRequest("bob", "GET", "/api/books/"+bookID, "") },
+			pathValues: []string{"id", bookID},
+			before:     http.StatusNotFound,
+			after:      http.StatusOK,
+		},
+		{
+			name:    "update book",
+			handler: handleUpdateBook,
+			request: func() *http.Request {
+				return newFormRequest("bob", "PUT", "/api/books/"+bookID, map[string]string{"title": "Dune", "author": "Frank Herbert"})
+			},
+			pathValues: []string{"id", bookID},
+			before:     http.StatusNotFound,
+			after:      http.StatusOK,
+		},
+		{
+			name:    "add book to the library",
+			handler: handleCreateBook,
+			request: func() *http.Request {
+				return newRequest("bob", "POST", "/api/books", `{"title": "Emma", "author": "Jane Austen", "library_id": `+libraryID+`}`)
+			},
+			before: http.StatusBadRequest,
+			after:  http.StatusCreated,
+		},
+		{
+			name:    "add member",
+			handler: handleAddMember,
+			request: func() *http.Request {
+				return newRequest("bob", "POST", "/api/books/libraries/"+libraryID+"/members", `{"user": "carol"}`)
+			},
+			pathValues: []string{"id", libraryID},
+			before:     http.StatusNotFound,
+			after:      http.StatusForbidden,
+		},
+		{
+			name:    "remove the owner",
+			handler: handleRemoveMember,
+			request: func() *http.Request {
+				return newRequest("bob", "DELETE", "/api/books/libraries/"+libraryID+"/members/alice", "")
+			},
+			pathValues: []string{"id", libraryID, "user", "alice"},
+			before:     http.StatusNotFound,
+			after:      http.StatusForbidden,
+		},
+		{
+			name:       "delete library",
+			handler:    handleDeleteLibrary,
+			request:    func() *http.Request { return newRequest("bob", "DELETE", "/api/books/libraries/"+libraryID, "") },
+			pathValues: []string{"id", libraryID},
+			before:     http.StatusNotFound,
+			after:      http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		if rr := serve(tt.handler, tt.request(), tt.pathValues...); rr.Code != tt.before {
+			t.Errorf("%s before joining: status = %d, want %d; body = %s", tt.name, rr.Code, tt.before, rr.Body)
+		}
+	}
+	rr = serve(handleListBooks, newRequest("bob", "GET", "/api/books?library="+libraryID, ""))
+	if books := decode[[]Book](t, rr); len(books) != 0 {
+		t.Errorf("bob lists %d of the library's books before joining, want none", len(books))
+	}
+
+	rr = serve(handleAddMember, newRequest("alice", "POST", "/api/books/libraries/"+libraryID+"/members", `{"user": "bob"}`),
+		"id", libraryID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("add bob: status = %d, body = %s", rr.Code, rr.Body)
+	}
+	if members := decode[Library](t, rr).Members; !slices.Equal(members, []string{"alice", "bob"}) {
+		t.Errorf("members = %q, want alice and bob", members)
+	}
+
+	for _, tt := range tests {
+		if rr := serve(tt.handler, tt.request(), tt.pathValues...); rr.Code != tt.after {
+			t.Errorf("%s after joining: status = %d, want %d; body = %s", tt.name, rr.Code, tt.after, rr.Body)
+		}
+	}
+	rr = serve(handleListBooks, newRequest("bob", "GET", "/api/books?library="+libraryID, ""))
+	if books := decode[[]Book](t, rr); len(books) != 2 {
+		t.Errorf("bob lists %d of the library's books after joining, want 2", len(books))
+	}
+
+	// Members leave by removing themselves, and lose the books with it
+	rr = serve(handleRemoveMember, newRequest("bob", "DELETE", "/api/books/libraries/"+libraryID+"/members/bob", ""),
+		"id", libraryID, "user", "bob")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("bob leaves: status = %d, body = %s", rr.Code, rr.Body)
+	}
+	rr = serve(handleGetBook, newRequest("bob", "GET", "/api/books/"+bookID, ""), "id", bookID)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("get book after leaving: status = %d, want 404", rr.Code)
+	}
+}
+
+func TestLibraryOwner(t *testing.T) {
+	setup(t)
+
+	library := decode[Library](t, serve(handleCreateLibrary,
+		newRequest("alice", "POST", "/api/books/libraries", `{"name": "Home"}`)))
+	libraryID := strconv.Itoa(library.ID)
+	serve(handleAddMember, newRequest("alice", "POST", "/api/books/libraries/"+libraryID+"/members", `{"user": "bob"}`),
+		"id", libraryID)
+
+	rr := serve(handleAddMember, newRequest("alice", "POST", "/api/books/libraries/"+libraryID+"/members", `{"user": "mallory"}`),
+		"id", libraryID)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("add unregistered user: status = %d, want 400", rr.Code)
+	}
+	rr = serve(handleRemoveMember, newRequest("alice", "DELETE", "/api/books/libraries/"+libraryID+"/members/alice", ""),
+		"id", libraryID, "user", "alice")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("owner leaves: status = %d, want 409", rr.Code)
+	}
+
+	// Libraries with books can't be deleted
+	book := createBook(t, "bob", map[string]any{"title": "Dune", "author": "Frank Herbert", "library_id": library.ID})
+	rr = serve(handleDeleteLibrary, newRequest("alice", "DELETE", "/api/books/libraries/"+libraryID, ""), "id", libraryID)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("delete library with books: status = %d, want 409", rr.Code)
+	}
+	serve(handleDeleteBook, newRequest("alice", "DELETE", "/api/books/"+strconv.Itoa(book.ID), ""), "id", strconv.Itoa(book.ID))
+
+	rr = serve(handleRemoveMember, newRequest("alice", "DELETE", "/api/books/libraries/"+libraryID+"/members/bob", ""),
+		"id", libraryID, "user", "bob")
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("owner removes bob: status = %d, want 204", rr.Code)
+	}
+	rr = serve(handleDeleteLibrary, newRequest("alice", "DELETE", "/api/books/libraries/"+libraryID, ""), "id", libraryID)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("delete empty library: status = %d, want 204", rr.Code)
+	}
+
+	// Personal libraries are neither shared nor deleted
+	personal := decode[[]Library](t, serve(handleListLibraries, newRequest("alice", "GET", "/api/books/libraries", "")))[0]
+	personalID := strconv.Itoa(personal.ID)
+	rr = serve(handleAddMember, newRequest("alice", "POST", "/api/books/libraries/"+personalID+"/members", `{"user": "bob"}`),
+		"id", personalID)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("share personal library: status = %d, want 409", rr.Code)
+	}
+	rr = serve(handleDeleteLibrary, newRequest("alice", "DELETE", "/api/books/libraries/"+personalID, ""), "id", personalID)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("delete personal library: status = %d, want 409", rr.Code)
+	}
+}
+
+func TestLibrariesRequireSignIn(t *testing.T) {
+	setup(t)
+	book := createBook(t, "alice", map[string]any{"title": "Dune", "author": "Frank Herbert"})
+	bookID := strconv.Itoa(book.ID)
+
+	// Naming alice without her password doesn't reach her books
+	tests := map[string]func(r *http.Request){
+		"nobody":       func(r *http.Request) {},
+		"bearer name":  func(r *http.Request) { r.Header.Set("Authorization", "Bearer alice") },
+		"user param":   func(r *http.Request) { r.URL.RawQuery = "user=alice" },
+		"wrong secret": func(r *http.Request) { r.SetBasicAuth("alice", "guess") },
+	}
+	for name, sign := range tests {
+		r := newRequest("", "GET", "/api/books/"+bookID, "")
+		sign(r)
+		if rr := serve(handleGetBook, r, "id", bookID); rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: get book status = %d, want 401", name, rr.Code)
+		}
+		r = newRequest("", "GET", "/api/books/libraries", "")
+		sign(r)
+		if rr := serve(handleListLibraries, r); rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: list libraries status = %d, want 401", name, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package books
+
+import (
+	"NbirdHttp/apierror"
+	"NbirdHttp/auth"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// BookPage is one page of a book listing.
+type BookPage struct {
+	Books []Book   `json:"books"`
+	Meta  PageMeta `json:"meta"`
+}
+
+type PageMeta struct {
+	// Books matching the filters, on every page
+	Total int `json:"total"`
+	Limit int `json:"limit"`
+	// Pass as cursor for the next page; absent on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// The expressions each sort key orders by. NULLs are coalesced so cursors
+// can compare every key.
+var sortKeys = map[string]string{
+	"title":       "books.title COLLATE NOCASE",
+	"author":      "books.author COLLATE NOCASE",
+	"created_at":  "COALESCE(books.created_at, '')",
+	"read_status": "COALESCE(books.read_status, '')",
+	"rating":      "COALESCE(books.rating, 0)",
+	"pages":       "COALESCE(books.pages, 0)",
+	// Only with q
+	"relevance": ftsRank,
+}
+
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// Parses a sort parameter such as "-rating,title": keys in priority order,
+// each descending with a leading -. Without one, searches sort by relevance
+// and listings newest first. The book ID is always the last key, so every
+// book has its own place in the order for cursors to point to.
+func parseSort(param string, search bool) ([]sortKey, error) {
+	if param == "" {
+		param = "-created_at"
+		if search {
+			param = "relevance"
+		}
+	}
+
+	var keys []sortKey
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		expr, ok := sortKeys[name]
+		if !ok || (name == "relevance" && !search) {
+			return nil, apierror.Validation(fmt.Sprintf("Can't sort by %q", name)).
+				WithDetails(map[string]any{"parameter": "sort", "allowed": sortKeyNames()})
+		}
+		keys = append(keys, sortKey{expr: expr, desc: desc})
+	}
+	return append(keys, sortKey{expr: "books.id", desc: keys[len(keys)-1].desc}), nil
+}
+
+func sortKeyNames() []string {
+	var names []string
+	for name := range sortKeys {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func orderBy(keys []sortKey) string {
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		terms[i] = key.expr
+		if key.desc {
+			terms[i] += " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+// A cursor holds the sort the page was listed in and the sort values of
+// the last book on it
+type cursor struct {
+	Sort   string `json:"s"`
+	Values []any  `json:"v"`
+}
+
+func encodeCursor(sort string, values []any) string {
+	data, _ := json.Marshal(cursor{Sort: sort, Values: values})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(param, sort string, keys []sortKey) ([]any, error) {
+	invalid := apierror.Validation("Invalid cursor; pass next_cursor from the previous page with the same sort").
+		WithDetails(map[string]string{"parameter": "cursor"})
+	data, err := base64.RawURLEncoding.DecodeString(param)
+	if err != nil {
+		return nil, invalid
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort || len(c.Values) != len(keys) {
+		return nil, invalid
+	}
+	return c.Values, nil
+}
+
+// Returns a condition matching the books after the cursor's, in the order
+// the keys sort them: those after it on the first key, or level on the
+// first and after on the second, and so on
+func afterCursor(keys []sortKey, values []any) (string, []any) {
+	var terms []string
+	var args []any
+	for i, key := range keys {
+		var conds []string
+		for j := range i {
+			conds = append(conds, keys[j].expr+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if key.desc {
+			op = " < ?"
+		}
+		conds = append(conds, key.expr+op)
+		args = append(args, values[i])
+		terms = append(terms, "("+strings.Join(conds, " AND ")+")")
+	}
+	return "(" + strings.Join(terms, " OR ") + ")", args
+}
+
+// The JSON names of Book's fields
+var bookFields = func() []string {
+	var names []string
+	t := reflect.TypeFor[Book]()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	return names
+}()
+
+// Parses a fields parameter such as "id,title,author"
+func parseFields(param string) ([]string, error) {
+	if param == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if !slices.Contains(bookFields, field) {
+			return nil, apierror.Validation(fmt.Sprintf("Unknown field %q", field)).
+				WithDetails(map[string]any{"parameter": "fields", "allowed": bookFields})
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Returns books with only the given fields, or as they are if there are none
+func selectFields(books []Book, fields []string) (any, error) {
+	if len(fields) == 0 {
+		return books, nil
+	}
+	data, err := json.Marshal(books)
+	if err != nil {
+		return nil, err
+	}
+	var all []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for _, book := range all {
+		for name := range book {
+			if !slices.Contains(fields, name) {
+				delete(book, name)
+			}
+		}
+	}
+	return all, nil
+}
+
+// Lists the books in the user's libraries matching the request's filters,
+// in the order of its sort parameter. With paged, at most limit books are
+// returned, starting after the cursor.
+func listBooks(r *http.Request, user string, paged bool) (BookPage, error) {
+	query := r.URL.Query()
+	library := query.Get("library")
+	q := query.Get("q")
+	search := query.Get("search")
+	genre := query.Get("genre")
+	readStatus := query.Get("read_status")
+	isSignedStr := query.Get("is_signed")
+	tag := query.Get("tag")
+	isbn := query.Get("isbn")
+	publisher := query.Get("publisher")
+	language := query.Get("language")
+	page := BookPage{Books: make([]Book, 0)}
+
+	from := "books"
+	where := memberOf
+	args := []interface{}{user}
+
+	match := ""
+	if q != "" {
+		match = ftsQuery(q)
+		if match == "" {
+			return page, apierror.Validation("Search has no words to look for").WithDetails(map[string]string{"parameter": "q"})
+		}
+		from = "books_fts JOIN books ON books.id = books_fts.rowid"
+		where = "books_fts MATCH ? AND " + memberOf
+		args = []interface{}{match, user}
+	}
+
+	if library != "" {
+		where += " AND books.library_id = ?"
+		args = append(args, library)
+	}
+	if search != "" {
+		where += " AND (books.title LIKE ? OR books.author LIKE ?)"
+		searchPattern := "%" + search + "%"
+		args = append(args, searchPattern, searchPattern)
+	}
+	if genre != "" {
+		where += " AND books.genre = ?"
+		args = append(args, genre)
+	}
+	if readStatus != "" {
+		where += " AND books.read_status = ?"
+		args = append(args, readStatus)
+	}
+	if isSignedStr != "" {
+		isSigned := 0
+		if isSignedStr == "true" {
+			isSigned = 1
+		}
+		where += " AND books.is_signed = ?"
+		args = append(args, isSigned)
+	}
+	if tag != "" {
+		where += " AND books.tags LIKE ?"
+		args = append(args, `%"`+tag+`"%`)
+	}
+	if isbn != "" {
+		// Books are saved with ISBN-13s, except those whose ISBN was
+		// invalid before ISBNs were checked
+		if isbn13, err := normalizeISBN(isbn); err == nil {
+			isbn = isbn13
+		}
+		where += " AND books.isbn = ?"
+		args = append(args, isbn)
+	}
+	if publisher != "" {
+		where += " AND books.publisher = ?"
+		args = append(args, publisher)
+	}
+	if language != "" {
+		where += " AND books.language = ?"
+		args = append(args, language)
+	}
+
+	sortParam := query.Get("sort")
+	keys, err := parseSort(sortParam, match != "")
+	if err != nil {
+		return page, err
+	}
+
+	// Count before the cursor narrows the books down
+	err = DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM "+from+" WHERE "+where, args...).Scan(&page.Meta.Total)
+	if err != nil {
+		return page, fmt.Errorf("failed to count books: %w", err)
+	}
+
+	limit := -1
+	if paged {
+		limit = defaultPageSize
+		if l := query.Get("limit"); l != "" {
+			limit, err = strconv.Atoi(l)
+			if err != nil || limit < 1 || limit > maxPageSize {
+				return page, apierror.Validation(fmt.Sprintf("limit must be between 1 and %d", maxPageSize)).
+					WithDetails(map[string]string{"parameter": "limit"})
+			}
+		}
+		page.Meta.Limit = limit
+		if c := query.Get("cursor"); c != "" {
+			values, err := decodeCursor(c, sortParam, keys)
+			if err != nil {
+				return page, err
+			}
+			cond, condArgs := afterCursor(keys, values)
+			where += " AND " + cond
+			args = append(args, condArgs...)
+		}
+	}
+
+	columns := bookColumns
+	if match != "" {
+		columns += ", " + ftsSnippet
+	}
+	for _, key := range keys {
+		columns += ", " + key.expr
+	}
+	sql := "SELECT " + columns + " FROM " + from + " WHERE " + where + " ORDER BY " + orderBy(keys)
+	if limit > 0 {
+		// One more shows whether there is another page
+		sql += " LIMIT " + strconv.Itoa(limit+1)
+	}
+
+	rows, err := DB.QueryContext(r.Context(), sql, args...)
+	if err != nil {
+		return page, fmt.Errorf("failed to query books: %w", err)
+	}
+	defer rows.Close()
+
+	var last []any
+	for rows.Next() {
+		if limit > 0 && len(page.Books) == limit {
+			page.Meta.NextCursor = encodeCursor(sortParam, last)
+			break
+		}
+
+		var snippet string
+		values := make([]any, len(keys))
+		extra := make([]any, 0, len(keys)+1)
+		if match != "" {
+			extra = append(extra, &snippet)
+		}
+		for i := range values {
+			extra = append(extra, &values[i])
+		}
+		book, err := scanBook(rows, extra...)
+		if err != nil {
+			log.Printf("[ERROR] Failed to scan book: %v\n", err)
+			continue
+		}
+		if match != "" {
+			book.Snippet = snippetHTML(snippet)
+		}
+		page.Books = append(page.Books, book)
+		last = values
+	}
+	return page, rows.Err()
+}
+
+func handleListBooks(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	page, err := listBooks(r, user, false)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	books, err := selectFields(page.Books, fields)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(books)
+}
+
+func handleListBooksV2(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	page, err := listBooks(r, user, true)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	books, err := selectFields(page.Books, fields)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"books": books, "meta": page.Meta})
+}
@@ -0,0 +1,210 @@
+package books
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// Adds books to alice's library whose sort values tie in every key, so
+// only the ID tells some of them apart
+func seedListBooks(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	libraryID, err := personalLibrary(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := []struct {
+		title, author, readStatus, createdAt string
+		rating, pages                        any
+	}{
+		{"Dune", "Frank Herbert", "read", "2024-01-01 10:00:00", 5, 412},
+		{"dune", "Frank Herbert", "read", "2024-01-01 10:00:00", 5, 412},
+		{"Dune", "frank herbert", "unread", "2024-01-01 10:00:00", nil, nil},
+		{"Emma", "Jane Austen", "reading", "2024-01-02 10:00:00", 4, 474},
+		{"Emma", "Jane Austen", "reading", "2024-01-02 10:00:00", 4, 474},
+		{"Persuasion", "Jane Austen", "unread", "2024-01-02 10:00:00", nil, 249},
+		{"The Hobbit", "J.R.R. Tolkien", "read", "2024-01-03 10:00:00", 5, 310},
+		{"The Hobbit", "J.R.R. Tolkien", "read", "2024-01-03 10:00:00", 5, 310},
+		{"Hobbit Tales", "Tolkien", "unread", "2024-01-03 10:00:00", 3, nil},
+		{"Zen", "Ann Other", "unread", "2024-01-04 10:00:00", nil, nil},
+		{"Another Dune", "Brian Herbert", "reading", "2024-01-04 10:00:00", 2, 412},
+	}
+	for _, b := range books {
+		_, err := DB.ExecContext(ctx, `
+			INSERT INTO books (title, author, read_status, created_at, rating, pages, library_id, tags)
+			VALUES (?, ?, ?, ?, ?, ?, ?, '[]')
+		`, b.title, b.author, b.readStatus, b.createdAt, b.rating, b.pages, libraryID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Another user's books are never listed
+	if _, err := personalLibrary(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	createBook(t, "bob", map[string]any{"title": "Dune", "author": "Frank Herbert"})
+}
+
+func listPage(t *testing.T, query url.Values) (*BookPage, int) {
+	t.Helper()
+	rr := serve(handleListBooksV2, newRequest("alice", "GET", "/api/books?"+query.Encode(), ""))
+	if rr.Code != http.StatusOK {
+		return nil, rr.Code
+	}
+	page := decode[BookPage](t, rr)
+	return &page, rr.Code
+}
+
+func TestListPages(t *testing.T) {
+	setup(t)
+	seedListBooks(t)
+
+	sorts := []struct{ q, sort string }{
+		{"", ""},
+		{"", "title"},
+		{"", "-title"},
+		{"", "author"},
+		{"", "-author,title"},
+		{"", "created_at"},
+		{"", "-created_at"},
+		{"", "read_status"},
+		{"", "-read_status,-created_at"},
+		{"", "rating"},
+		{"", "-rating"},
+		{"", "-rating,title"},
+		{"", "pages"},
+		{"", "-pages,author"},
+		{"", "rating,pages,read_status"},
+		{"dune", ""},
+		{"dune", "relevance"},
+		{"dune", "-relevance"},
+		{"herbert", "-rating"},
+	}
+	for _, tt := range sorts {
+		query := url.Values{}
+		if tt.q != "" {
+			query.Set("q", tt.q)
+		}
+		if tt.sort != "" {
+			query.Set("sort", tt.sort)
+		}
+
+		// The whole listing, in the order the pages should follow
+		rr := serve(handleListBooks, newRequest("alice", "GET", "/api/books?"+query.Encode(), ""))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("q=%q sort=%q: list status = %d, body = %s", tt.q, tt.sort, rr.Code, rr.Body)
+		}
+		var want []int
+		for _, book := range decode[[]Book](t, rr) {
+			want = append(want, book.ID)
+		}
+		if len(want) == 0 {
+			t.Fatalf("q=%q sort=%q: nothing listed", tt.q, tt.sort)
+		}
+
+		for _, limit := range []int{1, 2, 3, 5, 50} {
+			name := fmt.Sprintf("q=%q sort=%q limit=%d", tt.q, tt.sort, limit)
+			query.Set("limit", fmt.Sprint(limit))
+			query.Del("cursor")
+
+			var got []int
+			for pages := 0; ; pages++ {
+				if pages > len(want) {
+					t.Fatalf("%s: more pages than books", name)
+				}
+				page, code := listPage(t, query)
+				if code != http.StatusOK {
+					t.Fatalf("%s: page %d status = %d", name, pages+1, code)
+				}
+				if page.Meta.Total != len(want) || page.Meta.Limit != limit {
+					t.Errorf("%s: meta = %+v, want total %d", name, page.Meta, len(want))
+				}
+				if len(page.Books) > limit {
+					t.Errorf("%s: page %d has %d books", name, pages+1, len(page.Books))
+				}
+				for _, book := range page.Books {
+					got = append(got, book.ID)
+				}
+				if page.Meta.NextCursor == "" {
+					break
+				}
+				query.Set("cursor", page.Meta.NextCursor)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("%s: paged through %v, want %v", name, got, want)
+			}
+		}
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	setup(t)
+	seedListBooks(t)
+
+	page, _ := listPage(t, url.Values{"sort": {"title"}, "limit": {"2"}})
+	cursor := page.Meta.NextCursor
+	if cursor == "" {
+		t.Fatal("no next cursor")
+	}
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  int
+	}{
+		{"same sort", url.Values{"sort": {"title"}, "cursor": {cursor}}, http.StatusOK},
+		{"same sort, other limit", url.Values{"sort": {"title"}, "limit": {"3"}, "cursor": {cursor}}, http.StatusOK},
+		{"same sort, filtered", url.Values{"sort": {"title"}, "read_status": {"read"}, "cursor": {cursor}}, http.StatusOK},
+		{"other sort", url.Values{"sort": {"author"}, "cursor": {cursor}}, http.StatusBadRequest},
+		{"reversed sort", url.Values{"sort": {"-title"}, "cursor": {cursor}}, http.StatusBadRequest},
+		{"default sort", url.Values{"cursor": {cursor}}, http.StatusBadRequest},
+		{"more keys", url.Values{"sort": {"title,author"}, "cursor": {cursor}}, http.StatusBadRequest},
+		{"not base64", url.Values{"sort": {"title"}, "cursor": {"not a cursor!"}}, http.StatusBadRequest},
+		{"not json", url.Values{"sort": {"title"}, "cursor": {"bm90IGpzb24"}}, http.StatusBadRequest},
+		{"too few values", url.Values{"sort": {"title"}, "cursor": {encodeCursor("title", []any{"Dune"})}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if _, code := listPage(t, tt.query); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+}
+
+func TestListLimit(t *testing.T) {
+	setup(t)
+	seedListBooks(t)
+
+	tests := []struct {
+		limit     string
+		want      int
+		wantLimit int
+	}{
+		{"", http.StatusOK, defaultPageSize},
+		{"1", http.StatusOK, 1},
+		{fmt.Sprint(maxPageSize), http.StatusOK, maxPageSize},
+		{"0", http.StatusBadRequest, 0},
+		{"-1", http.StatusBadRequest, 0},
+		{fmt.Sprint(maxPageSize + 1), http.StatusBadRequest, 0},
+		{"ten", http.StatusBadRequest, 0},
+		{"2.5", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		query := url.Values{}
+		if tt.limit != "" {
+			query.Set("limit", tt.limit)
+		}
+		page, code := listPage(t, query)
+		if code != tt.want {
+			t.Errorf("limit=%q: status = %d, want %d", tt.limit, code, tt.want)
+			continue
+		}
+		if page != nil && page.Meta.Limit != tt.wantLimit {
+			t.Errorf("limit=%q: meta.limit = %d, want %d", tt.limit, page.Meta.Limit, tt.wantLimit)
+		}
+	}
+}
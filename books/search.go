@@ -0,0 +1,61 @@
+package books
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Surround the matches in snippets from SQLite, and are swapped for <mark>
+// tags once the rest of the snippet is escaped
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// Ranks title matches above author, genre and tag matches, in that order
+const ftsRank = "bm25(books_fts, 10.0, 5.0, 2.0, 1.0)"
+
+// The most relevant few words of the best matching field
+const ftsSnippet = "snippet(books_fts, -1, '" + markStart + "', '" + markEnd + "', '…', 12)"
+
+// The characters of a word as SQLite's unicode61 tokenizer splits them
+var ftsWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Converts a q= search to an FTS5 query. Every term must match somewhere in
+// the title, author, genre or tags, in any order. "Quoted words" match as a
+// phrase and a trailing * matches words starting with the term, e.g. tolk*.
+// Anything else is searched for as plain text, so no search is a syntax
+// error. Returns "" if q has no words.
+func ftsQuery(q string) string {
+	var terms []string
+	// Words joined by punctuation, such as Ender's, stay together as a phrase
+	add := func(text string) {
+		words := ftsWord.FindAllString(text, -1)
+		if len(words) == 0 {
+			return
+		}
+		term := `"` + strings.Join(words, " ") + `"`
+		if strings.HasSuffix(strings.TrimSpace(text), "*") {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+
+	// Text between quotes is a phrase, including an unclosed final one
+	for i, part := range strings.Split(q, `"`) {
+		if i%2 == 1 {
+			add(part)
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			add(field)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// Escapes a snippet for HTML and marks its matches with <mark>
+func snippetHTML(snippet string) string {
+	return strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>").Replace(html.EscapeString(snippet))
+}
@@ -0,0 +1,78 @@
+package books
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		q, want string
+	}{
+		{"dune", `"dune"`},
+		{"  frank   herbert ", `"frank" "herbert"`},
+		{"tolk*", `"tolk"*`},
+		{`"lord of the"`, `"lord of the"`},
+		{`"lord of the`, `"lord of the"`},
+		{`"lord of"*`, `"lord of"`},
+		{"Ender's game", `"Ender s" "game"`},
+		{"Ólafur Ísland", `"Ólafur" "Ísland"`},
+		// FTS5 syntax is searched for as plain words
+		{`dune"`, `"dune"`},
+		{`"`, ""},
+		{`""`, ""},
+		{"NEAR(frank herbert, 2)", `"NEAR frank" "herbert" "2"`},
+		{"frank AND herbert", `"frank" "AND" "herbert"`},
+		{"dune OR emma NOT austen", `"dune" "OR" "emma" "NOT" "austen"`},
+		{"*", ""},
+		{"*dune", `"dune"`},
+		{"-herbert", `"herbert"`},
+		{"dune -herbert", `"dune" "herbert"`},
+		{"title:dune", `"title dune"`},
+		{"{title author}:dune", `"title" "author dune"`},
+		{"^dune", `"dune"`},
+		{"(dune)", `"dune"`},
+		{"dune + emma", `"dune" "emma"`},
+		{"", ""},
+		{"!@#$%", ""},
+	}
+	for _, tt := range tests {
+		if got := ftsQuery(tt.q); got != tt.want {
+			t.Errorf("ftsQuery(%q) = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+
+	// Whatever is typed makes a query SQLite accepts
+	setup(t)
+	for _, tt := range tests {
+		if tt.want == "" {
+			continue
+		}
+		var n int
+		err := DB.QueryRowContext(context.Background(),
+			"SELECT COUNT(*) FROM books_fts WHERE books_fts MATCH ?", tt.want).Scan(&n)
+		if err != nil {
+			t.Errorf("ftsQuery(%q) = %s: %v", tt.q, tt.want, err)
+		}
+	}
+}
+
+func TestSnippetHTML(t *testing.T) {
+	tests := []struct {
+		snippet, want string
+	}{
+		{"Dune", "Dune"},
+		{markStart + "Dune" + markEnd + " Messiah", "<mark>Dune</mark> Messiah"},
+		{"…the " + markStart + "lord" + markEnd + " of the " + markStart + "rings" + markEnd + "…",
+			"…the <mark>lord</mark> of the <mark>rings</mark>…"},
+		{`<script>alert("x")</script>`, "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"},
+		{markStart + "<b>" + markEnd, "<mark>&lt;b&gt;</mark>"},
+		{"Tom & Jerry's <mark>", "Tom &amp; Jerry&#39;s &lt;mark&gt;"},
+		{"<img src=x onerror=alert(1)>", "&lt;img src=x onerror=alert(1)&gt;"},
+	}
+	for _, tt := range tests {
+		if got := snippetHTML(tt.snippet); got != tt.want {
+			t.Errorf("snippetHTML(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
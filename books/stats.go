@@ -0,0 +1,59 @@
+package books
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Stats is the size of the library and the disk space it uses.
+type Stats struct {
+	Count     int `json:"count"`
+	Libraries int `json:"libraries"`
+	// ISBN lookups cached, including those that found nothing
+	CachedLookups int   `json:"cached_lookups"`
+	Covers        int   `json:"covers"`
+	CoversBytes   int64 `json:"covers_bytes"`
+	// Including the write-ahead log, if there is one
+	DatabaseBytes int64 `json:"database_bytes"`
+}
+
+// GetStats counts the books and measures the database and cover directory.
+func GetStats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	if err := DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM books").Scan(&stats.Count); err != nil {
+		return stats, fmt.Errorf("failed to count books: %w", err)
+	}
+	if err := DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM libraries").Scan(&stats.Libraries); err != nil {
+		return stats, fmt.Errorf("failed to count libraries: %w", err)
+	}
+	if err := DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM isbn_cache").Scan(&stats.CachedLookups); err != nil {
+		return stats, fmt.Errorf("failed to count cached lookups: %w", err)
+	}
+
+	entries, err := os.ReadDir(coversDir)
+	if err != nil {
+		return stats, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		stats.Covers++
+		stats.CoversBytes += info.Size()
+	}
+
+	for _, path := range []string{dbPath, dbPath + "-wal"} {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.DatabaseBytes += info.Size()
+	}
+	return stats, nil
+}
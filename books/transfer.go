@@ -0,0 +1,133 @@
+package books
+
+import (
+	"NbirdHttp/isbn"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Export writes the books in user's libraries, or every book if user is "",
+// to w as a JSON array, oldest first, in the shape the API returns them. It
+// returns the number of books written.
+func Export(ctx context.Context, w io.Writer, user string) (int, error) {
+	query := "SELECT " + bookColumns + " FROM books"
+	var args []any
+	if user != "" {
+		query += " WHERE " + memberOf
+		args = append(args, user)
+	}
+	rows, err := DB.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query books: %w", err)
+	}
+	defer rows.Close()
+
+	books := make([]Book, 0)
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return 0, fmt.Errorf("failed to scan book: %w", err)
+		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query books: %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return len(books), enc.Encode(books)
+}
+
+// Import adds the books in a JSON array like the one Export writes to user's
+// personal library. IDs and libraries are ignored and new ones assigned; a
+// book with the same title and author, or the same ISBN, as one already in
+// the library is skipped. Cover images are referred to by path and must be copied into the
+// covers directory separately. It returns the number of books added and
+// skipped.
+func Import(ctx context.Context, r io.Reader, user string) (added, skipped int, err error) {
+	var books []Book
+	if err := json.NewDecoder(r).Decode(&books); err != nil {
+		return 0, 0, fmt.Errorf("invalid JSON: %w", err)
+	}
+	for i, book := range books {
+		if strings.TrimSpace(book.Title) == "" || strings.TrimSpace(book.Author) == "" {
+			return 0, 0, fmt.Errorf("book %d: title and author are required", i+1)
+		}
+		if book.Rating != nil && (*book.Rating < 1 || *book.Rating > 5) {
+			return 0, 0, fmt.Errorf("book %d: rating must be from 1 to 5", i+1)
+		}
+		if book.ISBN != nil {
+			isbn13, err := isbn.Normalize(*book.ISBN)
+			if err != nil {
+				return 0, 0, fmt.Errorf("book %d: invalid ISBN %q: %w", i+1, *book.ISBN, err)
+			}
+			books[i].ISBN = &isbn13
+		}
+	}
+
+	if user == "" {
+		return 0, 0, errors.New("a username is required")
+	}
+	libraryID, err := personalLibrary(ctx, user)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, book := range books {
+		var exists bool
+		err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM books WHERE ((title = ? AND author = ?) OR isbn = ?) AND library_id = ?)",
+			book.Title, book.Author, book.ISBN, libraryID,
+		).Scan(&exists)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to check for %q: %w", book.Title, err)
+		}
+		if exists {
+			skipped++
+			continue
+		}
+
+		if book.ReadStatus == "" {
+			book.ReadStatus = "unread"
+		}
+		if book.Tags == nil {
+			book.Tags = []string{}
+		}
+		tagsJSON, _ := json.Marshal(book.Tags)
+		isSigned := 0
+		if book.IsSigned {
+			isSigned = 1
+		}
+		// Keep the original date the book was added when there is one
+		var createdAt *string
+		if book.CreatedAt != "" {
+			createdAt = &book.CreatedAt
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO books (title, author, genre, read_status, cover_image, is_signed, tags, created_at, library_id, rating,
+				isbn, subtitle, publisher, publish_date, pages, language, description)
+			VALUES (?, ?, ?, ?, ?, ?, ?, COALESCE(?, datetime('now')), ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, book.Title, book.Author, book.Genre, book.ReadStatus, book.CoverImage, isSigned, string(tagsJSON), createdAt, libraryID, book.Rating,
+			book.ISBN, book.Subtitle, book.Publisher, book.PublishDate, book.Pages, book.Language, book.Description)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to insert %q: %w", book.Title, err)
+		}
+		added++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return added, skipped, nil
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"NbirdHttp/auth"
+	"NbirdHttp/backup"
+	"NbirdHttp/books"
+	"NbirdHttp/config"
+	"NbirdHttp/migrate"
+	"NbirdHttp/punch"
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// A subcommand of the server binary. Commands work on the data in the
+// working directory, so run them from the directory the server runs in.
+type command struct {
+	name    string // one or two words, e.g. "user add"
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "Run the server (the default with no command)", runServe},
+	{"user list", "List registered users", runUserList},
+	{"user add", "Register a user, reading the password from stdin", runUserAdd},
+	{"user delete", "Delete a user", runUserDelete},
+	{"user passwd", "Change a user's password, reading it from stdin", runUserPasswd},
+	{"books export", "Write every book, or a user's books, as JSON", runBooksExport},
+	{"books import", "Add books from JSON written by books export to a user's library", runBooksImport},
+	{"books claim", "Give a user the books added before each user had their own library", runBooksClaim},
+	{"punch report", "Show a user's punch clock for a month", runPunchReport},
+	{"migrate status", "List applied and pending database migrations", runMigrateStatus},
+	{"migrate", "Apply pending database migrations", runMigrate},
+	{"backup", "Archive all server data and prune old archives", runBackup},
+	{"restore", "Check an archive and restore the server data from it", runRestore},
+}
+
+// Runs the command named by the first one or two arguments. With no command,
+// or only flags, the server runs.
+func runCommand(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+	if args[0] == "help" {
+		printCommands(os.Stdout)
+		return nil
+	}
+
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && slices.Equal(args[:len(words)], words) {
+			if err := cmd.run(args[len(words):]); err != nil {
+				return fmt.Errorf("%s: %w", cmd.name, err)
+			}
+			return nil
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", strings.Join(args, " "))
+	printCommands(os.Stderr)
+	os.Exit(2)
+	return nil
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags] [arguments]\n\nCommands:\n", programName())
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun %s <command> -h for a command's flags.\n", programName())
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// Returns a flag set for the command along with the -config flag every
+// command accepts. args describes its arguments for the usage message.
+func newFlagSet(name, args string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configPath := fs.String("config", "./nbirdhttp.json", "path to the JSON config file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n\n", programName(), name, args)
+		fs.PrintDefaults()
+	}
+	return fs, configPath
+}
+
+// Parses the command's flags and requires exactly n arguments after them
+func parseArgs(fs *flag.FlagSet, args []string, n int) {
+	fs.Parse(args)
+	if fs.NArg() != n {
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// Reads a password from stdin, without echoing it when stdin is a terminal
+func readPassword(prompt string) (string, error) {
+	info, err := os.Stdin.Stat()
+	interactive := err == nil && info.Mode()&os.ModeCharDevice != 0
+	if interactive {
+		fmt.Fprint(os.Stderr, prompt)
+		if stty("-echo") == nil {
+			defer func() {
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("password is required")
+	}
+	return password, nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+func runUserList(args []string) error {
+	fs, configPath := newFlagSet("user list", "")
+	parseArgs(fs, args, 0)
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	users, err := auth.Users()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if slices.Contains(cfg.Admins, user) {
+			fmt.Printf("%s (admin)\n", user)
+		} else {
+			fmt.Println(user)
+		}
+	}
+	return nil
+}
+
+func runUserAdd(args []string) error {
+	fs, _ := newFlagSet("user add", "<username>")
+	parseArgs(fs, args, 1)
+	user := fs.Arg(0)
+
+	password, err := readPassword(fmt.Sprintf("Password for %s: ", user))
+	if err != nil {
+		return err
+	}
+	if err := auth.AddUser(user, password); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s\n", user)
+	return nil
+}
+
+func runUserDelete(args []string) error {
+	fs, _ := newFlagSet("user delete", "<username>")
+	parseArgs(fs, args, 1)
+	user := fs.Arg(0)
+
+	if err := auth.DeleteUser(user); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %s\n", user)
+	return nil
+}
+
+func runUserPasswd(args []string) error {
+	fs, _ := newFlagSet("user passwd", "<username>")
+	parseArgs(fs, args, 1)
+	user := fs.Arg(0)
+
+	password, err := readPassword(fmt.Sprintf("New password for %s: ", user))
+	if err != nil {
+		return err
+	}
+	if err := auth.SetPassword(user, password); err != nil {
+		return err
+	}
+	fmt.Printf("Changed the password for %s\n", user)
+	return nil
+}
+
+func runBooksExport(args []string) error {
+	fs, _ := newFlagSet("books export", "")
+	output := fs.String("o", "-", "file to write to, - for stdout")
+	user := fs.String("user", "", "only export the books in this user's libraries")
+	parseArgs(fs, args, 0)
+
+	w := os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := books.Migrate(context.Background()); err != nil {
+		return err
+	}
+	n, err := books.Export(context.Background(), w, *user)
+	if err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d books\n", n)
+	return nil
+}
+
+func runBooksImport(args []string) error {
+	fs, _ := newFlagSet("books import", "<file, or - for stdin>")
+	user := fs.String("user", "", "username whose library to add the books to (required)")
+	parseArgs(fs, args, 1)
+	if *user == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	r := os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := books.Migrate(context.Background()); err != nil {
+		return err
+	}
+	added, skipped, err := books.Import(context.Background(), r, *user)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added %d books, skipped %d already in the library\n", added, skipped)
+	return nil
+}
+
+func runBooksClaim(args []string) error {
+	fs, _ := newFlagSet("books claim", "<username>")
+	parseArgs(fs, args, 1)
+
+	if err := books.Migrate(context.Background()); err != nil {
+		return err
+	}
+	n, err := books.ClaimUnowned(context.Background(), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Println("No books are without an owner")
+		return nil
+	}
+	fmt.Printf("%s now owns the shared library of earlier books; add household members from the books page\n", fs.Arg(0))
+	return nil
+}
+
+func runPunchReport(args []string) error {
+	fs, _ := newFlagSet("punch report", "")
+	user := fs.String("user", "", "username whose clock to report (required)")
+	monthFlag := fs.String("month", time.Now().Format("2006-01"), "month to report, as YYYY-MM")
+	parseArgs(fs, args, 0)
+	if *user == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	month, err := time.ParseInLocation("2006-01", *monthFlag, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid month %q, want YYYY-MM", *monthFlag)
+	}
+
+	report, err := punch.MonthReport(*user, month)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Punch clock for %s, %s\n\n", report.User, report.Month.Format("January 2006"))
+	if len(report.Days) == 0 {
+		fmt.Println("No days punched in.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Date\tIn\tOut\tBreaks\tHours")
+	for _, day := range report.Days {
+		hours := "-"
+		if day.POut != "" {
+			hours = fmt.Sprintf("%.2f", day.Hours)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", day.Date.Format("Mon, Jan 02"), day.PIn, day.POut, day.Breaks, hours)
+	}
+	fmt.Fprintf(tw, "Total\t\t\t\t%.2f\n", report.Hours)
+	return tw.Flush()
+}
+
+// The databases migrate works on
+var databases = []struct {
+	name       string
+	db         *sql.DB
+	migrations []migrate.Migration
+}{
+	{"books", books.DB, books.Migrations},
+}
+
+func runMigrate(args []string) error {
+	fs, _ := newFlagSet("migrate", "")
+	dryRun := fs.Bool("dry-run", false, "run the pending migrations, then roll them back")
+	parseArgs(fs, args, 0)
+
+	for _, d := range databases {
+		applied, err := migrate.Apply(context.Background(), d.db, d.migrations, *dryRun)
+		verb := "applied"
+		if *dryRun {
+			verb = "would apply"
+		}
+		for _, m := range applied {
+			fmt.Printf("%s: %s %d %s\n", d.name, verb, m.Version, m.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.name, err)
+		}
+		if len(applied) == 0 {
+			fmt.Printf("%s: up to date\n", d.name)
+		}
+	}
+	return nil
+}
+
+func runMigrateStatus(args []string) error {
+	fs, _ := newFlagSet("migrate status", "")
+	parseArgs(fs, args, 0)
+
+	ctx := context.Background()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Database\tVersion\tName\tApplied")
+	for _, d := range databases {
+		applied, err := migrate.Applied(ctx, d.db)
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.name, err)
+		}
+		pending, err := migrate.Pending(ctx, d.db, d.migrations)
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.name, err)
+		}
+		for _, record := range applied {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", d.name, record.Version, record.Name, record.AppliedAt.Local().Format(time.DateTime))
+		}
+		for _, m := range pending {
+			fmt.Fprintf(tw, "%s\t%d\t%s\tpending\n", d.name, m.Version, m.Name)
+		}
+	}
+	return tw.Flush()
+}
+
+// Backs up the data in the working directory, then prunes old archives
+func runBackup(args []string) error {
+	fs, configPath := newFlagSet("backup", "")
+	dir := fs.String("dir", "", "directory to write the archive to (overrides the config file)")
+	keep := fs.Int("keep", -1, "number of archives to keep, 0 for all (overrides the config file)")
+	parseArgs(fs, args, 0)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	opts := cfg.Backup
+	if *dir != "" {
+		opts.Dir = *dir
+	}
+	if *keep >= 0 {
+		opts.Keep = *keep
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	archive, err := backup.Create(ctx, ".", opts.Dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", archive)
+
+	removed, err := backup.Prune(opts.Dir, opts.Keep)
+	for _, old := range removed {
+		fmt.Printf("Removed %s\n", old)
+	}
+	return err
+}
+
+// Validates an archive and, once confirmed, replaces the data in the working
+// directory with it
+func runRestore(args []string) error {
+	fs, _ := newFlagSet("restore", "<archive>\n\nStop the server before restoring.")
+	yes := fs.Bool("yes", false, "replace the live data; without it the archive is only checked")
+	parseArgs(fs, args, 1)
+	archive := fs.Arg(0)
+
+	manifest, err := backup.Validate(archive)
+	if err != nil {
+		return fmt.Errorf("invalid archive: %w", err)
+	}
+	var size int64
+	for _, file := range manifest.Files {
+		size += file.Size
+	}
+	fmt.Printf("%s is valid: %d files, %d bytes, created %s\n",
+		archive, len(manifest.Files), size, manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	if !*yes {
+		fmt.Println("Run again with -yes to restore it.")
+		return nil
+	}
+
+	aside, err := backup.Restore(archive, ".")
+	if aside != "" {
+		fmt.Printf("The data it replaced was moved to %s\n", aside)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Restored.")
+	return nil
+}
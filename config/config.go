@@ -0,0 +1,103 @@
+// Package config loads the server's settings from a JSON file.
+package config
+
+import (
+	"NbirdHttp/backup"
+	"NbirdHttp/lookup"
+	"NbirdHttp/middleware"
+	"NbirdHttp/proxy"
+	"NbirdHttp/scheduler"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+type Config struct {
+	// How long to wait for connections to drain and services to stop
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// Reverse proxies whose X-Forwarded-For headers are trusted (IPs or CIDRs)
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Rate limit policies by route group: auth, isbn, writes, reads
+	RateLimits map[string]middleware.RatePolicy `json:"rate_limits"`
+	// Cross-origin access to /api/ routes, e.g. for the standalone QuickPen app
+	CORS middleware.CORSOptions `json:"cors"`
+	// Users allowed to use the /api/admin/ routes
+	Admins []string `json:"admins"`
+	// Where and how often the data is backed up
+	Backup backup.Options `json:"backup"`
+	// Hosts and paths forwarded to other local services, tried in order
+	// before the server's own routes
+	Proxies []proxy.Route `json:"proxies"`
+	// Where ISBN lookups find books' details
+	BookLookup lookup.Options `json:"book_lookup"`
+}
+
+// Duration is a time.Duration written as a string like "10s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func Default() *Config {
+	return &Config{
+		ShutdownTimeout: Duration(10 * time.Second),
+		RateLimits: map[string]middleware.RatePolicy{
+			"auth":   {PerMinute: 10, Burst: 5},
+			"isbn":   {PerMinute: 10, Burst: 10},
+			"writes": {PerMinute: 120, Burst: 30, ByUser: true},
+			"reads":  {PerMinute: 600, Burst: 100, ByUser: true},
+		},
+		CORS:       middleware.DefaultCORSOptions,
+		Backup:     backup.DefaultOptions,
+		BookLookup: lookup.DefaultOptions,
+	}
+}
+
+// Load reads the config file at path over the defaults. A missing file is
+// not an error; the defaults are returned.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if _, err := middleware.ParseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if _, err := proxy.New(cfg.Proxies, nil, nil); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if _, err := lookup.New(cfg.BookLookup, nil); err != nil {
+		return nil, fmt.Errorf("parsing %s: book_lookup: %w", path, err)
+	}
+	if cfg.Backup.Schedule != "" {
+		if _, err := scheduler.ParseSchedule(cfg.Backup.Schedule); err != nil {
+			return nil, fmt.Errorf("parsing %s: backup: %w", path, err)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("missing file", func(t *testing.T) {
+		cfg, err := Load(filepath.Join(dir, "missing.json"))
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if time.Duration(cfg.ShutdownTimeout) != 10*time.Second {
+			t.Errorf("ShutdownTimeout = %v, want default", time.Duration(cfg.ShutdownTimeout))
+		}
+	})
+
+	t.Run("overrides defaults", func(t *testing.T) {
+		path := filepath.Join(dir, "config.json")
+		os.WriteFile(path, []byte(`{
+			"shutdown_timeout": "3s",
+			"trusted_proxies": ["127.0.0.1", "10.0.0.0/8"],
+			"rate_limits": {"auth": {"per_minute": 1, "burst": 2}}
+		}`), 0666)
+
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if time.Duration(cfg.ShutdownTimeout) != 3*time.Second {
+			t.Errorf("ShutdownTimeout = %v, want 3s", time.Duration(cfg.ShutdownTimeout))
+		}
+		if got := cfg.RateLimits["auth"].Burst; got != 2 {
+			t.Errorf("auth burst = %d, want 2", got)
+		}
+		if _, ok := cfg.RateLimits["reads"]; !ok {
+			t.Errorf("default reads policy was dropped")
+		}
+	})
+
+	t.Run("example file", func(t *testing.T) {
+		cfg, err := Load(filepath.Join("..", "nbirdhttp.example.json"))
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if len(cfg.Proxies) == 0 || len(cfg.Admins) == 0 {
+			t.Errorf("example settings were not loaded: %+v", cfg)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, content := range []string{
+			`{"shutdown_timeout": 5}`,
+			`{"trusted_proxies": ["not-an-ip"]}`,
+			`{"backup": {"schedule": "every night"}}`,
+			`{"book_lookup": {"providers": ["amazon"]}}`,
+			`{"book_lookup": {"providers": ["local"]}}`,
+			`{"book_lookup": {"timeout": -1}}`,
+			`{"proxies": [{"path": "/ha/"}]}`,
+			`{"proxies": [{"host": "ha.local", "upstreams": ["127.0.0.1:8123"]}]}`,
+			`{`,
+		} {
+			path := filepath.Join(dir, "bad.json")
+			os.WriteFile(path, []byte(content), 0666)
+			if _, err := Load(path); err == nil {
+				t.Errorf("Load(%s) succeeded, want error", content)
+			}
+		}
+	})
+}
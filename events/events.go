@@ -0,0 +1,296 @@
+// Package events is a publish/subscribe hub that services use to announce
+// changes, streamed to browsers as Server-Sent Events from GET /api/events.
+//
+// Topics are dotted names such as punch.in or book.created. Events published
+// for a user are only delivered to that user; events without one are public.
+package events
+
+import (
+	"NbirdHttp/api"
+	"NbirdHttp/auth"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a single message published to the hub.
+type Event struct {
+	ID    uint64    `json:"id"`
+	Topic string    `json:"topic"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+	// User the event belongs to, empty for public events
+	User string `json:"-"`
+}
+
+// Hub fans published events out to subscribed streams and keeps the most
+// recent ones so reconnecting clients can catch up.
+type Hub struct {
+	// Interval between keep-alive comments on idle streams
+	Heartbeat time.Duration
+
+	mu        sync.Mutex
+	lastID    uint64
+	history   []Event // oldest first, at most historySize long
+	size      int
+	subs      map[*subscriber]struct{}
+	listeners []func(Event)
+	closed    chan struct{}
+}
+
+type subscriber struct {
+	user   string
+	topics []string
+	ch     chan Event
+}
+
+// Events a stream may fall behind by before it is dropped. The client then
+// reconnects and resumes from the history.
+const subscriberBuffer = 64
+
+// NewHub returns a hub that remembers the last historySize events.
+func NewHub(historySize int) *Hub {
+	return &Hub{
+		Heartbeat: 15 * time.Second,
+		size:      historySize,
+		subs:      map[*subscriber]struct{}{},
+		closed:    make(chan struct{}),
+	}
+}
+
+// Publish sends data to every stream subscribed to topic and to every
+// listener. An empty user makes the event public.
+func (h *Hub) Publish(topic, user string, data any) Event {
+	h.mu.Lock()
+	h.lastID++
+	event := Event{ID: h.lastID, Topic: topic, Time: time.Now(), Data: data, User: user}
+	h.history = append(h.history, event)
+	if len(h.history) > h.size {
+		h.history = h.history[len(h.history)-h.size:]
+	}
+
+	for sub := range h.subs {
+		if !sub.wants(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			log.Printf("[WARN] events: dropping slow subscriber %q\n", sub.user)
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+	listeners := h.listeners
+	h.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(event)
+	}
+	return event
+}
+
+// PublishVerified publishes an event belonging to user only if r is signed in
+// as that user. Handlers that take the user from a parameter use it, so
+// naming someone else doesn't put events in their streams or fire their
+// webhooks.
+func (h *Hub) PublishVerified(r *http.Request, topic, user string, data any) {
+	if user == "" || auth.VerifiedUser(r) != user {
+		return
+	}
+	h.Publish(topic, user, data)
+}
+
+// Listen calls fn with every event published after it is registered,
+// whatever its topic or user. fn runs in the publisher's goroutine, so it
+// should hand slow work off elsewhere.
+func (h *Hub) Listen(fn func(Event)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listeners = append(h.listeners, fn)
+}
+
+// Adds a subscriber and returns the events it missed since lastID
+func (h *Hub) subscribe(user string, topics []string, lastID uint64) (*subscriber, []Event) {
+	sub := &subscriber{user: user, topics: topics, ch: make(chan Event, subscriberBuffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []Event
+	if lastID > 0 {
+		for _, event := range h.history {
+			if event.ID > lastID && sub.wants(event) {
+				missed = append(missed, event)
+			}
+		}
+	}
+	h.subs[sub] = struct{}{}
+	return sub, missed
+}
+
+func (h *Hub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Subscribers returns the number of open streams.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Close ends every open stream. Streams hold their connections open
+// indefinitely, so the server must close them before it can shut down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.closed:
+	default:
+		close(h.closed)
+	}
+}
+
+// Reports whether the event is visible to the subscriber and matches one of
+// its topics. A topic matches itself and everything below it, so "punch"
+// matches "punch.break.start".
+func (sub *subscriber) wants(event Event) bool {
+	if event.User != "" && event.User != sub.user {
+		return false
+	}
+	if len(sub.topics) == 0 {
+		return true
+	}
+	for _, topic := range sub.topics {
+		if event.Topic == topic || strings.HasPrefix(event.Topic, topic+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP streams events to the client until it disconnects or the hub is
+// closed. Clients resume after a dropped connection with Last-Event-ID.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var topics []string
+	for _, topic := range strings.Split(r.URL.Query().Get("topics"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	// Private events only go to a user who proves who they are
+	sub, missed := h.subscribe(auth.VerifiedUser(r), topics, lastID)
+	defer h.unsubscribe(sub)
+
+	// The stream outlives any write timeout set on the server
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	for _, event := range missed {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-h.closed:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[ERROR] events: encoding %s: %v\n", event.Topic, err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Topic, data)
+	return err
+}
+
+// Default is the hub services publish to and GET /api/events streams from.
+var Default = NewHub(256)
+
+// Publish sends an event on the default hub.
+func Publish(topic, user string, data any) {
+	Default.Publish(topic, user, data)
+}
+
+// PublishVerified publishes an event on the default hub if r is signed in as
+// user.
+func PublishVerified(r *http.Request, topic, user string, data any) {
+	Default.PublishVerified(r, topic, user, data)
+}
+
+// Listen registers fn to be called with every event on the default hub.
+func Listen(fn func(Event)) {
+	Default.Listen(fn)
+}
+
+// Close ends every stream on the default hub.
+func Close() {
+	Default.Close()
+}
+
+func EventsController() {
+	api.Handle(api.Route{
+		Method:  "GET",
+		Pattern: "/api/events",
+		Tag:     "events",
+		Summary: "Stream of live updates as Server-Sent Events",
+		Description: "Each event is named after its topic (e.g. punch.in, book.created) and " +
+			"carries an Event as JSON. Events belonging to a user are only sent to that user, " +
+			"signed in with the session cookie or HTTP Basic credentials. " +
+			"Reconnecting clients receive the events they missed after Last-Event-ID. " +
+			"A comment is sent every 15 seconds to keep idle connections open.",
+		Params: []api.Param{
+			{Name: "topics", In: "query", Description: "Comma separated topics to receive, e.g. punch,book.created (default all)"},
+			{Name: "Last-Event-ID", In: "header", Description: "ID of the last event received, to resume after it"},
+		},
+		Response:     Event{},
+		ResponseType: "text/event-stream",
+		Handler:      Default.ServeHTTP,
+	})
+}
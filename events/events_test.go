@@ -0,0 +1,162 @@
+package events
+
+import (
+	"NbirdHttp/auth"
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscriberWants(t *testing.T) {
+	sub := &subscriber{user: "alice", topics: []string{"punch", "book.created"}}
+	tests := []struct {
+		event Event
+		want  bool
+	}{
+		{Event{Topic: "punch.in", User: "alice"}, true},
+		{Event{Topic: "punch.break.start", User: "alice"}, true},
+		{Event{Topic: "punch.in", User: "bob"}, false},
+		{Event{Topic: "punchline"}, false},
+		{Event{Topic: "book.created"}, true},
+		{Event{Topic: "book.deleted"}, false},
+	}
+	for _, tt := range tests {
+		if got := sub.wants(tt.event); got != tt.want {
+			t.Errorf("wants(%s for %q) = %v, want %v", tt.event.Topic, tt.event.User, got, tt.want)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	h := NewHub(3)
+	for i := 0; i < 5; i++ {
+		h.Publish("book.created", "", i)
+	}
+	h.Publish("punch.in", "bob", nil)
+
+	sub, missed := h.subscribe("alice", nil, 3)
+	defer h.unsubscribe(sub)
+
+	// Events 1-3 have left the history and 6 belongs to bob
+	if len(missed) != 2 || missed[0].ID != 4 || missed[1].ID != 5 {
+		t.Errorf("missed = %+v, want events 4 and 5", missed)
+	}
+}
+
+func TestListen(t *testing.T) {
+	h := NewHub(10)
+	var got []string
+	h.Listen(func(event Event) { got = append(got, event.Topic+" "+event.User) })
+
+	h.Publish("punch.in", "alice", nil)
+	h.Publish("book.created", "", nil)
+
+	if want := []string{"punch.in alice", "book.created "}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("listener got %q, want %q", got, want)
+	}
+}
+
+func TestPublishVerified(t *testing.T) {
+	auth.AUTH_FILE = filepath.Join(t.TempDir(), ".auth")
+	defer func() { auth.AUTH_FILE = "./auth/.auth" }()
+	os.WriteFile(auth.AUTH_FILE, []byte(fmt.Sprintf("alice,%x\n", sha256.Sum256([]byte("secret")))), 0600)
+
+	h := NewHub(10)
+	var got []string
+	h.Listen(func(event Event) { got = append(got, event.Topic+" "+event.User) })
+
+	signedIn := httptest.NewRequest("POST", "/api/punch/in?user=alice", nil)
+	signedIn.SetBasicAuth("alice", "secret")
+	h.PublishVerified(signedIn, "punch.in", "alice", nil)
+	// Naming alice, or signing in as her but naming someone else, publishes nothing
+	h.PublishVerified(httptest.NewRequest("POST", "/api/punch/in?user=alice", nil), "punch.out", "alice", nil)
+	forged := httptest.NewRequest("POST", "/api/punch/in?user=alice", nil)
+	forged.Header.Set("Authorization", "Bearer alice")
+	h.PublishVerified(forged, "punch.out", "alice", nil)
+	h.PublishVerified(signedIn, "punch.out", "bob", nil)
+
+	if want := "punch.in alice"; strings.Join(got, ",") != want {
+		t.Errorf("listener got %q, want %q", got, want)
+	}
+}
+
+func TestStream(t *testing.T) {
+	h := NewHub(10)
+	h.Heartbeat = 20 * time.Millisecond
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	auth.AUTH_FILE = filepath.Join(t.TempDir(), ".auth")
+	defer func() { auth.AUTH_FILE = "./auth/.auth" }()
+	os.WriteFile(auth.AUTH_FILE, []byte(fmt.Sprintf("alice,%x\n", sha256.Sum256([]byte("secret")))), 0600)
+
+	h.Publish("punch.in", "alice", nil)
+
+	open := func(req *http.Request) (*bufio.Scanner, func() string) {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+		lines := bufio.NewScanner(resp.Body)
+		return lines, func() string {
+			for lines.Scan() {
+				if line := lines.Text(); line != "" && !strings.HasPrefix(line, "retry:") {
+					return line
+				}
+			}
+			t.Fatalf("stream ended: %v", lines.Err())
+			return ""
+		}
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"?topics=punch", nil)
+	req.SetBasicAuth("alice", "secret")
+	lines, next := open(req)
+	// Naming alice without her password only gets public events
+	forged, _ := http.NewRequest("GET", server.URL+"?user=alice&topics=punch", nil)
+	forged.Header.Set("Authorization", "Bearer alice")
+	_, nextForged := open(forged)
+
+	// Heartbeats keep the idle stream open
+	if line := next(); line != ": heartbeat" {
+		t.Errorf("got %q, want a heartbeat", line)
+	}
+	if line := nextForged(); line != ": heartbeat" {
+		t.Errorf("forged: got %q, want a heartbeat", line)
+	}
+
+	h.Publish("book.created", "", nil)
+	h.Publish("punch.out", "bob", nil)
+	h.Publish("punch.out", "alice", map[string]int{"hours": 8})
+
+	for next() != "id: 4" {
+	}
+	if line := next(); line != "event: punch.out" {
+		t.Errorf("got %q, want event: punch.out", line)
+	}
+	if line := next(); !strings.Contains(line, `"data":{"hours":8}`) {
+		t.Errorf("got %q, want the event data", line)
+	}
+
+	h.Publish("punch.in", "", nil)
+	for line := nextForged(); line != "id: 5"; line = nextForged() {
+		if strings.HasPrefix(line, "id: ") {
+			t.Errorf("forged: got %q, want only the public event 5", line)
+		}
+	}
+
+	h.Close()
+	for lines.Scan() {
+	}
+}
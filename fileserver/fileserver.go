@@ -0,0 +1,177 @@
+// Package fileserver serves the static site from an fs.FS, adding ETags,
+// Cache-Control headers and precompressed (.br/.gz) variants of assets.
+package fileserver
+
+import (
+	"NbirdHttp/negotiate"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Encodings with precompressed variants, in order of preference
+var encodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+type Handler struct {
+	fsys fs.FS
+	live bool
+
+	mu    sync.Mutex
+	etags map[string]string
+}
+
+// New returns a handler serving fsys. When live is true the files are
+// expected to change while the server runs (serving from disk during
+// development), so validators are derived from modification times and
+// nothing is cached by the browser without revalidating.
+func New(fsys fs.FS, live bool) *Handler {
+	return &Handler{fsys: fsys, live: live, etags: make(map[string]string)}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := r.URL.Path
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+
+	// Canonicalize index pages to their directory, like http.FileServer
+	if strings.HasSuffix(urlPath, "/index.html") {
+		http.Redirect(w, r, strings.TrimSuffix(urlPath, "index.html"), http.StatusMovedPermanently)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean(urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if info.IsDir() {
+		if !strings.HasSuffix(urlPath, "/") {
+			http.Redirect(w, r, path.Base(urlPath)+"/", http.StatusMovedPermanently)
+			return
+		}
+		name = path.Join(name, "index.html")
+	}
+
+	h.ServeFile(w, r, name)
+}
+
+// ServeFile responds with the named file, which is relative to the root of
+// the served filesystem.
+func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request, name string) {
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	servedName := name
+	if variant, encoding := h.precompressed(w, r, name); variant != "" {
+		servedName = variant
+		w.Header().Set("Content-Encoding", encoding)
+	}
+
+	f, err := h.fsys.Open(servedName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		log.Printf("[ERROR] static file %q is not seekable\n", servedName)
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	etag, err := h.etag(servedName, info, content)
+	if err != nil {
+		log.Printf("[ERROR] Failed to hash static file %q: %v\n", servedName, err)
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", h.cacheControl(ctype))
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// Returns the name of a precompressed variant of name accepted by the
+// client along with its content encoding, or "" if there is none.
+func (h *Handler) precompressed(w http.ResponseWriter, r *http.Request, name string) (string, string) {
+	varied := false
+	for _, enc := range encodings {
+		if _, err := fs.Stat(h.fsys, name+enc.ext); err != nil {
+			continue
+		}
+		if !varied {
+			w.Header().Add("Vary", "Accept-Encoding")
+			varied = true
+		}
+		if negotiate.AcceptsEncoding(r, enc.name) {
+			return name + enc.ext, enc.name
+		}
+	}
+	return "", ""
+}
+
+func (h *Handler) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if h.live {
+		return fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+
+	h.mu.Lock()
+	tag, ok := h.etags[name]
+	h.mu.Unlock()
+	if ok {
+		return tag, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	tag = fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+
+	h.mu.Lock()
+	h.etags[name] = tag
+	h.mu.Unlock()
+	return tag, nil
+}
+
+func (h *Handler) cacheControl(ctype string) string {
+	// Pages must always be revalidated so a new deploy shows up right away;
+	// assets can be reused for a while since their ETags will change anyway.
+	if h.live || strings.HasPrefix(ctype, "text/html") {
+		return "no-cache"
+	}
+	return "public, max-age=3600"
+}
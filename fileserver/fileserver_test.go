@@ -0,0 +1,132 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":         {Data: []byte("<h1>home</h1>")},
+		"app/index.html":     {Data: []byte("<h1>app</h1>")},
+		"app/app.js":         {Data: []byte("console.log('app')")},
+		"app/app.js.gz":      {Data: []byte("gzipped")},
+		"app/app.js.br":      {Data: []byte("brotli")},
+		"styles/main.css":    {Data: []byte("body {}"), ModTime: time.Unix(1700000000, 0)},
+		"styles/main.css.gz": {Data: []byte("gzipped css")},
+		"assets/NB-logo.png": {Data: []byte("png")},
+		"assets/readme.txt":  {Data: []byte("text")},
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := New(testFS(), false)
+
+	tests := []struct {
+		name         string
+		path         string
+		encoding     string
+		wantCode     int
+		wantBody     string
+		wantEncoding string
+		wantLocation string
+	}{
+		{"root index", "/", "", http.StatusOK, "<h1>home</h1>", "", ""},
+		{"directory index", "/app/", "", http.StatusOK, "<h1>app</h1>", "", ""},
+		{"directory redirect", "/app", "", http.StatusMovedPermanently, "", "", "/app/"},
+		{"index redirect", "/app/index.html", "", http.StatusMovedPermanently, "", "", "/app/"},
+		{"plain asset", "/app/app.js", "", http.StatusOK, "console.log('app')", "", ""},
+		{"prefers brotli", "/app/app.js", "gzip, br", http.StatusOK, "brotli", "br", ""},
+		{"gzip only", "/app/app.js", "gzip", http.StatusOK, "gzipped", "gzip", ""},
+		{"refused brotli", "/app/app.js", "br;q=0, gzip;q=0.5", http.StatusOK, "gzipped", "gzip", ""},
+		{"missing file", "/nope.js", "", http.StatusNotFound, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.encoding != "" {
+				req.Header.Set("Accept-Encoding", tt.encoding)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rr.Body.String(), tt.wantBody)
+			}
+			if got := rr.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := rr.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	h := New(testFS(), false)
+
+	tests := []struct {
+		path      string
+		wantType  string
+		wantCache string
+		wantVary  string
+	}{
+		{"/", "text/html; charset=utf-8", "no-cache", ""},
+		{"/app/app.js", "text/javascript; charset=utf-8", "public, max-age=3600", "Accept-Encoding"},
+		{"/assets/NB-logo.png", "image/png", "public, max-age=3600", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+
+			if got := rr.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tt.wantCache {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantCache)
+			}
+			if got := rr.Header().Get("Vary"); got != tt.wantVary {
+				t.Errorf("Vary = %q, want %q", got, tt.wantVary)
+			}
+			if rr.Header().Get("ETag") == "" {
+				t.Errorf("missing ETag")
+			}
+		})
+	}
+}
+
+func TestConditionalRequest(t *testing.T) {
+	for _, live := range []bool{false, true} {
+		h := New(testFS(), live)
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/styles/main.css", nil))
+		etag := rr.Header().Get("ETag")
+
+		req := httptest.NewRequest("GET", "/styles/main.css", nil)
+		req.Header.Set("If-None-Match", etag)
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("live=%v: status = %d, want %d", live, rr.Code, http.StatusNotModified)
+		}
+
+		// The compressed variant has its own validator
+		req = httptest.NewRequest("GET", "/styles/main.css", nil)
+		req.Header.Set("If-None-Match", etag)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("live=%v: gzip status = %d, want %d", live, rr.Code, http.StatusOK)
+		}
+	}
+}
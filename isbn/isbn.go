@@ -0,0 +1,135 @@
+// Package isbn validates International Standard Book Numbers and converts
+// between their 10 and 13 digit forms, so the same book is recognised
+// however its ISBN was typed or scanned.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrLength   = errors.New("an ISBN has 10 or 13 digits")
+	ErrChar     = errors.New("an ISBN has only digits, with an X as the last of 10")
+	ErrChecksum = errors.New("the ISBN's check digit is wrong")
+	// EAN-13 barcodes on other products use the same format
+	ErrPrefix = errors.New("an ISBN-13 starts with 978 or 979")
+)
+
+// Normalize checks an ISBN-10 or ISBN-13, which may contain hyphens and
+// spaces and a lowercase x, and returns it as an ISBN-13 of digits alone.
+func Normalize(s string) (string, error) {
+	s = clean(s)
+	switch len(s) {
+	case 10:
+		if err := check10(s); err != nil {
+			return "", err
+		}
+		return to13(s), nil
+	case 13:
+		if err := check13(s); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+	return "", ErrLength
+}
+
+// Valid reports whether s is an ISBN-10 or ISBN-13.
+func Valid(s string) bool {
+	_, err := Normalize(s)
+	return err == nil
+}
+
+// To10 returns the ISBN-10 form of an ISBN, or false if it has none, as
+// ISBN-13s starting with 979 don't.
+func To10(s string) (string, bool) {
+	isbn, err := Normalize(s)
+	if err != nil || !strings.HasPrefix(isbn, "978") {
+		return "", false
+	}
+	body := isbn[3:12]
+	return body + checkDigit10(body), true
+}
+
+// Removes hyphens and spaces, and upper-cases an x
+func clean(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, c := range s {
+		switch {
+		case c == '-' || c == ' ':
+		case c == 'x':
+			b.WriteRune('X')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func digits(s string) bool {
+	for i := range len(s) {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func check10(s string) error {
+	if !digits(s[:9]) || !(digits(s[9:]) || s[9] == 'X') {
+		return ErrChar
+	}
+	if checkDigit10(s[:9]) != s[9:] {
+		return ErrChecksum
+	}
+	return nil
+}
+
+func check13(s string) error {
+	if !digits(s) {
+		return ErrChar
+	}
+	if !strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979") {
+		return ErrPrefix
+	}
+	if checkDigit13(s[:12]) != s[12:] {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// The check digit of the first 9 digits of an ISBN-10: the digits weighted
+// 10 down to 2 plus it make a multiple of 11, with X for 10
+func checkDigit10(body string) string {
+	sum := 0
+	for i := range 9 {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return "X"
+	}
+	return string(rune('0' + check))
+}
+
+// The check digit of the first 12 digits of an ISBN-13: the digits weighted
+// 1 and 3 in turn plus it make a multiple of 10
+func checkDigit13(body string) string {
+	sum := 0
+	for i := range 12 {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(body[i]-'0') * weight
+	}
+	return string(rune('0' + (10-sum%10)%10))
+}
+
+// Converts a valid ISBN-10 to an ISBN-13
+func to13(s string) string {
+	body := "978" + s[:9]
+	return body + checkDigit13(body)
+}
@@ -0,0 +1,109 @@
+package isbn
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"9780441172719", "9780441172719", nil},
+		{"978-0-441-17271-9", "9780441172719", nil},
+		{"0441172717", "9780441172719", nil},
+		{"0 441 17271 7", "9780441172719", nil},
+		{"080442957X", "9780804429573", nil},
+		{"080442957x", "9780804429573", nil},
+		{"979-10-90636-07-1", "9791090636071", nil},
+		{"", "", ErrLength},
+		{"044117271", "", ErrLength},
+		{"97804411727190", "", ErrLength},
+		{"0441172718", "", ErrChecksum},
+		{"9780441172718", "", ErrChecksum},
+		{"X441172717", "", ErrChar},
+		{"978044117271X", "", ErrChar},
+		{"04411727.7", "", ErrChar},
+		{"4006381333931", "", ErrPrefix},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.in)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Normalize(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestTo10(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"9780441172719", "0441172717", true},
+		{"9780804429573", "080442957X", true},
+		{"0-441-17271-7", "0441172717", true},
+		{"9791090636071", "", false},
+		{"9780441172718", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := To10(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("To10(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func FuzzNormalize(f *testing.F) {
+	for _, seed := range []string{"9780441172719", "0-441-17271-7", "080442957x", "9791090636071", "0441172718", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		isbn, err := Normalize(s)
+		if err != nil {
+			if isbn != "" {
+				t.Errorf("Normalize(%q) = %q with error %v", s, isbn, err)
+			}
+			return
+		}
+		if len(isbn) != 13 || !digits(isbn) {
+			t.Fatalf("Normalize(%q) = %q, not 13 digits", s, isbn)
+		}
+		if checkDigit13(isbn[:12]) != isbn[12:] {
+			t.Errorf("Normalize(%q) = %q, with a wrong check digit", s, isbn)
+		}
+		// Normalizing is idempotent, and the two forms agree
+		if again, err := Normalize(isbn); again != isbn || err != nil {
+			t.Errorf("Normalize(%q) = %q, %v; want it unchanged", isbn, again, err)
+		}
+		if isbn10, ok := To10(isbn); ok {
+			if back, err := Normalize(isbn10); back != isbn || err != nil {
+				t.Errorf("Normalize(To10(%q)) = %q, %v", isbn, back, err)
+			}
+		}
+	})
+}
+
+func FuzzCheckDigit10(f *testing.F) {
+	f.Add("044117271")
+	f.Fuzz(func(t *testing.T, body string) {
+		if len(body) != 9 || !digits(body) {
+			return
+		}
+		// Any body with its check digit is valid, and changing one digit
+		// of it never is
+		isbn10 := body + checkDigit10(body)
+		if !Valid(isbn10) {
+			t.Fatalf("%q with its check digit is invalid", isbn10)
+		}
+		for i := range 9 {
+			changed := []byte(isbn10)
+			changed[i] = '0' + (changed[i]-'0'+1)%10
+			if Valid(string(changed)) {
+				t.Errorf("%q is valid after changing digit %d of %q", changed, i, isbn10)
+			}
+		}
+	})
+}
@@ -0,0 +1,65 @@
+// Package lifecycle coordinates the orderly shutdown of the server's services.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+var (
+	mu    sync.Mutex
+	hooks []hook
+)
+
+// OnShutdown registers fn to be run when the server shuts down. Hooks run in
+// reverse registration order, so a service registered after the ones it
+// depends on is stopped before them.
+func OnShutdown(name string, fn func(ctx context.Context) error) {
+	mu.Lock()
+	defer mu.Unlock()
+	hooks = append(hooks, hook{name, fn})
+}
+
+// Shutdown runs every registered hook and returns their combined errors.
+// A hook that is still running when ctx expires is abandoned and the
+// remaining hooks are reported as skipped.
+func Shutdown(ctx context.Context) error {
+	mu.Lock()
+	pending := hooks
+	hooks = nil
+	mu.Unlock()
+
+	var errs []error
+	for i := len(pending) - 1; i >= 0; i-- {
+		h := pending[i]
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Errorf("%s: skipped: %w", h.name, ctx.Err()))
+			continue
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- h.fn(ctx) }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("[ERROR] shutdown hook %q: %v\n", h.name, err)
+				errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			} else {
+				log.Printf("[INFO] shutdown hook %q done\n", h.name)
+			}
+		case <-ctx.Done():
+			log.Printf("[ERROR] shutdown hook %q did not finish in time\n", h.name)
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, ctx.Err()))
+		}
+	}
+	return errors.Join(errs...)
+}
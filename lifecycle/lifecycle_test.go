@@ -0,0 +1,74 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	var order []string
+	for _, name := range []string{"first", "second", "third"} {
+		OnShutdown(name, func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		})
+	}
+
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	want := []string{"third", "second", "first"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("hooks ran in order %v, want %v", order, want)
+	}
+}
+
+func TestShutdownErrors(t *testing.T) {
+	errBoom := errors.New("boom")
+	ran := false
+	OnShutdown("runs anyway", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	OnShutdown("fails", func(ctx context.Context) error {
+		return errBoom
+	})
+
+	err := Shutdown(context.Background())
+	if !errors.Is(err, errBoom) {
+		t.Errorf("Shutdown() error = %v, want %v", err, errBoom)
+	}
+	if !ran {
+		t.Errorf("hook after a failing hook was not run")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	skipped := true
+	OnShutdown("never reached", func(ctx context.Context) error {
+		skipped = false
+		return nil
+	})
+	OnShutdown("hangs", func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !skipped {
+		t.Errorf("hook ran after the deadline expired")
+	}
+}
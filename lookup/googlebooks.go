@@ -0,0 +1,83 @@
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GoogleBooks looks books up with the Google Books API.
+type GoogleBooks struct {
+	// Defaults to https://www.googleapis.com/books/v1
+	BaseURL string
+	// API key, if any
+	Key    string
+	Client *http.Client
+}
+
+type googleBooksVolumes struct {
+	TotalItems int `json:"totalItems"`
+	Items      []struct {
+		VolumeInfo struct {
+			Title         string   `json:"title"`
+			Subtitle      string   `json:"subtitle"`
+			Authors       []string `json:"authors"`
+			Publisher     string   `json:"publisher"`
+			PublishedDate string   `json:"publishedDate"`
+			Description   string   `json:"description"`
+			PageCount     int      `json:"pageCount"`
+			Categories    []string `json:"categories"`
+			Language      string   `json:"language"`
+			ImageLinks    struct {
+				Thumbnail string `json:"thumbnail"`
+			} `json:"imageLinks"`
+		} `json:"volumeInfo"`
+	} `json:"items"`
+}
+
+func (p *GoogleBooks) Name() string {
+	return "googlebooks"
+}
+
+func (p *GoogleBooks) Lookup(ctx context.Context, isbn string) (*Record, error) {
+	base := "https://www.googleapis.com/books/v1"
+	if p.BaseURL != "" {
+		base = strings.TrimSuffix(p.BaseURL, "/")
+	}
+	query := url.Values{"q": {"isbn:" + isbn}}
+	if p.Key != "" {
+		query.Set("key", p.Key)
+	}
+
+	var volumes googleBooksVolumes
+	if err := getJSON(ctx, p.Client, fmt.Sprintf("%s/volumes?%s", base, query.Encode()), &volumes); err != nil {
+		return nil, err
+	}
+	if volumes.TotalItems == 0 || len(volumes.Items) == 0 {
+		return nil, ErrNotFound
+	}
+
+	info := volumes.Items[0].VolumeInfo
+	record := &Record{
+		ISBN:        isbn,
+		Title:       info.Title,
+		Subtitle:    info.Subtitle,
+		Authors:     info.Authors,
+		Publisher:   info.Publisher,
+		PublishDate: info.PublishedDate,
+		Pages:       info.PageCount,
+		Language:    info.Language,
+		Description: info.Description,
+		Subjects:    info.Categories,
+	}
+	if thumbnail := info.ImageLinks.Thumbnail; thumbnail != "" {
+		// Thumbnails are linked over plain HTTP, and without the page curl
+		// and at a larger size when asked
+		thumbnail = strings.Replace(thumbnail, "http://", "https://", 1)
+		thumbnail = strings.Replace(thumbnail, "&edge=curl", "", 1)
+		record.Covers = []string{thumbnail}
+	}
+	return record, nil
+}
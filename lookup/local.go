@@ -0,0 +1,71 @@
+package lookup
+
+import (
+	"NbirdHttp/isbn"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Local looks books up in a dataset loaded from a JSON file, so common or
+// already catalogued books are found without going online.
+type Local struct {
+	// By ISBN-13, so records are found by either form
+	records map[string]*Record
+}
+
+// LoadLocal reads a JSON array of records from path. Each needs an ISBN;
+// covers may be URLs or paths the server serves, e.g. /books/covers/a.jpg.
+func LoadLocal(path string) (*Local, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	local, err := NewLocal(records)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return local, nil
+}
+
+// NewLocal returns a provider for the records.
+func NewLocal(records []*Record) (*Local, error) {
+	local := &Local{records: make(map[string]*Record, len(records))}
+	for i, record := range records {
+		if record.ISBN == "" {
+			return nil, fmt.Errorf("record %d has no ISBN", i+1)
+		}
+		isbn13, err := isbn.Normalize(record.ISBN)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: invalid ISBN %q: %w", i+1, record.ISBN, err)
+		}
+		local.records[isbn13] = record
+	}
+	return local, nil
+}
+
+func (p *Local) Name() string {
+	return "local"
+}
+
+func (p *Local) Lookup(ctx context.Context, s string) (*Record, error) {
+	isbn13, err := isbn.Normalize(s)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	record, ok := p.records[isbn13]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Callers may change the record they get
+	copied := *record
+	copied.Authors = append([]string(nil), record.Authors...)
+	copied.Subjects = append([]string(nil), record.Subjects...)
+	copied.Covers = append([]string(nil), record.Covers...)
+	return &copied, nil
+}
@@ -0,0 +1,272 @@
+// Package lookup finds books' details by ISBN, asking a list of metadata
+// providers such as Open Library in order of priority and merging what
+// they know.
+package lookup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ErrNotFound is returned by providers that don't know the ISBN, and by
+// Lookup when none of them do.
+var ErrNotFound = errors.New("book not found")
+
+// Record is what is known about an edition of a book. Empty fields are
+// unknown.
+type Record struct {
+	ISBN        string   `json:"isbn"`
+	Title       string   `json:"title"`
+	Subtitle    string   `json:"subtitle,omitempty"`
+	Authors     []string `json:"authors,omitempty"`
+	Publisher   string   `json:"publisher,omitempty"`
+	PublishDate string   `json:"publish_date,omitempty"`
+	Pages       int      `json:"pages,omitempty"`
+	// ISO 639 code, e.g. "eng" or "en"
+	Language    string `json:"language,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Topics the book is catalogued under, used to guess its genre
+	Subjects []string `json:"subjects,omitempty"`
+	// Cover image URLs, best first. A provider's cover may turn out to be
+	// missing, so Lookup keeps every provider's.
+	Covers []string `json:"covers,omitempty"`
+	// The providers the details came from, in priority order
+	Sources []string `json:"sources,omitempty"`
+	// Optional details that couldn't be fetched, such as from a provider
+	// that failed while another found the book. Looking it up again later
+	// may find more.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (r *Record) warn(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// MetadataProvider is a source of book details.
+type MetadataProvider interface {
+	// Name identifies the provider in Options and logs, e.g. "openlibrary"
+	Name() string
+	// Lookup returns the details of the edition with the ISBN, or
+	// ErrNotFound if the provider doesn't know it.
+	Lookup(ctx context.Context, isbn string) (*Record, error)
+}
+
+// Fills the fields of r that are unknown from other
+func (r *Record) merge(other *Record, source string) {
+	used := false
+	text := func(field *string, value string) {
+		if *field == "" && value != "" {
+			*field = value
+			used = true
+		}
+	}
+	list := func(field *[]string, value []string) {
+		if len(*field) == 0 && len(value) > 0 {
+			*field = value
+			used = true
+		}
+	}
+	text(&r.Title, other.Title)
+	text(&r.Subtitle, other.Subtitle)
+	list(&r.Authors, other.Authors)
+	text(&r.Publisher, other.Publisher)
+	text(&r.PublishDate, other.PublishDate)
+	if r.Pages == 0 && other.Pages > 0 {
+		r.Pages = other.Pages
+		used = true
+	}
+	text(&r.Language, other.Language)
+	text(&r.Description, other.Description)
+	list(&r.Subjects, other.Subjects)
+	for _, cover := range other.Covers {
+		if !slices.Contains(r.Covers, cover) {
+			r.Covers = append(r.Covers, cover)
+			used = true
+		}
+	}
+	if used {
+		r.Sources = append(r.Sources, source)
+	}
+	r.Warnings = append(r.Warnings, other.Warnings...)
+}
+
+// Whether every field is known, so there is nothing left to ask for. Most
+// books have no subtitle, so it isn't waited for.
+func (r *Record) complete() bool {
+	return r.Title != "" && len(r.Authors) > 0 && r.Publisher != "" &&
+		r.PublishDate != "" && r.Pages > 0 && r.Language != "" && r.Description != "" &&
+		len(r.Subjects) > 0 && len(r.Covers) > 0
+}
+
+// Lookup asks the providers for the book at the same time, merging their
+// records in priority order so each field comes from the first provider that
+// knows it. Once the providers before it have answered and the record is
+// complete, those still working are cancelled.
+//
+// It returns ErrNotFound if no provider has the book, or the first
+// provider's error if none found it because some failed. Providers that
+// failed when others found the book are noted in the record's warnings.
+func Lookup(ctx context.Context, providers []MetadataProvider, isbn string) (*Record, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		record *Record
+		err    error
+	}
+	results := make([]chan result, len(providers))
+	for i, p := range providers {
+		results[i] = make(chan result, 1)
+		go func() {
+			record, err := p.Lookup(ctx, isbn)
+			results[i] <- result{record, err}
+		}()
+	}
+
+	record := &Record{}
+	found := false
+	var failures []error
+	for i, p := range providers {
+		res := <-results[i]
+		if errors.Is(res.err, ErrNotFound) {
+			continue
+		}
+		if res.err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Printf("[WARN] lookup: %s failed for ISBN %s: %v\n", p.Name(), isbn, res.err)
+			failures = append(failures, fmt.Errorf("%s: %w", p.Name(), res.err))
+			continue
+		}
+
+		found = true
+		record.merge(res.record, p.Name())
+		if record.complete() {
+			break
+		}
+	}
+
+	if !found {
+		if len(failures) > 0 {
+			return nil, failures[0]
+		}
+		return nil, ErrNotFound
+	}
+	for _, err := range failures {
+		record.warn("%v", err)
+	}
+	record.ISBN = isbn
+	return record, nil
+}
+
+// Options chooses the providers books are looked up from.
+type Options struct {
+	// Providers to ask, highest priority first: "local", "openlibrary" and
+	// "googlebooks"
+	Providers []string `json:"providers"`
+	// JSON array of records for the local provider, such as a library's
+	// own catalogue
+	LocalDataset string `json:"local_dataset"`
+	// Google Books API key. Without one, requests share a small anonymous
+	// quota.
+	GoogleBooksKey string `json:"google_books_key"`
+	// Seconds each request to a provider may take (default 10)
+	Timeout int `json:"timeout"`
+}
+
+var DefaultOptions = Options{
+	Providers: []string{"openlibrary"},
+	Timeout:   10,
+}
+
+// NewClient returns a client for requests to providers and for cover
+// images, giving up on connections and responses that take longer than
+// opts allows.
+func NewClient(opts Options) *http.Client {
+	timeout := time.Duration(opts.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Duration(DefaultOptions.Timeout) * time.Second
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: timeout / 2}).DialContext,
+			TLSHandshakeTimeout:   timeout / 2,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConnsPerHost:   4,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
+
+// New returns the providers opts names, in order, making their requests
+// with client, or one from NewClient if it is nil.
+func New(opts Options, client *http.Client) ([]MetadataProvider, error) {
+	if len(opts.Providers) == 0 {
+		return nil, errors.New("no providers to look books up from")
+	}
+	if opts.Timeout < 0 {
+		return nil, errors.New("timeout can't be negative")
+	}
+	if client == nil {
+		client = NewClient(opts)
+	}
+	var providers []MetadataProvider
+	for _, name := range opts.Providers {
+		switch name {
+		case "openlibrary":
+			providers = append(providers, &OpenLibrary{Client: client})
+		case "googlebooks":
+			providers = append(providers, &GoogleBooks{Key: opts.GoogleBooksKey, Client: client})
+		case "local":
+			if opts.LocalDataset == "" {
+				return nil, errors.New("the local provider needs a local_dataset")
+			}
+			local, err := LoadLocal(opts.LocalDataset)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, local)
+		default:
+			return nil, fmt.Errorf("unknown provider %q", name)
+		}
+	}
+	return providers, nil
+}
+
+// Does a GET request, decoding a JSON response into v. A 404 is
+// ErrNotFound.
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("GET %s: %w", url, err)
+	}
+	return nil
+}
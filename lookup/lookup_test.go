@@ -0,0 +1,350 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Returns a server answering with the JSON bodies keyed by path and query,
+// and 404 for anything else
+func jsonServer(t *testing.T, bodies map[string]string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, ok := bodies[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if body == "" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestOpenLibrary(t *testing.T) {
+	srv, _ := jsonServer(t, map[string]string{
+		"/isbn/9780441172719.json": `{
+			"title": "Dune",
+			"authors": [{"key": "/authors/OL1A"}],
+			"works": [{"key": "/works/OL1W"}],
+			"publishers": ["Ace"],
+			"publish_date": "1990",
+			"number_of_pages": 535,
+			"languages": [{"key": "/languages/eng"}]
+		}`,
+		"/works/OL1W.json": `{
+			"subjects": ["Science fiction", {"name": "Deserts"}],
+			"description": {"type": "/type/text", "value": "Spice and sand"}
+		}`,
+		"/authors/OL1A.json": `{"name": "Frank Herbert"}`,
+		// Without authors on the edition, the work's are used
+		"/isbn/0000000000.json": `{"title": "Anonymous", "works": [{"key": "/works/OL2W"}]}`,
+		"/works/OL2W.json":      `{"authors": [{"author": {"key": "/authors/OL1A"}}], "description": "Plain"}`,
+	})
+	p := &OpenLibrary{BaseURL: srv.URL, CoversURL: "https://covers.test"}
+
+	record, err := p.Lookup(context.Background(), "9780441172719")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Title != "Dune" || !slices.Equal(record.Authors, []string{"Frank Herbert"}) ||
+		record.Publisher != "Ace" || record.Pages != 535 || record.Language != "eng" {
+		t.Errorf("record = %+v", record)
+	}
+	if record.Description != "Spice and sand" || !slices.Equal(record.Subjects, []string{"Science fiction", "Deserts"}) {
+		t.Errorf("work details = %q, %q", record.Description, record.Subjects)
+	}
+	if len(record.Covers) != 1 || record.Covers[0] != "https://covers.test/b/isbn/9780441172719-L.jpg?default=false" {
+		t.Errorf("covers = %q", record.Covers)
+	}
+
+	record, err = p.Lookup(context.Background(), "0000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(record.Authors, []string{"Frank Herbert"}) || record.Description != "Plain" {
+		t.Errorf("record from work = %+v", record)
+	}
+
+	if _, err := p.Lookup(context.Background(), "9999999999"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown ISBN: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestOpenLibraryPartial(t *testing.T) {
+	// The work is down and one author is missing
+	srv, _ := jsonServer(t, map[string]string{
+		"/isbn/1.json": `{
+			"title": "Good Omens",
+			"authors": [{"key": "/authors/OL1A"}, {"key": "/authors/OL2A"}, {"key": "/authors/OL3A"}],
+			"works": [{"key": "/works/OL1W"}],
+			"subjects": ["Comedy"]
+		}`,
+		"/works/OL1W.json":   "",
+		"/authors/OL1A.json": `{"name": "Terry Pratchett"}`,
+		"/authors/OL3A.json": `{"name": "Neil Gaiman"}`,
+	})
+	p := &OpenLibrary{BaseURL: srv.URL}
+
+	record, err := p.Lookup(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(record.Authors, []string{"Terry Pratchett", "Neil Gaiman"}) {
+		t.Errorf("authors = %q, want those found in order", record.Authors)
+	}
+	if !slices.Equal(record.Subjects, []string{"Comedy"}) {
+		t.Errorf("subjects = %q, want the edition's", record.Subjects)
+	}
+	if len(record.Warnings) != 2 {
+		t.Errorf("warnings = %q, want the work and the missing author", record.Warnings)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewClient(Options{Timeout: 1})
+	start := time.Now()
+	err := getJSON(context.Background(), client, srv.URL, &Record{})
+	if err == nil {
+		t.Fatal("slow response succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("gave up after %v, want about a second", elapsed)
+	}
+}
+
+func TestGoogleBooks(t *testing.T) {
+	srv, _ := jsonServer(t, map[string]string{
+		"/volumes?key=secret&q=isbn%3A9780441172719": `{"totalItems": 1, "items": [{"volumeInfo": {
+			"title": "Dune",
+			"subtitle": "Deluxe Edition",
+			"authors": ["Frank Herbert"],
+			"pageCount": 896,
+			"language": "en",
+			"categories": ["Fiction"],
+			"imageLinks": {"thumbnail": "http://books.test/cover?id=1&edge=curl"}
+		}}]}`,
+		"/volumes?key=secret&q=isbn%3A9999999999": `{"totalItems": 0}`,
+	})
+	p := &GoogleBooks{BaseURL: srv.URL, Key: "secret"}
+
+	record, err := p.Lookup(context.Background(), "9780441172719")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Subtitle != "Deluxe Edition" || record.Pages != 896 || record.Language != "en" {
+		t.Errorf("record = %+v", record)
+	}
+	if len(record.Covers) != 1 || record.Covers[0] != "https://books.test/cover?id=1" {
+		t.Errorf("covers = %q", record.Covers)
+	}
+
+	if _, err := p.Lookup(context.Background(), "9999999999"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("no volumes: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	p, err := NewLocal([]*Record{{ISBN: "0-441-17271-7", Title: "Dune", Authors: []string{"Frank Herbert"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := p.Lookup(context.Background(), "0441172717")
+	if err != nil || record.Title != "Dune" {
+		t.Fatalf("Lookup() = %+v, %v", record, err)
+	}
+	record.Authors[0] = "changed"
+	if again, _ := p.Lookup(context.Background(), "0441172717"); again.Authors[0] != "Frank Herbert" {
+		t.Error("changing a record changed the dataset")
+	}
+	if record, err := p.Lookup(context.Background(), "978-0-441-17271-9"); err != nil || record.Title != "Dune" {
+		t.Errorf("Lookup() by ISBN-13 = %+v, %v", record, err)
+	}
+	if _, err := p.Lookup(context.Background(), "9780765326355"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown ISBN: err = %v, want ErrNotFound", err)
+	}
+
+	if _, err := NewLocal([]*Record{{Title: "No ISBN"}}); err == nil {
+		t.Error("record without an ISBN was accepted")
+	}
+	if _, err := NewLocal([]*Record{{ISBN: "0441172718", Title: "Bad check digit"}}); err == nil {
+		t.Error("record with an invalid ISBN was accepted")
+	}
+}
+
+// A provider answering with a fixed record or error, once wait is closed if
+// it has one
+type fakeProvider struct {
+	name   string
+	record *Record
+	err    error
+	wait   chan struct{}
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) Lookup(ctx context.Context, isbn string) (*Record, error) {
+	if p.wait != nil {
+		select {
+		case <-p.wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return p.record, p.err
+}
+
+// A provider that closes started when asked, then waits to be cancelled
+type blockingProvider struct {
+	started chan struct{}
+}
+
+func (p *blockingProvider) Name() string { return "blocking" }
+
+func (p *blockingProvider) Lookup(ctx context.Context, isbn string) (*Record, error) {
+	close(p.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestLookup(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("merges fields in priority order", func(t *testing.T) {
+		first := &fakeProvider{name: "first", record: &Record{Title: "Dune", Covers: []string{"a.jpg"}}}
+		missing := &fakeProvider{name: "missing", err: ErrNotFound}
+		second := &fakeProvider{name: "second", record: &Record{
+			Title: "DUNE", Authors: []string{"Frank Herbert"}, Pages: 535, Covers: []string{"a.jpg", "b.jpg"},
+		}}
+		unused := &fakeProvider{name: "unused", record: &Record{Title: "Other"}}
+
+		record, err := Lookup(ctx, []MetadataProvider{first, missing, second, unused}, "9780441172719")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Title != "Dune" || record.Authors[0] != "Frank Herbert" || record.Pages != 535 {
+			t.Errorf("record = %+v", record)
+		}
+		if !slices.Equal(record.Covers, []string{"a.jpg", "b.jpg"}) {
+			t.Errorf("covers = %q, want both providers' in order", record.Covers)
+		}
+		// Only providers that filled something in are sources
+		if !slices.Equal(record.Sources, []string{"first", "second"}) {
+			t.Errorf("sources = %q", record.Sources)
+		}
+		if record.ISBN != "9780441172719" {
+			t.Errorf("ISBN = %q", record.ISBN)
+		}
+	})
+
+	t.Run("asks providers at the same time", func(t *testing.T) {
+		// The first only answers once the second has been asked. The second
+		// blocks until cancelled, so finishing at all means the lookup gave
+		// up on it once the first's record was complete.
+		second := &blockingProvider{started: make(chan struct{})}
+		first := &fakeProvider{name: "first", wait: second.started, record: &Record{
+			Title: "Dune", Authors: []string{"Frank Herbert"}, Publisher: "Ace", PublishDate: "1990", Pages: 535,
+			Language: "eng", Description: "Spice", Subjects: []string{"Fiction"}, Covers: []string{"a.jpg"},
+		}}
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := Lookup(ctx, []MetadataProvider{first, second}, "9780441172719")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("providers were asked one after another, or the slower one wasn't cancelled")
+		}
+	})
+
+	t.Run("cancels with the context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		blocked := &blockingProvider{started: make(chan struct{})}
+		go func() {
+			<-blocked.started
+			cancel()
+		}()
+		if _, err := Lookup(ctx, []MetadataProvider{blocked}, "1"); !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		down := &fakeProvider{name: "down", err: errors.New("connection refused")}
+		missing := &fakeProvider{name: "missing", err: ErrNotFound}
+		found := &fakeProvider{name: "found", record: &Record{Title: "Dune"}}
+
+		if _, err := Lookup(ctx, []MetadataProvider{missing}, "1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("nobody has it: err = %v, want ErrNotFound", err)
+		}
+		if _, err := Lookup(ctx, []MetadataProvider{down, missing}, "1"); err == nil || errors.Is(err, ErrNotFound) ||
+			!strings.Contains(err.Error(), "down") {
+			t.Errorf("provider failed: err = %v, want its error", err)
+		}
+		record, err := Lookup(ctx, []MetadataProvider{down, found}, "1")
+		if err != nil || record.Title != "Dune" {
+			t.Fatalf("fallback after failure = %+v, %v", record, err)
+		}
+		if len(record.Warnings) != 1 || !strings.Contains(record.Warnings[0], "down") {
+			t.Errorf("warnings = %q, want the failed provider", record.Warnings)
+		}
+	})
+
+	t.Run("upstream errors", func(t *testing.T) {
+		srv, requests := jsonServer(t, map[string]string{"/isbn/1.json": ""})
+		backup := &fakeProvider{name: "backup", record: &Record{Title: "Dune"}}
+		record, err := Lookup(ctx, []MetadataProvider{&OpenLibrary{BaseURL: srv.URL}, backup}, "1")
+		if err != nil || record.Title != "Dune" || requests.Load() != 1 {
+			t.Errorf("Lookup() = %+v, %v after %d requests", record, err, requests.Load())
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	providers, err := New(Options{Providers: []string{"googlebooks", "openlibrary"}, GoogleBooksKey: "k"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 2 || providers[0].Name() != "googlebooks" || providers[1].Name() != "openlibrary" {
+		t.Errorf("providers = %v", providers)
+	}
+
+	for _, opts := range []Options{
+		{},
+		{Providers: []string{"amazon"}},
+		{Providers: []string{"local"}},
+		{Providers: []string{"local"}, LocalDataset: "missing.json"},
+		{Providers: []string{"openlibrary"}, Timeout: -1},
+	} {
+		if _, err := New(opts, nil); err == nil {
+			t.Errorf("New(%+v) succeeded, want error", opts)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package lookup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// OpenLibrary looks books up on openlibrary.org, collecting the edition's
+// details, its authors' names and its work's subjects and description.
+type OpenLibrary struct {
+	// Defaults to https://openlibrary.org
+	BaseURL string
+	// Defaults to https://covers.openlibrary.org
+	CoversURL string
+	Client    *http.Client
+}
+
+type openLibraryEdition struct {
+	Title         string            `json:"title"`
+	Subtitle      string            `json:"subtitle"`
+	Authors       []openLibraryRef  `json:"authors"`
+	Works         []openLibraryRef  `json:"works"`
+	Subjects      []openLibraryName `json:"subjects"`
+	Publishers    []string          `json:"publishers"`
+	PublishDate   string            `json:"publish_date"`
+	NumberOfPages int               `json:"number_of_pages"`
+	Languages     []openLibraryRef  `json:"languages"`
+	Description   openLibraryText   `json:"description"`
+}
+
+type openLibraryWork struct {
+	Authors []struct {
+		Author openLibraryRef `json:"author"`
+		Key    string         `json:"key"`
+	} `json:"authors"`
+	Subjects    []openLibraryName `json:"subjects"`
+	Description openLibraryText   `json:"description"`
+}
+
+type openLibraryRef struct {
+	Key string `json:"key"`
+}
+
+type openLibraryAuthor struct {
+	Name string `json:"name"`
+}
+
+// A subject, sent either as a string or as {"name": ...}
+type openLibraryName string
+
+func (n *openLibraryName) UnmarshalJSON(data []byte) error {
+	var value struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &value); err == nil {
+		*n = openLibraryName(value.Name)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*n = openLibraryName(s)
+	return nil
+}
+
+// Text sent either as a string or as {"type": ..., "value": ...}
+type openLibraryText string
+
+func (t *openLibraryText) UnmarshalJSON(data []byte) error {
+	var value struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &value); err == nil {
+		*t = openLibraryText(value.Value)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*t = openLibraryText(s)
+	return nil
+}
+
+func (p *OpenLibrary) Name() string {
+	return "openlibrary"
+}
+
+func (p *OpenLibrary) baseURL() string {
+	if p.BaseURL != "" {
+		return strings.TrimSuffix(p.BaseURL, "/")
+	}
+	return "https://openlibrary.org"
+}
+
+func (p *OpenLibrary) coversURL() string {
+	if p.CoversURL != "" {
+		return strings.TrimSuffix(p.CoversURL, "/")
+	}
+	return "https://covers.openlibrary.org"
+}
+
+// Lookup fetches the edition, then its work and authors at the same time.
+// Only a failure to fetch the edition is an error; without the others the
+// record has fewer details and a warning for each.
+func (p *OpenLibrary) Lookup(ctx context.Context, isbn string) (*Record, error) {
+	var edition openLibraryEdition
+	if err := getJSON(ctx, p.Client, fmt.Sprintf("%s/isbn/%s.json", p.baseURL(), isbn), &edition); err != nil {
+		return nil, err
+	}
+
+	record := &Record{
+		ISBN:        isbn,
+		Title:       edition.Title,
+		Subtitle:    edition.Subtitle,
+		PublishDate: edition.PublishDate,
+		Pages:       edition.NumberOfPages,
+		Description: string(edition.Description),
+		Subjects:    subjects(edition.Subjects),
+		// Without default=false a missing cover is a blank placeholder
+		Covers: []string{fmt.Sprintf("%s/b/isbn/%s-L.jpg?default=false", p.coversURL(), isbn)},
+	}
+	if len(edition.Publishers) > 0 {
+		record.Publisher = edition.Publishers[0]
+	}
+	if len(edition.Languages) > 0 {
+		record.Language = strings.TrimPrefix(edition.Languages[0].Key, "/languages/")
+	}
+
+	authorKeys := make([]string, 0, len(edition.Authors))
+	for _, author := range edition.Authors {
+		authorKeys = append(authorKeys, author.Key)
+	}
+
+	var work openLibraryWork
+	var workErr error
+	var wg sync.WaitGroup
+	if len(edition.Works) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workErr = getJSON(ctx, p.Client, p.baseURL()+edition.Works[0].Key+".json", &work)
+		}()
+	}
+	authors, authorWarnings := p.authors(ctx, authorKeys)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if workErr != nil && !errors.Is(workErr, ErrNotFound) {
+		record.warn("openlibrary: failed to fetch the work: %v", workErr)
+	}
+	// Work subjects are more thorough than an edition's
+	if s := subjects(work.Subjects); len(s) > 0 {
+		record.Subjects = s
+	}
+	// Editions rarely have their own description
+	if record.Description == "" {
+		record.Description = string(work.Description)
+	}
+
+	// Editions without authors leave them to the work
+	if len(authorKeys) == 0 {
+		for _, author := range work.Authors {
+			key := author.Author.Key
+			if key == "" {
+				key = author.Key
+			}
+			if key != "" {
+				authorKeys = append(authorKeys, key)
+			}
+		}
+		authors, authorWarnings = p.authors(ctx, authorKeys)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	record.Authors = authors
+	record.Warnings = append(record.Warnings, authorWarnings...)
+	return record, nil
+}
+
+// Fetches the names of the authors with the keys at the same time, in the
+// keys' order, with a warning for each that fails
+func (p *OpenLibrary) authors(ctx context.Context, keys []string) ([]string, []string) {
+	names := make([]string, len(keys))
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var author openLibraryAuthor
+			errs[i] = getJSON(ctx, p.Client, p.baseURL()+key+".json", &author)
+			names[i] = author.Name
+		}()
+	}
+	wg.Wait()
+
+	var found, warnings []string
+	for i, name := range names {
+		if errs[i] != nil {
+			warnings = append(warnings, fmt.Sprintf("openlibrary: failed to fetch author %s: %v", keys[i], errs[i]))
+		} else if name != "" {
+			found = append(found, name)
+		}
+	}
+	return found, warnings
+}
+
+func subjects(names []openLibraryName) []string {
+	var subjects []string
+	for _, name := range names {
+		if name != "" {
+			subjects = append(subjects, string(name))
+		}
+	}
+	return subjects
+}
@@ -1,18 +1,34 @@
 package main
 
 import (
+	"NbirdHttp/admin"
+	"NbirdHttp/api"
+	"NbirdHttp/apierror"
 	"NbirdHttp/auth"
+	"NbirdHttp/backup"
 	"NbirdHttp/books"
+	"NbirdHttp/config"
+	"NbirdHttp/events"
+	"NbirdHttp/fileserver"
+	"NbirdHttp/lifecycle"
+	"NbirdHttp/lookup"
+	"NbirdHttp/middleware"
+	"NbirdHttp/proxy"
 	"NbirdHttp/punch"
 	qp "NbirdHttp/quick-pen"
+	"NbirdHttp/scheduler"
+	"NbirdHttp/webhooks"
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"os/signal"
 	"slices"
 	"syscall"
+	"time"
 )
 
 func helloController() {
@@ -42,7 +58,44 @@ func helloController() {
 	})
 }
 
+// Registers every service's API routes
+func apiControllers() {
+	auth.AuthController()
+	books.BooksController()
+	punch.PunchController()
+	qp.QuickPenController()
+	events.EventsController()
+	webhooks.WebhooksController()
+	scheduler.SchedulerController()
+	admin.AdminController()
+	api.DocsController()
+}
+
 func main() {
+	if err := runCommand(os.Args[1:]); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+}
+
+// Runs the server until it is told to shut down
+func runServe(args []string) error {
+	fs, configPath := newFlagSet("serve", "")
+	dev := fs.Bool("dev", false, "serve ./static from disk instead of the embedded copy")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long to wait for connections to drain and services to stop (overrides the config file)")
+	fs.Parse(args)
+
+	// Keep recent errors for the admin overview
+	log.SetOutput(io.MultiWriter(os.Stderr, admin.Errors))
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if *shutdownTimeout > 0 {
+		cfg.ShutdownTimeout = config.Duration(*shutdownTimeout)
+	}
+	proxies, _ := middleware.ParseTrustedProxies(cfg.TrustedProxies) // validated by config.Load
+
 	fmt.Print(
 		`
 
@@ -65,16 +118,40 @@ Serving at http://localhost
 
 `)
 
-	server := &http.Server{Addr: ":80"}
+	server := &http.Server{Addr: ":80", ConnState: admin.ConnState}
+	// Event streams never go idle, so end them for Shutdown to complete
+	server.RegisterOnShutdown(events.Close)
 
 	// Setup routes
-	http.Handle("GET /", http.FileServer(http.Dir("./static")))
+	site := fileserver.New(staticFS(*dev), *dev)
+	http.Handle("GET /", site)
+	http.HandleFunc("GET /punch", func(w http.ResponseWriter, r *http.Request) {
+		site.ServeFile(w, r, "punch.html")
+	})
 
+	auth.SetAdmins(cfg.Admins)
+	lookupClient := lookup.NewClient(cfg.BookLookup)
+	bookProviders, _ := lookup.New(cfg.BookLookup, lookupClient) // validated by config.Load
+	books.SetLookup(bookProviders, lookupClient)
 	helloController()
-	auth.AuthController()
-	books.BooksController()
-	punch.PunchController()
-	qp.QuickPenController()
+	apiControllers()
+	backup.ScheduleBackups(cfg.Backup)
+
+	// Services registered their jobs along with their routes
+	scheduler.Start()
+	lifecycle.OnShutdown("scheduler: stop jobs", scheduler.Stop)
+
+	handler := apierror.UnmatchedRoutes(http.DefaultServeMux)
+	handler = middleware.NewRateLimiter(middleware.APIRateGroups(cfg.RateLimits), proxies).Wrap(handler)
+	handler = middleware.CORS(handler, cfg.CORS)
+	handler = middleware.Compress(handler, middleware.DefaultCompressOptions)
+
+	// Other services answer for their hosts and paths untouched by the
+	// server's own middleware
+	router, _ := proxy.New(cfg.Proxies, proxies, handler) // validated by config.Load
+	router.Start()
+	lifecycle.OnShutdown("proxy: stop health checks", router.Stop)
+	server.Handler = router
 
 	// Create channel for shutdown signals
 	shutdown := make(chan struct{})
@@ -101,16 +178,43 @@ Serving at http://localhost
 	}()
 
 	// Wait for shutdown signal
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
 	select {
 	case <-shutdown:
-	case <-ctx.Done():
+	case sig := <-signals:
+		fmt.Printf("\nShutting down server on %s...\n", sig)
 	}
 
+	// A second signal abandons the graceful shutdown
+	go func() {
+		sig := <-signals
+		log.Printf("[WARN] received %s during shutdown, exiting immediately", sig)
+		os.Exit(1)
+	}()
+
+	shutdownServer(server, time.Duration(cfg.ShutdownTimeout))
+	return nil
+}
+
+// Gives open connections up to timeout to finish, then the services'
+// shutdown hooks a timeout of their own, so a connection that won't close
+// can't keep the services from stopping
+func shutdownServer(server *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	// Graceful shutdown
-	if err := server.Shutdown(context.Background()); err != nil {
+	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("HTTP server Shutdown error: %v", err)
+		server.Close()
+	}
+
+	// Stop services once no handlers are running
+	hooksCtx, cancelHooks := context.WithTimeout(context.Background(), timeout)
+	defer cancelHooks()
+	if err := lifecycle.Shutdown(hooksCtx); err != nil {
+		log.Printf("Service shutdown error: %v", err)
 	}
 }
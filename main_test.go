@@ -0,0 +1,126 @@
+package main
+
+import (
+	"NbirdHttp/api"
+	"NbirdHttp/lifecycle"
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIRoutesDocumented(t *testing.T) {
+	apiControllers()
+
+	routes := api.Routes()
+	if len(routes) == 0 {
+		t.Fatal("no API routes were registered")
+	}
+
+	spec := api.OpenAPI()
+	if _, err := json.Marshal(spec); err != nil {
+		t.Fatalf("OpenAPI document does not encode: %v", err)
+	}
+	paths := spec["paths"].(map[string]map[string]any)
+
+	for _, route := range routes {
+		name := route.Method + " " + route.Path()
+		if route.Summary == "" {
+			t.Errorf("%s has no summary", name)
+		}
+		if route.Tag == "" {
+			t.Errorf("%s has no tag", name)
+		}
+		if _, ok := paths[route.Path()][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s is missing from the OpenAPI document", name)
+		}
+	}
+}
+
+// API routes registered straight on a mux never reach the OpenAPI document
+func TestAPIRoutesUseRegistry(t *testing.T) {
+	fset := token.NewFileSet()
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == "static" || strings.HasPrefix(d.Name(), ".")) && path != "." {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "HandleFunc" && sel.Sel.Name != "Handle") {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			pattern, _ := strconv.Unquote(lit.Value)
+			if strings.Contains(pattern, "/api/") {
+				t.Errorf("%s: %q is registered without api.Handle", fset.Position(call.Pos()), pattern)
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A connection that never finishes must not use up the time the services
+// have to stop
+func TestShutdownServerRunsHooksAfterDrainTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+
+	var hookErr error
+	ran := false
+	lifecycle.OnShutdown("test: hook", func(ctx context.Context) error {
+		ran = true
+		hookErr = ctx.Err()
+		return nil
+	})
+
+	shutdownServer(server, 50*time.Millisecond)
+	if !ran {
+		t.Fatal("shutdown hook did not run")
+	}
+	if hookErr != nil {
+		t.Errorf("shutdown hook ran with an expired context: %v", hookErr)
+	}
+}
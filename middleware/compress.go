@@ -0,0 +1,203 @@
+// Package middleware contains the http.Handler wrappers that sit in front of
+// every service's routes.
+package middleware
+
+import (
+	"NbirdHttp/negotiate"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type CompressOptions struct {
+	// Responses smaller than this many bytes are sent uncompressed
+	MinSize int
+	// Content type prefixes eligible for compression
+	ContentTypes []string
+}
+
+var DefaultCompressOptions = CompressOptions{
+	MinSize: 1024,
+	ContentTypes: []string{
+		"text/",
+		"application/json",
+		"application/javascript",
+		"application/xml",
+		"application/manifest+json",
+		"image/svg+xml",
+	},
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		gz, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return gz
+	},
+}
+
+// Compress gzips responses whose content type is allowlisted and whose body
+// reaches the minimum size, when the client accepts it. Responses that
+// already carry a Content-Encoding, such as precompressed static assets, are
+// passed through untouched.
+//
+// Only gzip is offered; the standard library has no brotli or zstd encoder.
+func Compress(next http.Handler, opts CompressOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			opts:           opts,
+			accepted:       negotiate.AcceptsEncoding(r, "gzip"),
+			status:         http.StatusOK,
+		}
+		defer cw.finish()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Buffers the start of a response until it knows whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	opts     CompressOptions
+	accepted bool
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	gz          *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader || cw.decided {
+		return
+	}
+	if code >= 100 && code < 200 {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+	cw.wroteHeader = true
+
+	if !bodyAllowed(code) {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= cw.opts.MinSize {
+			if err := cw.decide(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends whatever has been buffered so far, which makes streamed
+// responses that flush early go out uncompressed unless they are already
+// past the size threshold.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide()
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Chooses whether to compress based on the headers and buffered body, then
+// writes out the header and the buffer
+func (cw *compressWriter) decide() error {
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	eligible := h.Get("Content-Encoding") == "" &&
+		cw.status != http.StatusPartialContent &&
+		cw.compressibleType(h.Get("Content-Type"))
+	if eligible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	big := len(cw.buf) >= cw.opts.MinSize
+	if length, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		big = length >= cw.opts.MinSize
+	}
+
+	cw.start(eligible && big && cw.accepted)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) start(compress bool) {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		// The encoded bytes differ from what a strong validator promised
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.gz = gzipWriters.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) finish() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			return // handler wrote nothing; let net/http send its default
+		}
+		cw.decide()
+	}
+	if cw.gz != nil {
+		cw.gz.Close()
+		gzipWriters.Put(cw.gz)
+		cw.gz = nil
+	}
+}
+
+func (cw *compressWriter) compressibleType(ctype string) bool {
+	ctype = strings.ToLower(ctype)
+	if strings.HasPrefix(ctype, "text/event-stream") {
+		return false // streams must reach the client as soon as they are flushed
+	}
+	for _, prefix := range cw.opts.ContentTypes {
+		if strings.HasPrefix(ctype, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"title":"The Hobbit"},`, 100)
+
+	tests := []struct {
+		name         string
+		ctype        string
+		encoding     string
+		body         string
+		acceptGzip   bool
+		wantEncoding string
+		wantVary     bool
+	}{
+		{"large json", "application/json", "", large, true, "gzip", true},
+		{"client refuses", "application/json", "", large, false, "", true},
+		{"below threshold", "application/json", "", `{"ok":true}`, true, "", true},
+		{"image", "image/jpeg", "", large, true, "", false},
+		{"already encoded", "text/css", "br", large, true, "br", false},
+		{"sniffed html", "", "", "<html>" + large, true, "gzip", true},
+		{"event stream", "text/event-stream", "", large, true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.ctype != "" {
+					w.Header().Set("Content-Type", tt.ctype)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				// Write in pieces to exercise buffering across calls
+				for chunk := range strings.SplitSeq(tt.body, ",") {
+					io.WriteString(w, chunk+",")
+				}
+			}), DefaultCompressOptions)
+
+			req := httptest.NewRequest("GET", "/api/books", nil)
+			if tt.acceptGzip {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := rr.Header().Get("Vary") == "Accept-Encoding"; got != tt.wantVary {
+				t.Errorf("Vary set = %v, want %v", got, tt.wantVary)
+			}
+
+			body := rr.Body.String()
+			if tt.wantEncoding == "gzip" {
+				gz, err := gzip.NewReader(rr.Body)
+				if err != nil {
+					t.Fatalf("invalid gzip body: %v", err)
+				}
+				data, _ := io.ReadAll(gz)
+				body = string(data)
+			}
+			want := tt.body + ","
+			if body != want {
+				t.Errorf("body mismatch: got %d bytes, want %d", len(body), len(want))
+			}
+		})
+	}
+}
+
+func TestCompressNoBody(t *testing.T) {
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), DefaultCompressOptions)
+
+	req := httptest.NewRequest("DELETE", "/api/books/1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusNoContent)
+	}
+	if rr.Header().Get("Content-Encoding") != "" {
+		t.Errorf("204 response was compressed")
+	}
+}
+
+func TestCompressStatusPreserved(t *testing.T) {
+	large := strings.Repeat("a", 4096)
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, large)
+	}), DefaultCompressOptions)
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusCreated)
+	}
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected gzip response")
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	opts := DefaultCORSOptions
+	opts.AllowedOrigins = []string{"https://quickpen.web.app"}
+	opts.AllowCredentials = true
+
+	reached := false
+	handler := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}), opts)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		preflight   bool
+		wantCode    int
+		wantOrigin  string
+		wantReached bool
+	}{
+		{"same origin", "GET", "/api/quick-pen/sprints", "", false, http.StatusOK, "", true},
+		{"allowed origin", "GET", "/api/quick-pen/sprints", "https://quickpen.web.app", false, http.StatusOK, "https://quickpen.web.app", true},
+		{"other origin", "GET", "/api/quick-pen/sprints", "https://evil.example", false, http.StatusOK, "", true},
+		{"preflight", "OPTIONS", "/api/quick-pen/sprint", "https://quickpen.web.app", true, http.StatusNoContent, "https://quickpen.web.app", false},
+		{"rejected preflight", "OPTIONS", "/api/quick-pen/sprint", "https://evil.example", true, http.StatusNoContent, "", false},
+		{"static files untouched", "GET", "/index.html", "https://quickpen.web.app", false, http.StatusOK, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantCode)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if reached != tt.wantReached {
+				t.Errorf("handler reached = %v, want %v", reached, tt.wantReached)
+			}
+			if tt.wantOrigin != "" && rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+				t.Errorf("missing Allow-Credentials")
+			}
+			if tt.preflight && tt.wantOrigin != "" && rr.Header().Get("Access-Control-Allow-Methods") == "" {
+				t.Errorf("preflight missing Allow-Methods")
+			}
+		})
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	opts := DefaultCORSOptions
+	opts.AllowedOrigins = []string{"*"}
+	handler := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), opts)
+
+	req := httptest.NewRequest("GET", "/api/books", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allow-Origin = %q, want %q", got, "*")
+	}
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"NbirdHttp/api"
+	"NbirdHttp/apierror"
+	"NbirdHttp/auth"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RatePolicy is a token bucket budget given to each client of a route group.
+type RatePolicy struct {
+	// Sustained requests allowed per minute
+	PerMinute float64 `json:"per_minute"`
+	// Requests that may be made at once before the rate applies
+	Burst int `json:"burst"`
+	// Also budget each signed in user, wherever their requests come from.
+	// Requests always spend their client IP's budget too.
+	ByUser bool `json:"by_user"`
+}
+
+// RateGroup applies a policy to the requests it matches.
+type RateGroup struct {
+	Name   string
+	Match  func(r *http.Request) bool
+	Policy RatePolicy
+}
+
+// How long a full bucket may sit unused before it is forgotten
+const bucketIdleTime = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type RateLimiter struct {
+	groups  []RateGroup
+	proxies TrustedProxies
+	now     func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter limits requests by the first group that matches them.
+// Requests matching no group are not limited.
+func NewRateLimiter(groups []RateGroup, proxies TrustedProxies) *RateLimiter {
+	return &RateLimiter{
+		groups:  groups,
+		proxies: proxies,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (rl *RateLimiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := rl.match(r)
+		if group == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keys := []string{group.Name + "|ip:" + rl.proxies.ClientIP(r)}
+		if group.Policy.ByUser {
+			// Only users who prove who they are, so naming another user
+			// can't spend their budget
+			if user := auth.VerifiedUser(r); user != "" {
+				keys = append(keys, group.Name+"|user:"+user)
+			}
+		}
+
+		if wait, ok := rl.take(keys, group.Policy); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			apierror.Write(w, r, apierror.TooManyRequests(fmt.Sprintf("Too many %s requests. Try again later.", group.Name)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rl *RateLimiter) match(r *http.Request) *RateGroup {
+	for i := range rl.groups {
+		if rl.groups[i].Match(r) {
+			return &rl.groups[i]
+		}
+	}
+	return nil
+}
+
+// Takes a token from the bucket for each key, or, if any is empty, takes
+// none and reports how long until they all have one.
+func (rl *RateLimiter) take(keys []string, policy RatePolicy) (time.Duration, bool) {
+	rate := policy.PerMinute / 60 // tokens per second
+	burst := float64(max(policy.Burst, 1))
+	now := rl.now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.sweep(now)
+
+	buckets := make([]*bucket, len(keys))
+	lowest := burst
+	for i, key := range keys {
+		b, ok := rl.buckets[key]
+		if !ok {
+			b = &bucket{tokens: burst, last: now}
+			rl.buckets[key] = b
+		}
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+		buckets[i] = b
+		lowest = min(lowest, b.tokens)
+	}
+
+	if lowest >= 1 {
+		for _, b := range buckets {
+			b.tokens--
+		}
+		return 0, true
+	}
+	if rate <= 0 {
+		return bucketIdleTime, false
+	}
+	return time.Duration((1 - lowest) / rate * float64(time.Second)), false
+}
+
+// Drops buckets that have been idle long enough to have refilled
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) > bucketIdleTime {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// APIRateGroups returns the route groups the API is limited by, in matching
+// order, using the named policies. Groups without a policy are not limited.
+// Every version of a route falls in the same group.
+func APIRateGroups(policies map[string]RatePolicy) []RateGroup {
+	candidates := []RateGroup{
+		{Name: "auth", Match: func(r *http.Request) bool {
+			return strings.HasPrefix(api.Unversioned(r.URL.Path), "/api/auth/")
+		}},
+		{Name: "isbn", Match: func(r *http.Request) bool {
+			return strings.HasPrefix(api.Unversioned(r.URL.Path), "/api/isbn/")
+		}},
+		{Name: "writes", Match: func(r *http.Request) bool {
+			return strings.HasPrefix(r.URL.Path, "/api/") && !isReadMethod(r.Method)
+		}},
+		{Name: "reads", Match: func(r *http.Request) bool {
+			return strings.HasPrefix(r.URL.Path, "/api/")
+		}},
+	}
+
+	var groups []RateGroup
+	for _, group := range candidates {
+		if policy, ok := policies[group.Name]; ok {
+			group.Policy = policy
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"NbirdHttp/auth"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	auth.AUTH_FILE = filepath.Join(t.TempDir(), ".auth")
+	defer func() { auth.AUTH_FILE = "./auth/.auth" }()
+	os.WriteFile(auth.AUTH_FILE, []byte(fmt.Sprintf("alice,%x\n", sha256.Sum256([]byte("secret")))), 0600)
+
+	policies := map[string]RatePolicy{
+		"isbn":  {PerMinute: 60, Burst: 2},
+		"reads": {PerMinute: 60, Burst: 5, ByUser: true},
+	}
+	rl := NewRateLimiter(APIRateGroups(policies), nil)
+	now := time.Unix(1700000000, 0)
+	rl.now = func() time.Time { return now }
+
+	handler := rl.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(path, ip, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := do("/api/isbn/123", "1.2.3.4", ""); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, rr.Code)
+		}
+	}
+
+	rr := do("/api/isbn/123", "1.2.3.4", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+
+	// Versioned paths share the budget of the unversioned alias
+	if rr := do("/api/v1/isbn/123", "1.2.3.4", ""); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("versioned path: status = %d, want 429", rr.Code)
+	}
+
+	// Other clients and groups have their own budgets
+	if rr := do("/api/isbn/123", "5.6.7.8", ""); rr.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want 200", rr.Code)
+	}
+	if rr := do("/api/books", "1.2.3.4", ""); rr.Code != http.StatusOK {
+		t.Errorf("other group: status = %d, want 200", rr.Code)
+	}
+
+	// Unlimited paths pass through
+	for i := 0; i < 10; i++ {
+		if rr := do("/index.html", "1.2.3.4", ""); rr.Code != http.StatusOK {
+			t.Fatalf("static: status = %d, want 200", rr.Code)
+		}
+	}
+
+	// Tokens refill over time
+	now = now.Add(time.Second)
+	if rr := do("/api/isbn/123", "1.2.3.4", ""); rr.Code != http.StatusOK {
+		t.Errorf("after refill: status = %d, want 200", rr.Code)
+	}
+
+	// User budgets follow the user across addresses
+	for i := 0; i < 5; i++ {
+		do("/api/books", fmt.Sprintf("9.9.9.%d", i), "alice")
+	}
+	if rr := do("/api/books", "8.8.8.8", "alice"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("user budget: status = %d, want 429", rr.Code)
+	}
+
+	// Naming a user without proving it doesn't spend their budget
+	now = now.Add(time.Minute)
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/api/books", nil)
+		req.RemoteAddr = "7.7.7.7:1234"
+		req.Header.Set("Authorization", "Bearer alice")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if rr := do("/api/books", "6.6.6.6", "alice"); rr.Code != http.StatusOK {
+		t.Errorf("after forged requests: status = %d, want 200", rr.Code)
+	}
+}
+
+// Naming a new user on each request must not give a client a fresh budget
+func TestRateLimiterRotatingUsers(t *testing.T) {
+	policies := map[string]RatePolicy{
+		"reads": {PerMinute: 60, Burst: 5, ByUser: true},
+	}
+	rl := NewRateLimiter(APIRateGroups(policies), nil)
+	now := time.Unix(1700000000, 0)
+	rl.now = func() time.Time { return now }
+	handler := rl.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/books?user=user%d", i), nil)
+		req.RemoteAddr = "1.2.3.4:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, rr.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/books?user=someone-new", nil)
+	req.RemoteAddr = "1.2.3.4:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("rotated user: status = %d, want 429", rr.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"direct", "203.0.113.5:5000", "", "203.0.113.5"},
+		{"untrusted proxy ignored", "203.0.113.5:5000", "1.1.1.1", "203.0.113.5"},
+		{"trusted proxy", "127.0.0.1:5000", "198.51.100.7", "198.51.100.7"},
+		{"proxy chain", "127.0.0.1:5000", "6.6.6.6, 198.51.100.7, 10.1.2.3", "198.51.100.7"},
+		{"spoofed hop", "127.0.0.1:5000", "garbage, 198.51.100.7", "198.51.100.7"},
+		{"no header", "127.0.0.1:5000", "", "127.0.0.1"},
+		{"ipv4 mapped", "[::ffff:203.0.113.5]:5000", "", "203.0.113.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := proxies.ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
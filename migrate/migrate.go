@@ -0,0 +1,171 @@
+// Package migrate applies versioned schema changes to SQLite databases,
+// recording each one in a schema_migrations table so it runs only once.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration is one change to a database's schema or data.
+type Migration struct {
+	// Versions order the migrations and must increase through the list.
+	// Never renumber or edit a migration once it has shipped; add another.
+	Version int
+	Name    string
+	// Statements to execute, if any
+	SQL string
+	// Run after SQL for changes that need Go, such as rewriting values
+	Func func(ctx context.Context, tx *sql.Tx) error
+}
+
+// Record is a migration that has been applied.
+type Record struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+const createTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)
+`
+
+func check(migrations []Migration) error {
+	for i, m := range migrations {
+		if m.Version <= 0 {
+			return fmt.Errorf("migration %q: version must be positive", m.Name)
+		}
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			return fmt.Errorf("migration %d (%s) is out of order", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// Applied returns the migrations recorded in db, oldest first. A database
+// that has never been migrated has none.
+func Applied(ctx context.Context, db *sql.DB) ([]Record, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')",
+	).Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []Record
+	for rows.Next() {
+		var record Record
+		var appliedAt int64
+		if err := rows.Scan(&record.Version, &record.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		record.AppliedAt = time.UnixMilli(appliedAt).UTC()
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// Pending returns the migrations not yet applied to db, in order.
+func Pending(ctx context.Context, db *sql.DB, migrations []Migration) ([]Migration, error) {
+	if err := check(migrations); err != nil {
+		return nil, err
+	}
+	records, err := Applied(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	applied := map[int]bool{}
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Apply runs the pending migrations in order, each in its own transaction,
+// and returns those it applied. It stops at the first that fails, leaving
+// the database as the previous one left it.
+//
+// With dryRun, the pending migrations all run in one transaction that is
+// rolled back, so they are checked against the real schema without
+// changing it.
+func Apply(ctx context.Context, db *sql.DB, migrations []Migration, dryRun bool) ([]Migration, error) {
+	pending, err := Pending(ctx, db, migrations)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	if dryRun {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		for _, m := range pending {
+			if err := run(ctx, tx, m); err != nil {
+				return nil, err
+			}
+		}
+		return pending, nil
+	}
+
+	var done []Migration
+	for _, m := range pending {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return done, err
+		}
+		if err := run(ctx, tx, m); err != nil {
+			tx.Rollback()
+			return done, err
+		}
+		if err := tx.Commit(); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Runs a migration and records it in tx
+func run(ctx context.Context, tx *sql.Tx, m Migration) error {
+	if _, err := tx.ExecContext(ctx, createTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	if m.SQL != "" {
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	if m.Func != nil {
+		if err := m.Func(ctx, tx); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("migration %d (%s): failed to record it: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
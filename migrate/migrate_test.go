@@ -0,0 +1,156 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", name).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+var migrations = []Migration{
+	{Version: 1, Name: "create notes", SQL: "CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)"},
+	{Version: 2, Name: "add title", SQL: "ALTER TABLE notes ADD COLUMN title TEXT"},
+	{Version: 3, Name: "seed notes", Func: func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO notes (title, body) VALUES ('hello', 'world')")
+		return err
+	}},
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+
+	applied, err := Apply(ctx, db, migrations[:2], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("applied %d migrations, want 2", len(applied))
+	}
+
+	pending, err := Pending(ctx, db, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Version != 3 {
+		t.Fatalf("pending = %v, want only version 3", pending)
+	}
+
+	applied, err = Apply(ctx, db, migrations, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Version != 3 {
+		t.Fatalf("applied = %v, want only version 3", applied)
+	}
+	var title string
+	if err := db.QueryRow("SELECT title FROM notes").Scan(&title); err != nil || title != "hello" {
+		t.Errorf("seeded title = %q, %v", title, err)
+	}
+
+	// Nothing is left to do
+	applied, err = Apply(ctx, db, migrations, false)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("second run applied %v, %v", applied, err)
+	}
+	records, err := Applied(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Name != "create notes" || records[2].AppliedAt.IsZero() {
+		t.Errorf("records = %v", records)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+
+	applied, err := Apply(ctx, db, migrations, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 3 {
+		t.Errorf("dry run would apply %d migrations, want 3", len(applied))
+	}
+	if tableExists(t, db, "notes") || tableExists(t, db, "schema_migrations") {
+		t.Error("dry run changed the database")
+	}
+
+	// Errors are still reported
+	broken := append(migrations[:1:1], Migration{Version: 2, Name: "broken", SQL: "ALTER TABLE missing ADD COLUMN x"})
+	if _, err := Apply(ctx, db, broken, true); err == nil {
+		t.Error("dry run of a broken migration succeeded")
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+
+	failed := errors.New("failed")
+	list := []Migration{
+		migrations[0],
+		{Version: 2, Name: "half done", SQL: "CREATE TABLE tags (name TEXT)", Func: func(ctx context.Context, tx *sql.Tx) error {
+			return failed
+		}},
+		{Version: 3, Name: "after", SQL: "CREATE TABLE after (x)"},
+	}
+	applied, err := Apply(ctx, db, list, false)
+	if !errors.Is(err, failed) {
+		t.Fatalf("err = %v, want the migration's error", err)
+	}
+	if len(applied) != 1 {
+		t.Errorf("applied %d migrations before the failure, want 1", len(applied))
+	}
+	if !tableExists(t, db, "notes") {
+		t.Error("migration before the failure was rolled back")
+	}
+	if tableExists(t, db, "tags") {
+		t.Error("failed migration was not rolled back")
+	}
+	records, _ := Applied(ctx, db)
+	if len(records) != 1 {
+		t.Errorf("%d migrations recorded, want 1", len(records))
+	}
+}
+
+func TestOrder(t *testing.T) {
+	db := openDB(t)
+	tests := [][]Migration{
+		{{Version: 2, Name: "b"}, {Version: 1, Name: "a"}},
+		{{Version: 1, Name: "a"}, {Version: 1, Name: "again"}},
+		{{Version: 0, Name: "zero"}},
+	}
+	for _, list := range tests {
+		if _, err := Apply(context.Background(), db, list, false); err == nil {
+			t.Errorf("%v: no error", list)
+		}
+	}
+	if tableExists(t, db, "schema_migrations") {
+		t.Error("invalid migrations were applied")
+	}
+}
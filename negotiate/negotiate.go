@@ -0,0 +1,86 @@
+// Package negotiate implements the parts of HTTP content negotiation the
+// server needs.
+package negotiate
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AcceptsEncoding reports whether the request's Accept-Encoding header allows
+// a response in the given content coding (e.g. "gzip").
+func AcceptsEncoding(r *http.Request, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, weight := parsePart(part)
+		switch {
+		case strings.EqualFold(name, coding):
+			return weight > 0
+		case name == "*":
+			wildcard = weight > 0
+		}
+	}
+	return wildcard
+}
+
+// Splits one element of an Accept-style header into its value and q weight
+func parsePart(part string) (string, float64) {
+	value, params, _ := strings.Cut(part, ";")
+	weight := 1.0
+	for _, param := range strings.Split(params, ";") {
+		if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				parsed = 0
+			}
+			weight = parsed
+		}
+	}
+	return strings.TrimSpace(value), weight
+}
+
+// Preferred returns the media type from offers that best matches the
+// request's Accept header. Ties, a missing header and a header accepting
+// none of the offers all resolve to the earliest offer.
+func Preferred(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" || len(offers) == 0 {
+		return first(offers)
+	}
+
+	best, bestWeight := first(offers), 0.0
+	for _, offer := range offers {
+		offerType, _, _ := strings.Cut(offer, "/")
+		weight, specificity := 0.0, -1
+		for _, part := range strings.Split(accept, ",") {
+			mediaRange, q := parsePart(part)
+			var s int
+			switch {
+			case strings.EqualFold(mediaRange, offer):
+				s = 2
+			case strings.EqualFold(mediaRange, offerType+"/*"):
+				s = 1
+			case mediaRange == "*/*":
+				s = 0
+			default:
+				continue
+			}
+			// The most specific matching range decides the weight
+			if s > specificity {
+				weight, specificity = q, s
+			}
+		}
+		if weight > bestWeight {
+			best, bestWeight = offer, weight
+		}
+	}
+	return best
+}
+
+func first(offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	return offers[0]
+}
@@ -0,0 +1,57 @@
+package negotiate
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		coding string
+		want   bool
+	}{
+		{"", "gzip", false},
+		{"gzip", "gzip", true},
+		{"GZIP", "gzip", true},
+		{"deflate, gzip;q=0.5", "gzip", true},
+		{"br;q=0, gzip", "br", false},
+		{"gzip;q=0.000", "gzip", false},
+		{"*", "br", true},
+		{"*;q=0, gzip", "br", false},
+		{"*, br;q=0", "br", false},
+		{"identity", "gzip", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tt.header)
+		if got := AcceptsEncoding(r, tt.coding); got != tt.want {
+			t.Errorf("AcceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
+
+func TestPreferred(t *testing.T) {
+	offers := []string{"application/json", "text/plain"}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"text/plain", "text/plain"},
+		{"text/*", "text/plain"},
+		{"text/plain, application/json", "application/json"},
+		{"application/json;q=0.5, text/plain", "text/plain"},
+		{"text/html, */*;q=0.1", "application/json"},
+		{"*/*, application/json;q=0", "text/plain"},
+		{"image/png", "application/json"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := Preferred(r, offers...); got != tt.want {
+			t.Errorf("Preferred(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
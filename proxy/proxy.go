@@ -0,0 +1,289 @@
+// Package proxy forwards requests for other hosts and paths to local
+// services, such as other apps running on the Pi, so they can be served
+// through this server on port 80.
+package proxy
+
+import (
+	"NbirdHttp/apierror"
+	"NbirdHttp/middleware"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Route forwards the requests matching its host and path to its upstreams.
+type Route struct {
+	// Host the route matches, e.g. "grafana.nbird.dev", or "*.nbird.dev"
+	// for any subdomain. Empty matches every host.
+	Host string `json:"host"`
+	// Path prefix the route matches, e.g. "/ha/". Empty matches every path.
+	Path string `json:"path"`
+	// Remove Path from the request before forwarding it, so "/ha/api"
+	// reaches the upstream as "/api"
+	StripPath bool `json:"strip_path"`
+	// Base URLs of the services, e.g. "http://127.0.0.1:3000". Requests are
+	// spread across the healthy ones in turn.
+	Upstreams []string `json:"upstreams"`
+	// Send the client's Host header rather than the upstream's
+	PreserveHost bool `json:"preserve_host"`
+	// Headers set on forwarded requests and on their responses. An empty
+	// value removes the header.
+	RequestHeaders  map[string]string `json:"request_headers"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+	// Path polled on each upstream to check it is up, e.g. "/health". Any
+	// response below 500 counts as up. Empty disables health checks.
+	HealthCheck string `json:"health_check"`
+	// Seconds between health checks (default 10)
+	HealthInterval int `json:"health_interval"`
+}
+
+const defaultHealthInterval = 10 * time.Second
+
+type upstream struct {
+	url     *url.URL
+	proxy   *httputil.ReverseProxy
+	healthy atomic.Bool
+}
+
+type route struct {
+	Route
+	upstreams []*upstream
+	next      atomic.Uint64
+}
+
+// Router sends requests matching a route to its upstreams and every other
+// request to the server's own handler.
+type Router struct {
+	routes []*route
+	next   http.Handler
+	client *http.Client
+
+	stop    chan struct{}
+	running sync.WaitGroup
+}
+
+// New returns a router that tries routes in order, passing requests that
+// match none to next. X-Forwarded-For headers from trusted proxies are
+// passed on; others are replaced.
+func New(routes []Route, proxies middleware.TrustedProxies, next http.Handler) (*Router, error) {
+	rt := &Router{
+		next:   next,
+		client: &http.Client{Timeout: 5 * time.Second},
+		stop:   make(chan struct{}),
+	}
+	for i, config := range routes {
+		if config.Host == "" && config.Path == "" {
+			return nil, fmt.Errorf("proxy %d: a host or path is required", i+1)
+		}
+		if config.Path != "" && !strings.HasPrefix(config.Path, "/") {
+			return nil, fmt.Errorf("proxy %d: path %q must start with /", i+1, config.Path)
+		}
+		if len(config.Upstreams) == 0 {
+			return nil, fmt.Errorf("proxy %d: at least one upstream is required", i+1)
+		}
+		config.Host = strings.ToLower(config.Host)
+
+		r := &route{Route: config}
+		for _, raw := range config.Upstreams {
+			target, err := url.Parse(raw)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				return nil, fmt.Errorf("proxy %d: upstream %q must be an http or https URL", i+1, raw)
+			}
+			up := &upstream{url: target}
+			up.healthy.Store(true)
+			up.proxy = r.reverseProxy(target, proxies)
+			r.upstreams = append(r.upstreams, up)
+		}
+		rt.routes = append(rt.routes, r)
+	}
+	return rt, nil
+}
+
+func (r *route) reverseProxy(target *url.URL, proxies middleware.TrustedProxies) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if r.StripPath && r.Path != "" {
+				pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.In.URL.Path, strings.TrimSuffix(r.Path, "/")), "/")
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(target)
+			if r.PreserveHost {
+				pr.Out.Host = pr.In.Host
+			}
+			if proxies.Trusted(pr.In) {
+				pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			}
+			pr.SetXForwarded()
+			setHeaders(pr.Out.Header, r.RequestHeaders)
+		},
+		ModifyResponse: func(res *http.Response) error {
+			setHeaders(res.Header, r.ResponseHeaders)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Printf("[WARN] proxy: %s %s%s to %s: %v\n", req.Method, req.Host, req.URL.Path, target, err)
+			apierror.Write(w, req, apierror.UpstreamFailed("The upstream service is unavailable"))
+		},
+	}
+}
+
+func setHeaders(header http.Header, values map[string]string) {
+	for name, value := range values {
+		if value == "" {
+			header.Del(name)
+		} else {
+			header.Set(name, value)
+		}
+	}
+}
+
+// Reports whether the route matches the request's host and path
+func (r *route) matches(req *http.Request) bool {
+	if r.Host != "" {
+		host := strings.ToLower(req.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if suffix, ok := strings.CutPrefix(r.Host, "*"); ok {
+			if !strings.HasSuffix(host, suffix) {
+				return false
+			}
+		} else if host != r.Host {
+			return false
+		}
+	}
+	if prefix := strings.TrimSuffix(r.Path, "/"); prefix != "" {
+		// "/ha/" matches "/ha" and everything under it, but not "/hat"
+		path := req.URL.Path
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the next healthy upstream in turn, or nil if none are up
+func (r *route) pick() *upstream {
+	start := r.next.Add(1)
+	for i := range r.upstreams {
+		up := r.upstreams[(int(start)+i)%len(r.upstreams)]
+		if up.healthy.Load() {
+			return up
+		}
+	}
+	return nil
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, r := range rt.routes {
+		if !r.matches(req) {
+			continue
+		}
+		up := r.pick()
+		if up == nil {
+			apierror.Write(w, req, apierror.UpstreamFailed("No upstream service is available"))
+			return
+		}
+		up.proxy.ServeHTTP(w, req)
+		return
+	}
+	rt.next.ServeHTTP(w, req)
+}
+
+// Start polls the upstreams of routes with health checks in the
+// background. Upstreams count as up until a check fails.
+func (rt *Router) Start() {
+	for _, r := range rt.routes {
+		if r.HealthCheck == "" {
+			continue
+		}
+		interval := defaultHealthInterval
+		if r.HealthInterval > 0 {
+			interval = time.Duration(r.HealthInterval) * time.Second
+		}
+		for _, up := range r.upstreams {
+			rt.running.Add(1)
+			go func() {
+				defer rt.running.Done()
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					rt.check(up, r.HealthCheck)
+					select {
+					case <-rt.stop:
+						return
+					case <-ticker.C:
+					}
+				}
+			}()
+		}
+	}
+}
+
+// Stop ends the health checks.
+func (rt *Router) Stop(ctx context.Context) error {
+	close(rt.stop)
+	done := make(chan struct{})
+	go func() {
+		rt.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Checks an upstream and logs when it goes down or comes back up
+func (rt *Router) check(up *upstream, path string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-rt.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	healthy := false
+	req, err := http.NewRequestWithContext(ctx, "GET", up.url.JoinPath(path).String(), nil)
+	if err == nil {
+		var res *http.Response
+		res, err = rt.client.Do(req)
+		if err == nil {
+			res.Body.Close()
+			healthy = res.StatusCode < 500
+			if !healthy {
+				err = fmt.Errorf("status %d", res.StatusCode)
+			}
+		}
+	}
+
+	select {
+	case <-rt.stop:
+		return // cancelled rather than failed
+	default:
+	}
+	if was := up.healthy.Swap(healthy); was != healthy {
+		if healthy {
+			log.Printf("[INFO] proxy: upstream %s is back up\n", up.url)
+		} else {
+			log.Printf("[WARN] proxy: upstream %s is down: %v\n", up.url, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"NbirdHttp/middleware"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Returns an upstream that describes the request it received
+func echoServer(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "upstream")
+		w.Header().Set("X-Upstream", name)
+		fmt.Fprintf(w, "%s %s host=%s xff=%s secret=%q",
+			name, r.URL.Path, r.Host, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Secret"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, h http.Handler, host, path string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", path, nil)
+	r.Host = host
+	r.RemoteAddr = "192.0.2.10:4000"
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRouting(t *testing.T) {
+	grafana := echoServer(t, "grafana")
+	ha := echoServer(t, "ha")
+	site := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "site")
+	})
+
+	rt, err := New([]Route{
+		{Host: "grafana.nbird.dev", Upstreams: []string{grafana.URL}},
+		{Host: "*.apps.nbird.dev", Upstreams: []string{grafana.URL}, PreserveHost: true},
+		{
+			Path:            "/ha/",
+			StripPath:       true,
+			Upstreams:       []string{ha.URL},
+			RequestHeaders:  map[string]string{"X-Secret": ""},
+			ResponseHeaders: map[string]string{"Server": "", "X-Proxied": "yes"},
+		},
+	}, nil, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host, path string
+		want       string
+	}{
+		{"grafana.nbird.dev", "/d/home", "grafana /d/home host=" + strings.TrimPrefix(grafana.URL, "http://")},
+		{"GRAFANA.nbird.dev:80", "/", "grafana / "},
+		{"one.apps.nbird.dev", "/x", "grafana /x host=one.apps.nbird.dev"},
+		{"nbird.dev", "/ha/api/states", "ha /api/states "},
+		{"nbird.dev", "/ha", "ha / "},
+		{"nbird.dev", "/hat", "site"},
+		{"nbird.dev", "/books", "site"},
+	}
+	for _, test := range tests {
+		w := get(t, rt, test.host, test.path, http.Header{"X-Secret": {"hunter2"}})
+		if body := w.Body.String(); !strings.HasPrefix(body, test.want) {
+			t.Errorf("%s%s: body = %q, want prefix %q", test.host, test.path, body, test.want)
+		}
+	}
+
+	w := get(t, rt, "nbird.dev", "/ha/", http.Header{"X-Secret": {"hunter2"}})
+	body := w.Body.String()
+	if !strings.Contains(body, `secret=""`) || !strings.Contains(body, "xff=192.0.2.10") {
+		t.Errorf("request headers not rewritten: %q", body)
+	}
+	if w.Header().Get("Server") != "" || w.Header().Get("X-Proxied") != "yes" {
+		t.Errorf("response headers not rewritten: %v", w.Header())
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	upstream := echoServer(t, "app")
+	trusted, _ := middleware.ParseTrustedProxies([]string{"192.0.2.0/24"})
+	rt, err := New([]Route{{Path: "/app/", Upstreams: []string{upstream.URL}}}, trusted, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := get(t, rt, "nbird.dev", "/app/", http.Header{"X-Forwarded-For": {"198.51.100.7"}})
+	if body := w.Body.String(); !strings.Contains(body, "xff=198.51.100.7, 192.0.2.10") {
+		t.Errorf("trusted proxy's X-Forwarded-For not kept: %q", body)
+	}
+
+	rt, _ = New([]Route{{Path: "/app/", Upstreams: []string{upstream.URL}}}, nil, nil)
+	w = get(t, rt, "nbird.dev", "/app/", http.Header{"X-Forwarded-For": {"198.51.100.7"}})
+	if body := w.Body.String(); !strings.Contains(body, "xff=192.0.2.10 ") {
+		t.Errorf("untrusted X-Forwarded-For passed on: %q", body)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	healthy := echoServer(t, "healthy")
+	var down atomic.Bool
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "flaky")
+	}))
+	defer flaky.Close()
+
+	rt, err := New([]Route{{
+		Path:           "/app/",
+		Upstreams:      []string{flaky.URL, healthy.URL},
+		HealthCheck:    "/health",
+		HealthInterval: 1,
+	}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Store(true)
+	rt.Start()
+	defer rt.Stop(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rt.routes[0].upstreams[0].healthy.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("failing upstream was never marked down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for range 4 {
+		if body := get(t, rt, "nbird.dev", "/app/", nil).Body.String(); !strings.HasPrefix(body, "healthy") {
+			t.Errorf("request went to the unhealthy upstream: %q", body)
+		}
+	}
+
+	rt.routes[0].upstreams[1].healthy.Store(false)
+	if w := get(t, rt, "nbird.dev", "/app/", nil); w.Code != http.StatusBadGateway {
+		t.Errorf("with no healthy upstreams: status = %d, want 502", w.Code)
+	}
+}
+
+func TestWebSocketUpgrade(t *testing.T) {
+	// Upstream that switches protocols and echoes one line back
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+		line, _ := buf.ReadString('\n')
+		buf.WriteString("echo: " + line)
+		buf.Flush()
+	}))
+	defer upstream.Close()
+
+	rt, err := New([]Route{{Path: "/ws/", Upstreams: []string{upstream.URL}}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(rt)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "GET /ws/ HTTP/1.1\r\nHost: nbird.dev\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", res.StatusCode)
+	}
+	fmt.Fprint(conn, "hello\n")
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if line != "echo: hello\n" {
+		t.Errorf("got %q through the upgraded connection", line)
+	}
+}
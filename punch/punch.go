@@ -1,8 +1,11 @@
 package punch
 
 import (
+	"NbirdHttp/admin"
+	"NbirdHttp/api"
+	"NbirdHttp/apierror"
+	"NbirdHttp/events"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -28,20 +31,97 @@ type ClockData struct {
 	WorkHours  float64
 }
 
+// PunchEvent is published on the punch.in, punch.break.start,
+// punch.break.end and punch.out event topics.
+type PunchEvent struct {
+	At time.Time `json:"at"`
+	// Hours worked, set on punch.out
+	Hours float64 `json:"hours,omitempty"`
+}
+
 var g_DEFAULT_WORK_HOURS = 8.0
 
 // Visible for testing
 var CLOCK_FILE = "./punch/.punch_clock"
 
+var userParam = api.Param{Name: "user", In: "query", Description: "Username whose clock to use", Required: true}
+
 func PunchController() {
-	http.HandleFunc("GET /punch", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/punch.html")
+	api.Handle(api.Route{
+		Method:       "POST",
+		Pattern:      "/api/punch/in",
+		Tag:          "punch",
+		Summary:      "Punch in for the day",
+		Params:       []api.Param{userParam},
+		Status:       http.StatusCreated,
+		Response:     "",
+		ResponseType: "text/plain",
+		Handler:      punchInHandler,
+	})
+	api.Handle(api.Route{
+		Method:       "POST",
+		Pattern:      "/api/punch/break/start",
+		Tag:          "punch",
+		Summary:      "Start a break",
+		Params:       []api.Param{userParam},
+		Status:       http.StatusCreated,
+		Response:     "",
+		ResponseType: "text/plain",
+		Handler:      breakStartHandler,
+	})
+	api.Handle(api.Route{
+		Method:       "POST",
+		Pattern:      "/api/punch/break/end",
+		Tag:          "punch",
+		Summary:      "End the current break",
+		Params:       []api.Param{userParam},
+		Status:       http.StatusCreated,
+		Response:     "",
+		ResponseType: "text/plain",
+		Handler:      breakEndHandler,
+	})
+	api.Handle(api.Route{
+		Method:       "POST",
+		Pattern:      "/api/punch/out",
+		Tag:          "punch",
+		Summary:      "Punch out and record the hours worked",
+		Params:       []api.Param{userParam},
+		Status:       http.StatusCreated,
+		Response:     "",
+		ResponseType: "text/plain",
+		Handler:      punchOutHandler,
+	})
+	api.Handle(api.Route{
+		Method:  "GET",
+		Pattern: "/api/punch/status",
+		Tag:     "punch",
+		Summary: "Current punch state and time worked",
+		Description: "Responds 204 when the user has never punched in. While punched in, " +
+			"timeLeft, totalTime and workHours are included.",
+		Params: []api.Param{
+			userParam,
+			{Name: "hours", In: "query", Description: "Length of the work day in hours (default 8)"},
+		},
+		Response: map[string]string{},
+		Handler:  statusHandler,
 	})
-	http.HandleFunc("POST /api/punch/in", punchInHandler)
-	http.HandleFunc("POST /api/punch/break/start", breakStartHandler)
-	http.HandleFunc("POST /api/punch/break/end", breakEndHandler)
-	http.HandleFunc("POST /api/punch/out", punchOutHandler)
-	http.HandleFunc("GET /api/punch/status", statusHandler)
+	api.Handle(api.Route{
+		Version: 2,
+		Method:  "GET",
+		Pattern: "/api/punch/status",
+		Tag:     "punch",
+		Summary: "Current punch state and hours worked",
+		Description: "Responds 204 when the user has never punched in. Times are reported " +
+			"in hours, rounded to two decimal places.",
+		Params: []api.Param{
+			userParam,
+			{Name: "hours", In: "query", Description: "Length of the work day in hours (default 8)"},
+		},
+		Response: StatusV2{},
+		Handler:  statusV2Handler,
+	})
+
+	admin.RegisterUserStorage("punch", StorageUsage)
 }
 
 func getUserClockFile(user string) string {
@@ -114,12 +194,12 @@ func getUserFromParams(r *http.Request) (string, error) {
 	params, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		return "", err
+		return "", apierror.BadRequest(err.Error())
 	}
 
 	user := params.Get("user")
 	if user == "" {
-		errMissingParamUser := errors.New("parameter `user` is required")
+		errMissingParamUser := apierror.Validation("parameter `user` is required").WithDetails(map[string]string{"parameter": "user"})
 		return "", errMissingParamUser
 	}
 
@@ -130,20 +210,19 @@ func punchInHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := getUserFromParams(r)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	cd, err := loadEntries(user)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	if cd.FocusEntry != nil && cd.FocusEntry.POut == "" {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintln(w, "Already punched in.")
+		apierror.Write(w, r, apierror.Conflict("Already punched in."))
 		return
 	}
 
@@ -157,10 +236,12 @@ func punchInHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := writeToClockFileln(user, fmt.Sprintf("\n%s\n  P_IN::%s", entry.Date, entry.PIn)); err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
+	events.PublishVerified(r, "punch.in", user, PunchEvent{At: now})
+
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, "PUNCH IN AT %s\n", now.Format("03:04pm, Mon, Jan 2, 2006"))
 }
@@ -169,26 +250,24 @@ func breakStartHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := getUserFromParams(r)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	cd, err := loadEntries(user)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	if cd.FocusEntry == nil || cd.FocusEntry.POut != "" {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintln(w, "Not punched in.")
+		apierror.Write(w, r, apierror.Conflict("Not punched in."))
 		return
 	}
 
 	if cd.FocusEntry.Breaks != nil && cd.FocusEntry.Breaks[len(cd.FocusEntry.Breaks)-1][1] == "" {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintln(w, "Already on break.")
+		apierror.Write(w, r, apierror.Conflict("Already on break."))
 		return
 	}
 
@@ -197,10 +276,12 @@ func breakStartHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := writeToClockFileln(user, fmt.Sprintf("  B_IN::%s", cd.FocusEntry.Breaks[len(cd.FocusEntry.Breaks)-1][0])); err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
+	events.PublishVerified(r, "punch.break.start", user, PunchEvent{At: now})
+
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, "BREAK STARTED AT %s\n", now.Format("03:04pm, Mon, Jan 2, 2006"))
 }
@@ -209,26 +290,24 @@ func breakEndHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := getUserFromParams(r)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	cd, err := loadEntries(user)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	if cd.FocusEntry == nil || cd.FocusEntry.POut != "" {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintln(w, "Not punched in.")
+		apierror.Write(w, r, apierror.Conflict("Not punched in."))
 		return
 	}
 
 	if cd.FocusEntry.Breaks == nil || cd.FocusEntry.Breaks[len(cd.FocusEntry.Breaks)-1][1] != "" {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintln(w, "Not on break.")
+		apierror.Write(w, r, apierror.Conflict("Not on break."))
 		return
 	}
 
@@ -237,10 +316,12 @@ func breakEndHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := writeToClockFileln(user, fmt.Sprintf("  B_OUT::%s", cd.FocusEntry.Breaks[len(cd.FocusEntry.Breaks)-1][1])); err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
+	events.PublishVerified(r, "punch.break.end", user, PunchEvent{At: now})
+
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, "BREAK ENDED AT %s\n", now.Format("3:04pm, Mon, Jan 2, 2006"))
 }
@@ -249,27 +330,25 @@ func punchOutHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := getUserFromParams(r)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	cd, err := loadEntries(user)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	if cd.FocusEntry == nil || cd.FocusEntry.POut != "" {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintln(w, "Not punched in.")
+		apierror.Write(w, r, apierror.Conflict("Not punched in."))
 		return
 	}
 
 	// Taken at least one break      && Last break has no end time
 	if len(cd.FocusEntry.Breaks) > 0 && cd.FocusEntry.Breaks[len(cd.FocusEntry.Breaks)-1][1] == "" {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintln(w, "Still on break.")
+		apierror.Write(w, r, apierror.Conflict("Still on break."))
 		return
 	}
 
@@ -278,7 +357,7 @@ func punchOutHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := writeToClockFileln(user, fmt.Sprintf("  P_OUT::%s", cd.FocusEntry.POut)); err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
@@ -295,96 +374,168 @@ func punchOutHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := writeToClockFileln(user, fmt.Sprintf("  TIME::%.2f", hours/60)); err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
+	events.PublishVerified(r, "punch.out", user, PunchEvent{At: now, Hours: math.Round(hours/60*100) / 100})
+
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, "PUNCH OUT AT %s\n", now.Format("3:04pm, Mon, Jan 2, 2006"))
 }
 
-func statusHandler(w http.ResponseWriter, r *http.Request) {
+// State of the focused entry, shared by every version of the status route
+type clockStatus struct {
+	PunchedIn bool
+	OnBreak   bool
+	WorkHours float64
+	Worked    time.Duration
+	Left      time.Duration // only while punched in
+	PunchIn   time.Time
+	Since     time.Duration
+	// Hours recorded at punch out, e.g. "7.50"
+	RecordedTime string
+}
+
+// Reads the user's clock and works out their current status. Writes the
+// response and returns nil when there is nothing more to report.
+func loadStatus(w http.ResponseWriter, r *http.Request) *clockStatus {
 	user, err := getUserFromParams(r)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		apierror.Write(w, r, err)
+		return nil
 	}
 
 	cd, err := loadEntries(user)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		apierror.Write(w, r, err)
+		return nil
 	}
 
 	if cd.FocusEntry == nil {
 		w.WriteHeader(http.StatusNoContent)
-		fmt.Fprintln(w, "No punch data available.")
+		return nil
 	}
 
 	params, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
 		log.Printf("[ERROR] %v\n", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		apierror.Write(w, r, apierror.BadRequest(err.Error()))
+		return nil
 	}
 
-	status := map[string]string{}
-	var hoursToWork float64
+	status := &clockStatus{WorkHours: cd.WorkHours}
 	if prm_hours := params.Get("hours"); prm_hours != "" {
-		hoursToWork, _ = strconv.ParseFloat(prm_hours, 64)
-	} else {
-		hoursToWork = cd.WorkHours
+		status.WorkHours, _ = strconv.ParseFloat(prm_hours, 64)
 	}
 
-	punchState := "punched out"
-	if cd.FocusEntry != nil && cd.FocusEntry.POut == "" { // Still working
-		punchState = "punched in"
+	if cd.FocusEntry.POut == "" { // Still working
+		status.PunchedIn = true
 
 		// Calculate total time worked so far
 		loc, err := time.LoadLocation("Local")
 		if err != nil {
 			log.Printf("[ERROR] %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			apierror.Write(w, r, err)
+			return nil
 		}
 		pIn, _ := time.ParseInLocation("Mon, Jan 2, 2006 15:04", fmt.Sprintf("%s %s", cd.FocusEntry.Date, cd.FocusEntry.PIn), loc)
-		timeSince := time.Since(pIn)
-		var totalWorked time.Duration = timeSince
+		status.PunchIn = pIn
+		status.Since = time.Since(pIn)
+		status.Worked = status.Since
 		for _, b := range cd.FocusEntry.Breaks {
 			bIn, _ := time.ParseInLocation("Mon, Jan 2, 2006 15:04", fmt.Sprintf("%s %s", cd.FocusEntry.Date, b[0]), loc)
 			bOut, _ := time.ParseInLocation("Mon, Jan 2, 2006 15:04", fmt.Sprintf("%s %s", cd.FocusEntry.Date, b[1]), loc)
-			totalWorked -= bOut.Sub(bIn)
+			status.Worked -= bOut.Sub(bIn)
 		}
 
 		// Calculate remaining time until punch out
-		workDuration := time.Duration(hoursToWork) * time.Hour
-		timeUntilPOut := workDuration - totalWorked
+		workDuration := time.Duration(status.WorkHours) * time.Hour
+		status.Left = workDuration - status.Worked
+	} else { // Finished working
+		status.RecordedTime = cd.FocusEntry.Time
+	}
+	if len(cd.FocusEntry.Breaks) > 0 && cd.FocusEntry.Breaks[len(cd.FocusEntry.Breaks)-1][1] == "" {
+		status.OnBreak = true
+	}
+	return status
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	cs := loadStatus(w, r)
+	if cs == nil {
+		return
+	}
+
+	status := map[string]string{}
+	punchState := "punched out"
+	if cs.PunchedIn {
+		punchState = "punched in"
 
 		// Format the hoursLeft and minutes
-		hoursLeft := int(timeUntilPOut.Hours())
-		minutesLeft := int(math.Round(timeUntilPOut.Minutes())) % 60
+		hoursLeft := int(cs.Left.Hours())
+		minutesLeft := int(math.Round(cs.Left.Minutes())) % 60
 
-		hoursWorked := int(totalWorked.Hours())
-		minutesWorked := int(math.Round(totalWorked.Minutes())) % 60
+		hoursWorked := int(cs.Worked.Hours())
+		minutesWorked := int(math.Round(cs.Worked.Minutes())) % 60
 
 		status["timeLeft"] = fmt.Sprintf("%dH:%dM", hoursLeft, minutesLeft)
 		status["totalTime"] = fmt.Sprintf("%dH:%dM", hoursWorked, minutesWorked)
-		status["workHours"] = fmt.Sprintf("%.2f", hoursToWork)
+		status["workHours"] = fmt.Sprintf("%.2f", cs.WorkHours)
 		status["debugTimeNow"] = time.Now().Format("Mon, Jan 2, 2006 15:04")
-		status["debugTimePIn"] = pIn.Format("Mon, Jan 2, 2006 15:04")
-		status["debugTimeSince"] = timeSince.String()
-	} else if cd.FocusEntry != nil && cd.FocusEntry.Time != "" { // Finished working
-		status["totalTime"] = fmt.Sprintf("%sH", cd.FocusEntry.Time)
+		status["debugTimePIn"] = cs.PunchIn.Format("Mon, Jan 2, 2006 15:04")
+		status["debugTimeSince"] = cs.Since.String()
+	} else if cs.RecordedTime != "" {
+		status["totalTime"] = fmt.Sprintf("%sH", cs.RecordedTime)
 	}
 	breakState := "off break"
-	if cd.FocusEntry != nil && len(cd.FocusEntry.Breaks) > 0 && cd.FocusEntry.Breaks[len(cd.FocusEntry.Breaks)-1][1] == "" {
+	if cs.OnBreak {
 		breakState = "on break"
 	}
 	status["inOut"] = fmt.Sprintf("%s, %s", punchState, breakState)
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+// StatusV2 is the v2 status response, reporting time as numbers of hours
+// rather than formatted strings.
+type StatusV2 struct {
+	PunchedIn   bool    `json:"punched_in"`
+	OnBreak     bool    `json:"on_break"`
+	WorkHours   float64 `json:"work_hours"`
+	HoursWorked float64 `json:"hours_worked"`
+	// Null once punched out
+	HoursLeft *float64 `json:"hours_left"`
+}
+
+func statusV2Handler(w http.ResponseWriter, r *http.Request) {
+	cs := loadStatus(w, r)
+	if cs == nil {
+		return
+	}
+
+	status := StatusV2{
+		PunchedIn: cs.PunchedIn,
+		OnBreak:   cs.OnBreak,
+		WorkHours: cs.WorkHours,
+	}
+	if cs.PunchedIn {
+		status.HoursWorked = roundHours(cs.Worked)
+		left := roundHours(cs.Left)
+		status.HoursLeft = &left
+	} else {
+		status.HoursWorked, _ = strconv.ParseFloat(cs.RecordedTime, 64)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
+
+// Converts d to hours rounded to two decimal places
+func roundHours(d time.Duration) float64 {
+	return math.Round(d.Hours()*100) / 100
+}
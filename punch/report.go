@@ -0,0 +1,55 @@
+package punch
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ReportDay is one day on a user's clock.
+type ReportDay struct {
+	Date   time.Time
+	PIn    string
+	POut   string
+	Breaks int
+	// Hours recorded at punch out; 0 while still punched in
+	Hours float64
+}
+
+// Report is a user's clock for one month.
+type Report struct {
+	User  string
+	Month time.Time
+	Days  []ReportDay
+	Hours float64
+}
+
+// MonthReport returns the days user punched in during the month containing
+// month, oldest first.
+func MonthReport(user string, month time.Time) (*Report, error) {
+	cd, err := loadEntries(user)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{User: user, Month: time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.Local)}
+	for _, entry := range cd.Entries {
+		date, err := time.ParseInLocation("Mon, Jan 2, 2006", entry.Date, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("bad date %q in clock file: %w", entry.Date, err)
+		}
+		if date.Year() != month.Year() || date.Month() != month.Month() {
+			continue
+		}
+
+		day := ReportDay{Date: date, PIn: entry.PIn, POut: entry.POut, Breaks: len(entry.Breaks)}
+		if entry.Time != "" {
+			if day.Hours, err = strconv.ParseFloat(entry.Time, 64); err != nil {
+				return nil, fmt.Errorf("bad time %q in clock file: %w", entry.Time, err)
+			}
+		}
+		report.Days = append(report.Days, day)
+		report.Hours += day.Hours
+	}
+	return report, nil
+}
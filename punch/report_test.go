@@ -0,0 +1,50 @@
+package punch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonthReport(t *testing.T) {
+	user := "reporter"
+	setupTestClockFile(`
+Mon, Aug 31, 2026
+  P_IN::09:00
+  P_OUT::17:00
+  TIME::8.00
+
+Tue, Sep 01, 2026
+  P_IN::08:00
+  B_IN::12:00
+  B_OUT::12:30
+  P_OUT::16:30
+  TIME::8.00
+
+Wed, Sep 02, 2026
+  P_IN::08:15
+  P_OUT::12:45
+  TIME::4.50
+
+Thu, Sep 03, 2026
+  P_IN::08:00
+`, user)
+	defer teardownTestClockFile(user)
+
+	report, err := MonthReport(user, time.Date(2026, time.September, 15, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Days) != 3 {
+		t.Fatalf("got %d days, want 3: %+v", len(report.Days), report.Days)
+	}
+	if report.Hours != 12.5 {
+		t.Errorf("hours = %v, want 12.5", report.Hours)
+	}
+	first := report.Days[0]
+	if first.Date.Day() != 1 || first.PIn != "08:00" || first.POut != "16:30" || first.Breaks != 1 {
+		t.Errorf("first day = %+v", first)
+	}
+	if last := report.Days[2]; last.POut != "" || last.Hours != 0 {
+		t.Errorf("day still punched in = %+v", last)
+	}
+}
@@ -0,0 +1,27 @@
+package punch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StorageUsage returns the size in bytes of each user's clock file.
+func StorageUsage() (map[string]int64, error) {
+	files, err := filepath.Glob(CLOCK_FILE + "_*")
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(CLOCK_FILE) + "_"
+	usage := map[string]int64{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			usage[strings.TrimPrefix(filepath.Base(file), prefix)] = info.Size()
+		}
+	}
+	return usage, nil
+}
@@ -1,7 +1,12 @@
 package quickpen
 
 import (
+	"NbirdHttp/admin"
+	"NbirdHttp/api"
+	"NbirdHttp/apierror"
+	"NbirdHttp/events"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,6 +19,8 @@ import (
 
 type HighScoreCategory string
 
+var errSprintNotFound = errors.New("sprint not found")
+
 const SPRINTS_DIR = "./quick-pen/.sprints.d"
 
 const (
@@ -72,15 +79,80 @@ func ensureUserDir(user string) error {
 	return nil
 }
 
+var timezoneParam = api.Param{Name: "X-Timezone", In: "header", Description: "IANA time zone used to group sprints by day (default UTC)"}
+
 func QuickPenController() {
 	// List all supported endpoints
-	http.HandleFunc("GET /api/quick-pen/sprints", handleGetSprints)
-	http.HandleFunc("POST /api/quick-pen/sprint", handleCreateSprint)
-	http.HandleFunc("GET /api/quick-pen/sprint/{id}/content", handleGetSprintContent)
-	http.HandleFunc("PATCH /api/quick-pen/sprint/{id}/tags", handleUpdateSprintTags)
-	http.HandleFunc("GET /api/quick-pen/best-sprint/{category}", handleGetBestSprint)
-	http.HandleFunc("GET /api/quick-pen/best-streak", handleGetBestStreak)
-	http.HandleFunc("GET /api/quick-pen/progress/{range}", handleGetProgress)
+	api.Handle(api.Route{
+		Method:     "GET",
+		Pattern:    "/api/quick-pen/sprints",
+		Tag:        "quick-pen",
+		Summary:    "List the user's sprints, oldest first",
+		BearerAuth: true,
+		Response:   []Sprint{},
+		Handler:    handleGetSprints,
+	})
+	api.Handle(api.Route{
+		Method:     "POST",
+		Pattern:    "/api/quick-pen/sprint",
+		Tag:        "quick-pen",
+		Summary:    "Save a finished sprint and its text",
+		BearerAuth: true,
+		Request:    Sprint{},
+		Status:     http.StatusCreated,
+		Handler:    handleCreateSprint,
+	})
+	api.Handle(api.Route{
+		Method:       "GET",
+		Pattern:      "/api/quick-pen/sprint/{id}/content",
+		Tag:          "quick-pen",
+		Summary:      "Text written during a sprint",
+		BearerAuth:   true,
+		Response:     "",
+		ResponseType: "text/plain",
+		Handler:      handleGetSprintContent,
+	})
+	api.Handle(api.Route{
+		Method:     "PATCH",
+		Pattern:    "/api/quick-pen/sprint/{id}/tags",
+		Tag:        "quick-pen",
+		Summary:    "Replace a sprint's tags",
+		BearerAuth: true,
+		Request:    []string{},
+		Handler:    handleUpdateSprintTags,
+	})
+	api.Handle(api.Route{
+		Method:      "GET",
+		Pattern:     "/api/quick-pen/best-sprint/{category}",
+		Tag:         "quick-pen",
+		Summary:     "Highest scoring sprint by wpm, words or duration",
+		Description: "Responds with null when the user has no sprints.",
+		BearerAuth:  true,
+		Response:    &Sprint{},
+		Handler:     handleGetBestSprint,
+	})
+	api.Handle(api.Route{
+		Method:     "GET",
+		Pattern:    "/api/quick-pen/best-streak",
+		Tag:        "quick-pen",
+		Summary:    "Longest run of consecutive days with a sprint",
+		BearerAuth: true,
+		Params:     []api.Param{timezoneParam},
+		Response:   map[string]int{},
+		Handler:    handleGetBestStreak,
+	})
+	api.Handle(api.Route{
+		Method:     "GET",
+		Pattern:    "/api/quick-pen/progress/{range}",
+		Tag:        "quick-pen",
+		Summary:    "Writing totals for today, week, month, year or total",
+		BearerAuth: true,
+		Params:     []api.Param{timezoneParam},
+		Response:   ProgressStats{},
+		Handler:    handleGetProgress,
+	})
+
+	admin.RegisterUserStorage("quick-pen", StorageUsage)
 }
 
 // Returns all sprints for a user
@@ -89,15 +161,16 @@ func handleGetSprints(w http.ResponseWriter, r *http.Request) {
 	user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 	if user == "" {
 		log.Printf("User not specified in request: %+v\n", r)
-		http.Error(w, "User not specified", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Unauthorized("User not specified"))
 		return
 	}
 
 	sprints, err := loadSprints(user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(sprints)
 }
 
@@ -107,18 +180,18 @@ func handleCreateSprint(w http.ResponseWriter, r *http.Request) {
 	user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 	if user == "" {
 		log.Printf("User not specified in request: %+v\n", r)
-		http.Error(w, "User not specified", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Unauthorized("User not specified"))
 		return
 	}
 
 	var sprint Sprint
 	if err := json.NewDecoder(r.Body).Decode(&sprint); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, r, apierror.BadRequest(err.Error()))
 		return
 	}
 
 	if err := ensureUserDir(user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
@@ -127,16 +200,18 @@ func handleCreateSprint(w http.ResponseWriter, r *http.Request) {
 	sprint.Content = "" // Clear content from metadata
 
 	if err := saveSprint(user, sprint); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	// Save content to separate file
 	if err := saveContent(user, sprint.ID, content); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
+	events.PublishVerified(r, "sprint.saved", user, sprint)
+
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -146,23 +221,23 @@ func handleGetSprintContent(w http.ResponseWriter, r *http.Request) {
 	user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 	if user == "" {
 		log.Printf("User not specified in request: %+v\n", r)
-		http.Error(w, "User not specified", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Unauthorized("User not specified"))
 		return
 	}
 
 	idStr := r.PathValue("id")
 	var id int
 	if _, err := fmt.Sscanf(idStr, "%d", &id); err != nil {
-		http.Error(w, "Invalid sprint ID", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Validation("Invalid sprint ID"))
 		return
 	}
 
 	content, err := loadContent(user, id)
 	if err != nil {
 		if os.IsNotExist(err) {
-			http.Error(w, "Sprint content not found", http.StatusNotFound)
+			apierror.Write(w, r, apierror.NotFound("Sprint content not found"))
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			apierror.Write(w, r, err)
 		}
 		return
 	}
@@ -177,25 +252,29 @@ func handleUpdateSprintTags(w http.ResponseWriter, r *http.Request) {
 	user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 	if user == "" {
 		log.Printf("User not specified in request: %+v\n", r)
-		http.Error(w, "User not specified", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Unauthorized("User not specified"))
 		return
 	}
 
 	idStr := r.PathValue("id")
 	var id int
 	if _, err := fmt.Sscanf(idStr, "%d", &id); err != nil {
-		http.Error(w, "Invalid sprint ID", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Validation("Invalid sprint ID"))
 		return
 	}
 
 	var tags []string
 	if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, r, apierror.BadRequest(err.Error()))
 		return
 	}
 
 	if err := updateSprintTags(user, id, tags); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, errSprintNotFound) {
+			apierror.Write(w, r, apierror.NotFound("Sprint not found"))
+			return
+		}
+		apierror.Write(w, r, err)
 		return
 	}
 
@@ -208,7 +287,7 @@ func handleGetBestSprint(w http.ResponseWriter, r *http.Request) {
 	user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 	if user == "" {
 		log.Printf("User not specified in request: %+v\n", r)
-		http.Error(w, "User not specified", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Unauthorized("User not specified"))
 		return
 	}
 
@@ -217,13 +296,13 @@ func handleGetBestSprint(w http.ResponseWriter, r *http.Request) {
 	case HighScoreWPM, HighScoreWords, HighScoreDuration:
 		// Valid category
 	default:
-		http.Error(w, "Invalid category. Must be one of: wpm, words, duration", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Validation("Invalid category. Must be one of: wpm, words, duration"))
 		return
 	}
 
 	sprints, err := loadSprints(user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
@@ -254,6 +333,7 @@ func handleGetBestSprint(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(highScore)
 }
 
@@ -263,7 +343,7 @@ func handleGetBestStreak(w http.ResponseWriter, r *http.Request) {
 	user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 	if user == "" {
 		log.Printf("User not specified in request: %+v\n", r)
-		http.Error(w, "User not specified", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Unauthorized("User not specified"))
 		return
 	}
 
@@ -274,10 +354,11 @@ func handleGetBestStreak(w http.ResponseWriter, r *http.Request) {
 
 	sprints, err := loadSprints(user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	if len(sprints) == 0 {
 		json.NewEncoder(w).Encode(map[string]int{"length": 0})
 		return
@@ -293,7 +374,7 @@ func handleGetProgress(w http.ResponseWriter, r *http.Request) {
 	user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 	if user == "" {
 		log.Printf("User not specified in request: %+v\n", r)
-		http.Error(w, "User not specified", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Unauthorized("User not specified"))
 		return
 	}
 
@@ -307,17 +388,18 @@ func handleGetProgress(w http.ResponseWriter, r *http.Request) {
 	case ProgressToday, ProgressWeek, ProgressMonth, ProgressYear, ProgressTotal:
 		// Valid range
 	default:
-		http.Error(w, "Invalid range. Must be one of: today, week, month, year, total", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Validation("Invalid range. Must be one of: today, week, month, year, total"))
 		return
 	}
 
 	sprints, err := loadSprints(user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	stats := calculateProgressStats(sprints, rangeType, timezone)
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
 
@@ -469,7 +551,7 @@ func updateSprintTags(user string, sprintId int, tags []string) error {
 	}
 
 	if !sprintFound {
-		return fmt.Errorf("sprint not found")
+		return errSprintNotFound
 	}
 
 	// Rewrite the entire sprints file
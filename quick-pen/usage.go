@@ -0,0 +1,51 @@
+package quickpen
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StorageUsage returns the size in bytes of each user's sprints and sprint
+// content.
+func StorageUsage() (map[string]int64, error) {
+	entries, err := os.ReadDir(SPRINTS_DIR)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[string]int64{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if user, ok := strings.CutPrefix(name, ".sprints_"); ok && entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			usage[user] += info.Size()
+			continue
+		}
+
+		user, ok := strings.CutPrefix(name, ".content_")
+		user, isDir := strings.CutSuffix(user, ".d")
+		if !ok || !isDir || !entry.IsDir() {
+			continue
+		}
+		err := filepath.WalkDir(filepath.Join(SPRINTS_DIR, name), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			usage[user] += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"NbirdHttp/api"
+	"NbirdHttp/apierror"
+	"NbirdHttp/auth"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+// Default is the scheduler services register their jobs with.
+var Default = New(filepath.Join(".", "scheduler", "data", "jobs.json"))
+
+// Register adds a job to the default scheduler, logging rather than
+// returning a bad schedule or duplicate name. Names are used in URLs, so
+// keep them to a "service.task-name" form.
+func Register(name, spec string, fn func(ctx context.Context) error) {
+	if err := Default.Register(name, spec, fn); err != nil {
+		log.Printf("[ERROR] scheduler: registering %q: %v\n", name, err)
+	}
+}
+
+// Start runs the default scheduler's jobs in the background.
+func Start() {
+	Default.Start()
+}
+
+// Stop cancels the default scheduler's running jobs and waits for them.
+func Stop(ctx context.Context) error {
+	return Default.Stop(ctx)
+}
+
+func SchedulerController() {
+	api.Handle(api.Route{
+		Method:   "GET",
+		Pattern:  "/api/admin/jobs",
+		Tag:      "admin",
+		Summary:  "List scheduled jobs with their last and next runs",
+		SignedIn: true,
+		Response: []JobStatus{},
+		Handler:  Default.handleListJobs,
+	})
+	api.Handle(api.Route{
+		Method:      "POST",
+		Pattern:     "/api/admin/jobs/{name}/run",
+		Tag:         "admin",
+		Summary:     "Run a job now",
+		Description: "The job runs in the background; poll the job list for its outcome.",
+		SignedIn:    true,
+		Status:      http.StatusAccepted,
+		Response:    JobStatus{},
+		Handler:     Default.handleRunJob,
+	})
+}
+
+func (s *Scheduler) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.RequireAdmin(r); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Jobs())
+}
+
+func (s *Scheduler) handleRunJob(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireAdmin(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := s.Trigger(name); err != nil {
+		if errors.Is(err, ErrUnknownJob) {
+			apierror.Write(w, r, apierror.NotFound(fmt.Sprintf("No job named %q", name)))
+			return
+		}
+		if errors.Is(err, ErrRunning) {
+			apierror.Write(w, r, apierror.Conflict(fmt.Sprintf("Job %q is already running", name)))
+			return
+		}
+		apierror.Write(w, r, err)
+		return
+	}
+	log.Printf("[INFO] scheduler: %s started job %q\n", user, name)
+
+	for _, status := range s.Jobs() {
+		if status.Name == name {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(status)
+			return
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Package scheduler runs services' recurring maintenance jobs on cron-style
+// schedules, remembering when each last ran across restarts.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownJob = errors.New("no job with that name")
+	ErrRunning    = errors.New("job is already running")
+)
+
+// JobStatus is a job's schedule and the outcome of its last run.
+type JobStatus struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Running  bool   `json:"running"`
+	// Persisted between runs of the server
+	LastRun        *time.Time `json:"last_run"`
+	LastDurationMS int64      `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextRun        time.Time  `json:"next_run"`
+}
+
+type job struct {
+	status   JobStatus
+	schedule Schedule
+	run      func(ctx context.Context) error
+}
+
+// Scheduler runs registered jobs when they are due, one run of each job at a
+// time.
+type Scheduler struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	statePath string
+	// Statuses loaded from the state file, applied as jobs register
+	saved map[string]JobStatus
+	now   func() time.Time
+
+	wake    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+// New returns a scheduler that keeps job state in the JSON file at
+// statePath. A job whose saved next run passed while the server was down
+// runs as soon as the scheduler starts.
+func New(statePath string) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		jobs:      map[string]*job{},
+		statePath: statePath,
+		saved:     map[string]JobStatus{},
+		now:       time.Now,
+		wake:      make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[ERROR] scheduler: reading state: %v\n", err)
+	}
+	if err == nil {
+		var saved []JobStatus
+		if err := json.Unmarshal(data, &saved); err != nil {
+			log.Printf("[ERROR] scheduler: parsing %s: %v\n", statePath, err)
+		}
+		for _, status := range saved {
+			s.saved[status.Name] = status
+		}
+	}
+	return s
+}
+
+// Register adds a job that runs fn on the schedule described by spec (see
+// ParseSchedule). fn should return promptly once ctx is cancelled at
+// shutdown.
+func (s *Scheduler) Register(name, spec string, fn func(ctx context.Context) error) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[name]; ok {
+		return fmt.Errorf("job %q is already registered", name)
+	}
+
+	j := &job{schedule: schedule, run: fn}
+	if saved, ok := s.saved[name]; ok && saved.Schedule == spec {
+		j.status = saved
+	} else {
+		j.status.NextRun = schedule.Next(s.now())
+	}
+	j.status.Name = name
+	j.status.Schedule = spec
+	j.status.Running = false
+	s.jobs[name] = j
+
+	s.notify()
+	return nil
+}
+
+// Jobs returns the status of every job ordered by name.
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		statuses = append(statuses, j.status)
+	}
+	slices.SortFunc(statuses, func(a, b JobStatus) int { return strings.Compare(a.Name, b.Name) })
+	return statuses
+}
+
+// Trigger starts a run of the named job now, outside its schedule.
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrUnknownJob
+	}
+	if j.status.Running {
+		return ErrRunning
+	}
+	s.start(j)
+	return nil
+}
+
+// Start runs due jobs in the background until Stop is called.
+func (s *Scheduler) Start() {
+	go func() {
+		for {
+			s.mu.Lock()
+			var next time.Time
+			for _, j := range s.jobs {
+				if !j.status.Running && !j.status.NextRun.IsZero() &&
+					(next.IsZero() || j.status.NextRun.Before(next)) {
+					next = j.status.NextRun
+				}
+			}
+			s.mu.Unlock()
+
+			wait := time.Hour
+			if !next.IsZero() {
+				wait = next.Sub(s.now())
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-s.ctx.Done():
+				timer.Stop()
+				return
+			case <-s.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			s.runDue()
+		}
+	}()
+}
+
+// Stop cancels running jobs and waits for them to return.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.cancel()
+
+	finished := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Starts every job whose next run has come
+func (s *Scheduler) runDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, j := range s.jobs {
+		if !j.status.Running && !j.status.NextRun.IsZero() && !j.status.NextRun.After(now) {
+			s.start(j)
+		}
+	}
+}
+
+// Runs the job in the background. Callers hold s.mu.
+func (s *Scheduler) start(j *job) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	started := s.now()
+	j.status.Running = true
+	j.status.NextRun = j.schedule.Next(started)
+	s.save()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		err := runJob(s.ctx, j)
+		if err != nil {
+			log.Printf("[ERROR] scheduler: job %q failed: %v\n", j.status.Name, err)
+		}
+
+		s.mu.Lock()
+		finished := s.now()
+		j.status.Running = false
+		j.status.LastRun = &started
+		j.status.LastDurationMS = finished.Sub(started).Milliseconds()
+		j.status.LastError = ""
+		if err != nil {
+			j.status.LastError = err.Error()
+		}
+		s.save()
+		s.mu.Unlock()
+
+		s.notify()
+	}()
+}
+
+// Runs the job's function, turning a panic into an error
+func runJob(ctx context.Context, j *job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return j.run(ctx)
+}
+
+// Writes every job's status to the state file. Callers hold s.mu.
+func (s *Scheduler) save() {
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		status := j.status
+		status.Running = false
+		statuses = append(statuses, status)
+	}
+	slices.SortFunc(statuses, func(a, b JobStatus) int { return strings.Compare(a.Name, b.Name) })
+
+	data, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		log.Printf("[ERROR] scheduler: encoding state: %v\n", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0755); err != nil {
+		log.Printf("[ERROR] scheduler: %v\n", err)
+		return
+	}
+	// Replace the file whole so a crash mid-write can't corrupt it
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("[ERROR] scheduler: writing state: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, s.statePath); err != nil {
+		log.Printf("[ERROR] scheduler: writing state: %v\n", err)
+	}
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTriggerSingleFlight(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "jobs.json"))
+	release := make(chan struct{})
+	runs := make(chan struct{}, 10)
+	s.Register("slow", "@daily", func(ctx context.Context) error {
+		runs <- struct{}{}
+		<-release
+		return errors.New("boom")
+	})
+
+	if err := s.Trigger("slow"); err != nil {
+		t.Fatal(err)
+	}
+	<-runs
+	if err := s.Trigger("slow"); !errors.Is(err, ErrRunning) {
+		t.Errorf("second trigger: err = %v, want ErrRunning", err)
+	}
+	if err := s.Trigger("missing"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("unknown job: err = %v, want ErrUnknownJob", err)
+	}
+
+	close(release)
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	status := s.Jobs()[0]
+	if status.Running || status.LastRun == nil || status.LastError != "boom" {
+		t.Errorf("status = %+v", status)
+	}
+	if len(runs) != 0 {
+		t.Errorf("job ran %d extra times", len(runs))
+	}
+}
+
+func TestStatePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.Local)
+
+	s := New(path)
+	s.now = func() time.Time { return now }
+	s.Register("nightly", "0 3 * * *", func(ctx context.Context) error { return nil })
+	s.Trigger("nightly")
+	s.Stop(context.Background())
+
+	// The server was down past the 03:00 run, so it is due on restart
+	now = now.Add(24 * time.Hour)
+	restarted := New(path)
+	restarted.now = func() time.Time { return now }
+	ran := make(chan struct{})
+	restarted.Register("nightly", "0 3 * * *", func(ctx context.Context) error {
+		close(ran)
+		return nil
+	})
+
+	status := restarted.Jobs()[0]
+	if status.LastRun == nil || !status.LastRun.Equal(time.Date(2025, 1, 15, 10, 0, 0, 0, time.Local)) {
+		t.Errorf("LastRun = %v, want the previous run", status.LastRun)
+	}
+	if want := time.Date(2025, 1, 16, 3, 0, 0, 0, time.Local); !status.NextRun.Equal(want) {
+		t.Errorf("NextRun = %v, want %v", status.NextRun, want)
+	}
+
+	restarted.Start()
+	defer restarted.Stop(context.Background())
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("missed job did not run on start")
+	}
+}
+
+func TestRecoversPanics(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "jobs.json"))
+	s.Register("panics", "@hourly", func(ctx context.Context) error { panic("oops") })
+	s.Trigger("panics")
+	s.Stop(context.Background())
+
+	if got := s.Jobs()[0].LastError; got != "panic: oops" {
+		t.Errorf("LastError = %q, want the panic", got)
+	}
+}
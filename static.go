@@ -0,0 +1,23 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// Returns the static site, read from ./static on disk in dev mode so edits
+// show up without rebuilding the binary.
+func staticFS(dev bool) fs.FS {
+	if dev {
+		return os.DirFS("./static")
+	}
+	site, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		panic(err) // the embed directive guarantees the directory exists
+	}
+	return site
+}
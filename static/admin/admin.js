@@ -0,0 +1,130 @@
+import { getLoggedInUser, AUTH_EVENT } from '/scripts/auth.js';
+
+async function loadOverview() {
+  const message = document.getElementById('message');
+  const overview = document.getElementById('overview');
+
+  let data;
+  try {
+    const response = await fetch('/api/v1/admin/overview');
+    if (!response.ok) {
+      const body = await response.json();
+      throw new Error(body.error.message);
+    }
+    data = await response.json();
+  } catch (error) {
+    message.textContent = `Failed to load the overview: ${error.message}`;
+    overview.hidden = true;
+    return;
+  }
+  message.textContent = '';
+  overview.hidden = false;
+
+  document.getElementById('uptime').textContent = formatDuration(data.uptime_seconds);
+  document.getElementById('startedAt').textContent = `since ${formatTime(data.started_at)}`;
+  document.getElementById('version').textContent = `${data.build.version} (${data.build.go_version})`;
+  document.getElementById('revision').textContent = data.build.revision
+    ? `${data.build.revision.slice(0, 12)}${data.build.modified ? ' (modified)' : ''}`
+    : '';
+  document.getElementById('httpConnections').textContent = `${data.connections.http} HTTP`;
+  document.getElementById('eventStreams').textContent = `${data.connections.event_streams} event streams`;
+  const books = data.services.books;
+  if (books) {
+    document.getElementById('bookCount').textContent = `${books.count} books`;
+    document.getElementById('bookStorage').textContent =
+      `${books.covers} covers (${formatBytes(books.covers_bytes)}), ` +
+      `database ${formatBytes(books.database_bytes)}`;
+    document.getElementById('cachedLookups').textContent = `${books.cached_lookups} cached ISBN lookups`;
+  }
+
+  document.getElementById('users').innerHTML = data.users.map(user => `
+    <tr>
+      <td>${escapeHTML(user.name)}${user.admin ? ' <span class="badge">admin</span>' : ''}</td>
+      <td>${user.registered ? 'Yes' : 'No'}</td>
+      <td>${formatBytes(user.storage_bytes.punch ?? 0)}</td>
+      <td>${formatBytes(user.storage_bytes['quick-pen'] ?? 0)}</td>
+    </tr>`).join('');
+
+  document.getElementById('jobs').innerHTML = data.jobs.map(job => `
+    <tr>
+      <td>${escapeHTML(job.name)}</td>
+      <td><code>${escapeHTML(job.schedule)}</code></td>
+      <td>${job.running ? 'Running' : job.last_run ? formatTime(job.last_run) : 'Never'}
+        ${job.last_error ? `<div class="error">${escapeHTML(job.last_error)}</div>` : ''}</td>
+      <td>${job.next_run ? formatTime(job.next_run) : ''}</td>
+      <td><button data-job="${escapeHTML(job.name)}" ${job.running ? 'disabled' : ''}>Run Now</button></td>
+    </tr>`).join('');
+  for (const button of document.querySelectorAll('#jobs button')) {
+    button.onclick = () => runJob(button.dataset.job);
+  }
+
+  const errors = document.getElementById('errors');
+  errors.innerHTML = data.recent_errors.length === 0
+    ? '<li>None since the server started.</li>'
+    : data.recent_errors.map(entry =>
+      `<li><time>${formatTime(entry.time)}</time> ${escapeHTML(entry.message)}</li>`).join('');
+}
+
+async function purgeLookups() {
+  if (!confirm('Purge every cached ISBN lookup? The next lookups ask the providers again.')) {
+    return;
+  }
+  const response = await fetch('/api/v1/admin/isbn-cache', { method: 'DELETE' });
+  if (!response.ok) {
+    const body = await response.json();
+    alert(body.error.message);
+  }
+  loadOverview();
+}
+
+async function runJob(name) {
+  const response = await fetch(`/api/v1/admin/jobs/${encodeURIComponent(name)}/run`, { method: 'POST' });
+  if (!response.ok) {
+    const body = await response.json();
+    alert(body.error.message);
+  }
+  loadOverview();
+}
+
+function formatDuration(seconds) {
+  const days = Math.floor(seconds / 86400);
+  const hours = Math.floor(seconds % 86400 / 3600);
+  const minutes = Math.floor(seconds % 3600 / 60);
+  return days > 0 ? `${days}d ${hours}h ${minutes}m` : `${hours}h ${minutes}m`;
+}
+
+function formatTime(time) {
+  return new Date(time).toLocaleString();
+}
+
+function formatBytes(bytes) {
+  const units = ['B', 'KB', 'MB', 'GB'];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return `${i === 0 ? bytes : bytes.toFixed(1)} ${units[i]}`;
+}
+
+function escapeHTML(text) {
+  return String(text)
+    .replaceAll('&', '&amp;')
+    .replaceAll('<', '&lt;')
+    .replaceAll('>', '&gt;')
+    .replaceAll('"', '&quot;');
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+  document.getElementById('refresh').onclick = loadOverview;
+  document.getElementById('purgeLookups').onclick = purgeLookups;
+  if (getLoggedInUser()) {
+    loadOverview();
+  }
+});
+
+window.addEventListener(AUTH_EVENT, (event) => {
+  if (event.detail.action === 'login') {
+    loadOverview();
+  }
+});
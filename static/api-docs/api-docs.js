@@ -0,0 +1,159 @@
+let g_spec = null;
+
+async function loadDocs() {
+  const docs = document.getElementById('docs');
+  try {
+    const response = await fetch('/api/v1/openapi.json');
+    if (!response.ok) {
+      throw new Error(`HTTP error! status: ${response.status}`);
+    }
+    g_spec = await response.json();
+  } catch (error) {
+    docs.textContent = `Failed to load the API document: ${error.message}`;
+    return;
+  }
+
+  // Group operations by their first tag
+  const groups = {};
+  for (const [path, item] of Object.entries(g_spec.paths)) {
+    for (const [method, op] of Object.entries(item)) {
+      const tag = op.tags?.[0] ?? 'other';
+      (groups[tag] ??= []).push({ path, method, op });
+    }
+  }
+
+  docs.innerHTML = '';
+  for (const tag of Object.keys(groups).sort()) {
+    const section = document.createElement('section');
+    section.className = 'tag-group';
+    section.innerHTML = `<h2>${escapeHTML(tag)}</h2>`;
+    for (const entry of groups[tag]) {
+      section.appendChild(renderOperation(entry));
+    }
+    docs.appendChild(section);
+  }
+}
+
+function renderOperation({ path, method, op }) {
+  const details = document.createElement('details');
+  details.className = 'operation';
+
+  const params = op.parameters ?? [];
+  const body = op.requestBody?.content ?? {};
+  const bodyType = Object.keys(body)[0];
+  const responses = Object.entries(op.responses)
+    .map(([status, res]) => `<h4>${escapeHTML(status)}: ${escapeHTML(res.description)}</h4>` +
+      Object.entries(res.content ?? {})
+        .map(([type, media]) => `<p>${escapeHTML(type)}</p><pre>${escapeHTML(schemaText(media.schema))}</pre>`)
+        .join(''))
+    .join('');
+
+  details.innerHTML = `
+    <summary>
+      <span class="method ${method}">${method.toUpperCase()}</span>
+      <span class="path">${escapeHTML(path)}</span>
+      <span>${escapeHTML(op.summary ?? '')}</span>
+    </summary>
+    ${op.description ? `<p>${escapeHTML(op.description)}</p>` : ''}
+    ${bodyType ? `<h4>Request body (${escapeHTML(Object.keys(body).join(', '))})</h4>
+      <pre>${escapeHTML(schemaText(body[bodyType].schema))}</pre>` : ''}
+    <h3>Responses</h3>
+    ${responses}
+    <h3>Try it</h3>
+    <form class="try-it">
+      ${op.security?.some((s) => 'bearerUser' in s) ? `<label>Username (Bearer) <input name="bearer"></label>` : ''}
+      ${op.security?.some((s) => 'session' in s) ? `<p>Sent as the user signed in on this site.</p>` : ''}
+      ${params.map((p) => `
+        <label>${escapeHTML(p.name)} (${p.in}${p.required ? ', required' : ''})
+          <input name="${p.in}:${escapeHTML(p.name)}" placeholder="${escapeHTML(p.description ?? '')}">
+        </label>`).join('')}
+      ${bodyType === 'application/json' ? `<label>JSON body <textarea name="body" rows="6"></textarea></label>` : ''}
+      ${bodyType && bodyType !== 'application/json' ? `<label>Form body (a=1&b=2) <input name="form"></label>` : ''}
+      <button type="submit">Send</button>
+      <pre class="result" hidden></pre>
+    </form>
+  `;
+
+  details.querySelector('form').addEventListener('submit', (event) => {
+    event.preventDefault();
+    sendRequest(path, method, bodyType, event.target);
+  });
+  return details;
+}
+
+async function sendRequest(path, method, bodyType, form) {
+  const data = new FormData(form);
+  const headers = {};
+  const query = new URLSearchParams();
+  let url = path;
+
+  for (const [key, value] of data.entries()) {
+    if (!value) continue;
+    const [where, name] = key.split(':');
+    if (where === 'path') {
+      url = url.replace(`{${name}}`, encodeURIComponent(value));
+    } else if (where === 'query') {
+      query.append(name, value);
+    } else if (where === 'header') {
+      headers[name] = value;
+    } else if (key === 'bearer') {
+      headers['Authorization'] = `Bearer ${value}`;
+    }
+  }
+  if ([...query].length > 0) {
+    url += `?${query}`;
+  }
+
+  const init = { method: method.toUpperCase(), headers };
+  if (data.get('body')) {
+    headers['Content-Type'] = 'application/json';
+    init.body = data.get('body');
+  } else if (data.get('form')) {
+    headers['Content-Type'] = bodyType === 'multipart/form-data'
+      ? 'application/x-www-form-urlencoded'
+      : bodyType;
+    init.body = data.get('form');
+  }
+
+  const result = form.querySelector('.result');
+  result.hidden = false;
+  try {
+    const response = await fetch(url, init);
+    const text = await response.text();
+    result.textContent = `${response.status} ${response.statusText}\n\n${prettyJSON(text)}`;
+  } catch (error) {
+    result.textContent = `Request failed: ${error.message}`;
+  }
+}
+
+// Renders a schema with component references expanded one level deep
+function schemaText(schema) {
+  const resolve = (s, depth) => {
+    if (!s || typeof s !== 'object') return s;
+    if (s.$ref) {
+      const name = s.$ref.split('/').pop();
+      return depth > 2 ? name : resolve(g_spec.components.schemas[name], depth + 1);
+    }
+    if (Array.isArray(s)) return s.map((item) => resolve(item, depth));
+    return Object.fromEntries(Object.entries(s).map(([k, v]) => [k, resolve(v, depth)]));
+  };
+  return JSON.stringify(resolve(schema, 0), null, 2);
+}
+
+function prettyJSON(text) {
+  try {
+    return JSON.stringify(JSON.parse(text), null, 2);
+  } catch {
+    return text;
+  }
+}
+
+function escapeHTML(text) {
+  return String(text)
+    .replaceAll('&', '&amp;')
+    .replaceAll('<', '&lt;')
+    .replaceAll('>', '&gt;')
+    .replaceAll('"', '&quot;');
+}
+
+document.addEventListener('DOMContentLoaded', loadDocs);
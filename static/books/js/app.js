@@ -1,7 +1,25 @@
-const API_URL = '/api/books';
+import { getLoggedInUser, AUTH_EVENT } from '/scripts/auth.js';
+
+const API_URL = '/api/v1/books';
+const LIST_URL = '/api/v2/books';
+const PAGE_SIZE = 60;
+
+// Book details from an ISBN lookup, and the IDs of their inputs
+const DETAIL_FIELDS = [
+  ['isbn', 'isbn'],
+  ['subtitle', 'subtitle'],
+  ['publisher', 'publisher'],
+  ['publish_date', 'publishDate'],
+  ['pages', 'pages'],
+  ['language', 'language'],
+  ['description', 'description']
+];
 
 // State
 let books = [];
+let totalBooks = 0;
+let nextCursor = null;
+let libraries = [];
 let genres = [];
 let allTags = [];
 let currentTags = [];
@@ -24,6 +42,8 @@ const tagsContainer = document.getElementById('tagsContainer');
 const tagsInput = document.getElementById('tagsInput');
 const imagePreview = document.getElementById('imagePreview');
 const genreFilter = document.getElementById('genreFilter');
+const libraryFilter = document.getElementById('libraryFilter');
+const librariesModal = document.getElementById('librariesModal');
 const tagFilter = document.getElementById('tagFilter');
 const genreList = document.getElementById('genreList');
 const deleteBookTitle = document.getElementById('deleteBookTitle');
@@ -43,11 +63,40 @@ document.addEventListener('DOMContentLoaded', () => {
     document.body.classList.add('list-view');
   }
 
-  loadBooks();
-  loadFilters();
   setupEventListeners();
+  if (getLoggedInUser()) {
+    loadAll();
+  }
+});
+
+window.addEventListener(AUTH_EVENT, (event) => {
+  if (event.detail.action === 'login') {
+    loadAll();
+  }
 });
 
+function loadAll() {
+  loadLibraries();
+  loadBooks();
+  loadFilters();
+  subscribeToBookEvents();
+}
+
+// Reload when books are changed from another tab, device or library member
+let events = null;
+function subscribeToBookEvents() {
+  events?.close();
+  const params = new URLSearchParams({ topics: 'book' });
+  events = new EventSource(`/api/v1/events?${params}`);
+  const reload = debounce(() => {
+    loadBooks();
+    loadFilters();
+  }, 300);
+  for (const topic of ['book.created', 'book.updated', 'book.deleted']) {
+    events.addEventListener(topic, reload);
+  }
+}
+
 function setupEventListeners() {
   // Filter toggle
   filterToggleBtn.addEventListener('click', toggleFilters);
@@ -78,12 +127,21 @@ function setupEventListeners() {
   // Image preview
   document.getElementById('coverImage').addEventListener('change', handleImageChange);
 
+  // Libraries modal
+  document.getElementById('librariesBtn').addEventListener('click', openLibrariesModal);
+  document.getElementById('closeLibrariesModal').addEventListener('click', closeLibrariesModal);
+  librariesModal.querySelector('.modal__overlay').addEventListener('click', closeLibrariesModal);
+  document.getElementById('libraryForm').addEventListener('submit', handleCreateLibrary);
+
   // Filters
-  document.getElementById('searchInput').addEventListener('input', debounce(loadBooks, 300));
-  genreFilter.addEventListener('change', loadBooks);
-  document.getElementById('statusFilter').addEventListener('change', loadBooks);
-  document.getElementById('signedFilter').addEventListener('change', loadBooks);
-  tagFilter.addEventListener('change', loadBooks);
+  document.getElementById('searchInput').addEventListener('input', debounce(() => loadBooks(), 300));
+  genreFilter.addEventListener('change', () => loadBooks());
+  libraryFilter.addEventListener('change', () => loadBooks());
+  document.getElementById('sortSelect').addEventListener('change', () => loadBooks());
+  document.getElementById('loadMoreBtn').addEventListener('click', () => loadBooks(true));
+  document.getElementById('statusFilter').addEventListener('change', () => loadBooks());
+  document.getElementById('signedFilter').addEventListener('change', () => loadBooks());
+  tagFilter.addEventListener('change', () => loadBooks());
   document.getElementById('clearFiltersBtn').addEventListener('click', clearFilters);
 
   // Scan ISBN button
@@ -107,29 +165,41 @@ function setupEventListeners() {
       closeDeleteModal();
       closeScanModal();
       closePreviewModal();
+      closeLibrariesModal();
     }
   });
 }
 
 // API Functions
-async function loadBooks() {
-  const params = new URLSearchParams();
+
+// Loads the first page of books, or with more, the page after those shown
+async function loadBooks(more = false) {
+  const params = new URLSearchParams({ limit: PAGE_SIZE });
 
   const search = document.getElementById('searchInput').value;
   const genre = genreFilter.value;
   const status = document.getElementById('statusFilter').value;
   const signed = document.getElementById('signedFilter').value;
   const tag = tagFilter.value;
+  const library = libraryFilter.value;
+  const sort = document.getElementById('sortSelect').value;
 
-  if (search) params.append('search', search);
+  if (library) params.append('library', library);
+  if (search.trim()) params.append('q', search);
   if (genre) params.append('genre', genre);
   if (status) params.append('read_status', status);
   if (signed) params.append('is_signed', signed);
   if (tag) params.append('tag', tag);
+  // Searches sort by relevance unless another order is picked
+  if (sort && !(search.trim() && sort === '-created_at')) params.append('sort', sort);
+  if (more && nextCursor) params.append('cursor', nextCursor);
 
   try {
-    const response = await fetch(`${API_URL}?${params}`);
-    books = await response.json();
+    const response = await fetch(`${LIST_URL}?${params}`);
+    const page = await response.json();
+    books = more ? books.concat(page.books) : page.books;
+    totalBooks = page.meta.total;
+    nextCursor = page.meta.next_cursor ?? null;
     renderBooks();
   } catch (error) {
     console.error('Error loading books:', error);
@@ -160,7 +230,8 @@ async function saveBook(formData) {
   const response = await fetch(url, { method, body: formData });
 
   if (!response.ok) {
-    throw new Error('Failed to save book');
+    const body = await response.json().catch(() => null);
+    throw new Error(body?.error?.message || 'Failed to save book');
   }
 
   return response.json();
@@ -174,9 +245,127 @@ async function deleteBook(id) {
   }
 }
 
+async function loadLibraries() {
+  try {
+    const response = await fetch(`${API_URL}/libraries`);
+    libraries = await response.json();
+    updateLibrarySelects();
+    renderLibraries();
+  } catch (error) {
+    console.error('Error loading libraries:', error);
+  }
+}
+
+// Sends a libraries request, alerting with the error message if it fails
+async function libraryRequest(path, method, body) {
+  const options = { method, headers: {} };
+  if (body) {
+    options.headers['Content-Type'] = 'application/json';
+    options.body = JSON.stringify(body);
+  }
+  const response = await fetch(`${API_URL}/libraries${path}`, options);
+  if (!response.ok) {
+    const error = await response.json();
+    alert(error.error.message);
+    return false;
+  }
+  loadLibraries();
+  return true;
+}
+
 // Render Functions
+function updateLibrarySelects() {
+  const selected = libraryFilter.value;
+  libraryFilter.innerHTML = '<option value="">All Libraries</option>' +
+    libraries.map(library => `<option value="${library.id}">${escapeHtml(library.name)}</option>`).join('');
+  libraryFilter.value = selected;
+
+  // New books go in the user's own library unless another is picked
+  for (const select of document.querySelectorAll('.library-select')) {
+    select.innerHTML = libraries.map(library =>
+      `<option value="${library.id}">${escapeHtml(library.name)}</option>`).join('');
+  }
+}
+
+function renderLibraries() {
+  const user = getLoggedInUser();
+  document.getElementById('librariesList').innerHTML = libraries.map(library => {
+    const owner = library.owner === user;
+    const members = library.personal ? '<p class="libraries__note">Only you can see this library.</p>' : `
+      <ul class="libraries__members">
+        ${library.members.map(member => `
+          <li>
+            ${escapeHtml(member)}${member === library.owner ? ' (owner)' : ''}
+            ${member !== library.owner && (owner || member === user)
+              ? `<button class="btn btn--secondary" data-library="${library.id}" data-remove="${escapeHtml(member)}">
+                  ${member === user ? 'Leave' : 'Remove'}</button>`
+              : ''}
+          </li>`).join('')}
+      </ul>
+      ${owner ? `
+        <div class="libraries__actions">
+          <input type="text" class="input" placeholder="Username" data-member-input="${library.id}">
+          <button class="btn btn--secondary" data-add-member="${library.id}">Add Member</button>
+          <button class="btn btn--danger" data-delete-library="${library.id}">Delete</button>
+        </div>` : ''}`;
+    return `
+      <div class="libraries__item">
+        <h3>${escapeHtml(library.name)}</h3>
+        ${members}
+      </div>`;
+  }).join('');
+
+  for (const button of document.querySelectorAll('[data-remove]')) {
+    button.onclick = () => removeMember(button.dataset.library, button.dataset.remove);
+  }
+  for (const button of document.querySelectorAll('[data-add-member]')) {
+    button.onclick = () => addMember(button.dataset.addMember);
+  }
+  for (const button of document.querySelectorAll('[data-delete-library]')) {
+    button.onclick = () => deleteLibrary(button.dataset.deleteLibrary);
+  }
+}
+
+function openLibrariesModal() {
+  loadLibraries();
+  librariesModal.classList.add('open');
+  document.body.classList.add('modal-open');
+}
+
+function closeLibrariesModal() {
+  librariesModal.classList.remove('open');
+  document.body.classList.remove('modal-open');
+}
+
+async function handleCreateLibrary(e) {
+  e.preventDefault();
+  const name = document.getElementById('libraryName');
+  if (await libraryRequest('', 'POST', { name: name.value })) {
+    name.value = '';
+  }
+}
+
+async function addMember(libraryId) {
+  const input = document.querySelector(`[data-member-input="${libraryId}"]`);
+  await libraryRequest(`/${libraryId}/members`, 'POST', { user: input.value.trim() });
+}
+
+async function removeMember(libraryId, member) {
+  if (await libraryRequest(`/${libraryId}/members/${encodeURIComponent(member)}`, 'DELETE')) {
+    loadBooks();
+    loadFilters();
+  }
+}
+
+async function deleteLibrary(libraryId) {
+  if (confirm('Delete this library?')) {
+    await libraryRequest(`/${libraryId}`, 'DELETE');
+  }
+}
+
 function renderBooks() {
   if (books.length === 0) {
+    document.getElementById('loadMoreBtn').hidden = true;
     booksGrid.innerHTML = '';
     emptyState.classList.add('visible');
     bookStats.textContent = '';
@@ -184,7 +373,10 @@ function renderBooks() {
   }
 
   emptyState.classList.remove('visible');
-  bookStats.textContent = `${books.length} book${books.length !== 1 ? 's' : ''} in collection`;
+  bookStats.textContent = books.length < totalBooks
+    ? `Showing ${books.length} of ${totalBooks} books`
+    : `${totalBooks} book${totalBooks !== 1 ? 's' : ''} in collection`;
+  document.getElementById('loadMoreBtn').hidden = !nextCursor;
 
   booksGrid.innerHTML = books.map((book, index) => `
     <article class="book-card" style="animation-delay: ${index * 0.05}s" data-id="${book.id}">
@@ -197,10 +389,13 @@ function renderBooks() {
       </div>
       <div class="book-card__body">
         <h3 class="book-card__title">${escapeHtml(book.title)}</h3>
+        ${book.subtitle ? `<p class="book-card__subtitle">${escapeHtml(book.subtitle)}</p>` : ''}
         <p class="book-card__author">${escapeHtml(book.author)}</p>
+        ${book.snippet ? `<p class="book-card__snippet">${book.snippet}</p>` : ''}
         <div class="book-card__meta">
           ${book.genre ? `<span class="book-card__genre">${escapeHtml(book.genre)}</span>` : ''}
           <span class="book-card__status book-card__status--${book.read_status}">${formatStatus(book.read_status)}</span>
+          ${book.rating ? `<span class="book-card__rating" title="${book.rating} of 5">${'★'.repeat(book.rating)}</span>` : ''}
         </div>
         ${book.tags.length > 0 ? `
           <div class="book-card__tags">
@@ -269,6 +464,11 @@ function openModal(bookId = null) {
       document.getElementById('genre').value = book.genre || '';
       document.getElementById('readStatus').value = book.read_status;
       document.getElementById('isSigned').checked = book.is_signed;
+      document.getElementById('library').value = book.library_id;
+      document.getElementById('rating').value = book.rating ?? 0;
+      for (const [field, id] of DETAIL_FIELDS) {
+        document.getElementById(id).value = book[field] ?? '';
+      }
       currentTags = [...book.tags];
 
       if (book.cover_image) {
@@ -314,6 +514,11 @@ async function handleSubmit(e) {
   formData.append('read_status', document.getElementById('readStatus').value);
   formData.append('is_signed', document.getElementById('isSigned').checked);
   formData.append('tags', JSON.stringify(currentTags));
+  formData.append('library_id', document.getElementById('library').value);
+  formData.append('rating', document.getElementById('rating').value);
+  for (const [field, id] of DETAIL_FIELDS) {
+    formData.append(field, document.getElementById(id).value);
+  }
 
   const coverInput = document.getElementById('coverImage');
   if (coverInput.files[0]) {
@@ -327,7 +532,7 @@ async function handleSubmit(e) {
     loadFilters();
   } catch (error) {
     console.error('Error saving book:', error);
-    alert('Failed to save book. Please try again.');
+    alert(`${error.message}. Please try again.`);
   }
 }
 
@@ -384,6 +589,7 @@ function clearFilters() {
   document.getElementById('statusFilter').value = '';
   document.getElementById('signedFilter').value = '';
   tagFilter.value = '';
+  document.getElementById('sortSelect').value = '-created_at';
   loadBooks();
 }
 
@@ -399,6 +605,11 @@ function toggleView() {
 }
 
 // Utility Functions
+// The ID of the preview form's input for a book form input
+function previewId(id) {
+  return 'preview' + id[0].toUpperCase() + id.slice(1);
+}
+
 function escapeHtml(text) {
   if (!text) return '';
   const div = document.createElement('div');
@@ -539,7 +750,7 @@ async function onScanSuccess(decodedText, decodedResult) {
   scanStatus.className = 'scan__status scan__status--loading';
 
   try {
-    const response = await fetch(`/api/isbn/${decodedText}`);
+    const response = await fetch(`/api/v1/isbn/${decodedText}`);
 
     if (!response.ok) {
       throw new Error('Book not found');
@@ -547,7 +758,9 @@ async function onScanSuccess(decodedText, decodedResult) {
 
     const bookData = await response.json();
 
-    scanStatus.textContent = 'Book found!';
+    scanStatus.textContent = bookData.partial
+      ? 'Book found, but some details could not be fetched.'
+      : 'Book found!';
     scanStatus.className = 'scan__status scan__status--success';
 
     // Close scan modal and open preview
@@ -576,7 +789,9 @@ function openPreviewModal(bookData) {
   previewTags = [];
   previewForm.reset();
 
-  document.getElementById('previewIsbn').value = bookData.isbn || '';
+  for (const [field, id] of DETAIL_FIELDS) {
+    document.getElementById(previewId(id)).value = bookData[field] ?? '';
+  }
   document.getElementById('previewCoverPath').value = bookData.cover_image || '';
   document.getElementById('previewTitle').value = bookData.title || '';
   document.getElementById('previewAuthor').value = bookData.author || '';
@@ -644,6 +859,11 @@ async function handlePreviewSubmit(e) {
   formData.append('read_status', document.getElementById('previewReadStatus').value);
   formData.append('is_signed', document.getElementById('previewIsSigned').checked);
   formData.append('tags', JSON.stringify(previewTags));
+  formData.append('library_id', document.getElementById('previewLibrary').value);
+  formData.append('rating', document.getElementById('previewRating').value);
+  for (const [field, id] of DETAIL_FIELDS) {
+    formData.append(field, document.getElementById(previewId(id)).value);
+  }
 
   // Use the cover path from ISBN lookup (already saved on server)
   const coverPath = document.getElementById('previewCoverPath').value;
@@ -658,7 +878,7 @@ async function handlePreviewSubmit(e) {
     loadFilters();
   } catch (error) {
     console.error('Error saving book:', error);
-    alert('Failed to save book. Please try again.');
+    alert(`${error.message}. Please try again.`);
   }
 }
 
@@ -680,12 +900,22 @@ async function saveBookFromPreview(formData) {
       read_status: data.read_status,
       is_signed: data.is_signed === 'true',
       tags: data.tags,
-      cover_image: data.cover_image || null
+      cover_image: data.cover_image || null,
+      library_id: Number(data.library_id),
+      rating: Number(data.rating),
+      isbn: data.isbn,
+      subtitle: data.subtitle,
+      publisher: data.publisher,
+      publish_date: data.publish_date,
+      pages: data.pages ? Number(data.pages) : null,
+      language: data.language,
+      description: data.description
     })
   });
 
   if (!response.ok) {
-    throw new Error('Failed to save book');
+    const body = await response.json().catch(() => null);
+    throw new Error(body?.error?.message || 'Failed to save book');
   }
 
   return response.json();
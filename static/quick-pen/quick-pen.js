@@ -144,7 +144,7 @@ class SprintTimer {
   }
 
   async updateSprintTags(sprintId, tags) {
-    const response = await fetch(`/api/quick-pen/sprint/${sprintId}/tags`, {
+    const response = await fetch(`/api/v1/quick-pen/sprint/${sprintId}/tags`, {
       method: 'PATCH',
       headers: {
         'Content-Type': 'application/json',
@@ -308,7 +308,7 @@ class SprintTimer {
     };
 
     try {
-      const response = await fetch('/api/quick-pen/sprint', {
+      const response = await fetch('/api/v1/quick-pen/sprint', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
@@ -334,7 +334,7 @@ class SprintTimer {
   }
 
   async loadSprintContent(id) {
-    const response = await fetch(`/api/quick-pen/sprint/${id}/content`, {
+    const response = await fetch(`/api/v1/quick-pen/sprint/${id}/content`, {
       method: 'GET',
       headers: {
         'Authorization': `Bearer ${getLoggedInUser()}`
@@ -409,7 +409,7 @@ class SprintTimer {
 
   async loadSprints() {
     try {
-      const response = await fetch('/api/quick-pen/sprints', {
+      const response = await fetch('/api/v1/quick-pen/sprints', {
         method: 'GET',
         headers: {
           'Authorization': `Bearer ${getLoggedInUser()}`
@@ -463,7 +463,7 @@ class SprintTimer {
     this.currentSprintId = sprintData.id;
 
     try {
-      const response = await fetch(`/api/quick-pen/sprint/${sprintData.id}/content`, {
+      const response = await fetch(`/api/v1/quick-pen/sprint/${sprintData.id}/content`, {
         method: 'GET',
         headers: {
           'Authorization': `Bearer ${getLoggedInUser()}`
@@ -502,7 +502,7 @@ class SprintTimer {
 
     for (const category of categories) {
       try {
-        const response = await fetch(`/api/quick-pen/best-sprint/${category}`, {
+        const response = await fetch(`/api/v1/quick-pen/best-sprint/${category}`, {
           method: 'GET',
           headers: {
             'Authorization': `Bearer ${getLoggedInUser()}`
@@ -536,7 +536,7 @@ class SprintTimer {
 
     // Load streak separately
     try {
-      const response = await fetch('/api/quick-pen/best-streak', {
+      const response = await fetch('/api/v1/quick-pen/best-streak', {
         method: 'GET',
         headers: {
           'Authorization': `Bearer ${getLoggedInUser()}`,
@@ -559,7 +559,7 @@ class SprintTimer {
   async loadProgress() {
     try {
       const range = this.progressRange.value;
-      const response = await fetch(`/api/quick-pen/progress/${range}`, {
+      const response = await fetch(`/api/v1/quick-pen/progress/${range}`, {
         method: 'GET',
         headers: {
           'Authorization': `Bearer ${getLoggedInUser()}`,
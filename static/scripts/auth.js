@@ -115,7 +115,7 @@ function createLogoutButton() {
 }
 
 function setupListeners() {
-  document.getElementById('logout').onclick = logoutUser;
+  document.getElementById('logout').onclick = () => logoutUser();
 
   document.getElementById('registerForm').onsubmit = async function (event) {
     event.preventDefault();
@@ -150,19 +150,27 @@ function init() {
   }
 }
 
+// API errors arrive as {"error": {"code", "message"}}; successes as plain text
+async function responseMessage(response) {
+  if (response.headers.get('Content-Type')?.startsWith('application/json')) {
+    const body = await response.json();
+    return body.error?.message ?? JSON.stringify(body);
+  }
+  return response.text();
+}
+
 async function registerUser(username, password) {
-  const response = await fetch('/api/auth/register', {
+  const response = await fetch('/api/v1/auth/register', {
     method: 'POST',
     headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
     body: new URLSearchParams({ username, password })
   });
 
-  const message = await response.text();
-  alert(message);
+  alert(await responseMessage(response));
 }
 
 async function loginUser(username, password) {
-  const response = await fetch('/api/auth/login', {
+  const response = await fetch('/api/v1/auth/login', {
     method: 'POST',
     headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
     body: new URLSearchParams({ username, password })
@@ -175,13 +183,14 @@ async function loginUser(username, password) {
       detail: { user: username, action: 'login' }
     }));
   } else {
-    const message = await response.text();
-    alert(message);
+    alert(await responseMessage(response));
   }
 }
 
-function logoutUser() {
+async function logoutUser() {
   const user = getLoggedInUser();
+  // Ends the session the login cookie names
+  await fetch('/api/v1/auth/logout', { method: 'POST' });
   localStorage.removeItem('loggedInUser');
   setContentVisible(false);
   window.dispatchEvent(new CustomEvent(AUTH_EVENT, {
@@ -5,36 +5,40 @@ async function handlePost(endpoint) {
       method: 'POST',
     });
 
-    await response.text().then((body) => {
-      alert(body);
-    });
+    if (!response.ok) {
+      const body = await response.json();
+      alert(body.error.message);
+      return;
+    }
+
+    alert(await response.text());
   } catch (error) {
     alert(`Request failed: ${error.message}`);
   }
 }
 
 async function punchIn() {
-  await handlePost('/api/punch/in');
+  await handlePost('/api/v1/punch/in');
   checkStatus();
 }
 
 async function startBreak() {
-  await handlePost('/api/punch/break/start');
+  await handlePost('/api/v1/punch/break/start');
   checkStatus();
 }
 
 async function endBreak() {
-  await handlePost('/api/punch/break/end')
+  await handlePost('/api/v1/punch/break/end')
   checkStatus();
 }
 
 async function punchOut() {
-  await handlePost('/api/punch/out');
+  await handlePost('/api/v1/punch/out');
   checkStatus();
 }
 
 async function checkStatus() {
-  let url = '/api/punch/status' + `?user=${g_loggedInUser}`;
+  let url = '/api/v1/punch/status' + `?user=${g_loggedInUser}`;
 
   const workHours = document.getElementById('workHours').value;
   if (workHours) {
@@ -0,0 +1,318 @@
+package webhooks
+
+import (
+	"NbirdHttp/events"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Dispatcher stores webhooks and delivers events to them from a persistent
+// queue, retrying failed deliveries with exponential backoff.
+type Dispatcher struct {
+	// Delay before the first retry, doubling after each failed attempt
+	RetryBase time.Duration
+	// Longest delay between retries
+	RetryMax time.Duration
+	// Attempts made before a delivery is marked failed
+	MaxAttempts int
+
+	db     *sql.DB
+	client *http.Client
+	now    func() time.Time
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens (or creates) the webhook database at path. Deliveries left
+// pending by a previous run are sent once Start is called.
+func Open(path string) (*Dispatcher, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; serialize rather than fail with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user TEXT NOT NULL,
+			url TEXT NOT NULL,
+			events TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_code INTEGER,
+			last_error TEXT,
+			created_at INTEGER NOT NULL,
+			next_attempt_at INTEGER NOT NULL,
+			delivered_at INTEGER
+		);
+		CREATE INDEX IF NOT EXISTS deliveries_due ON deliveries(status, next_attempt_at);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create webhook tables: %w", err)
+	}
+
+	return &Dispatcher{
+		RetryBase:   30 * time.Second,
+		RetryMax:    time.Hour,
+		MaxAttempts: 8,
+		db:          db,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		now:         time.Now,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// Payload is the JSON body posted to a webhook.
+type Payload struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	User  string    `json:"user,omitempty"`
+	Data  any       `json:"data"`
+}
+
+// Enqueue queues a delivery of the event to every webhook subscribed to it.
+// Events for a user go to that user's webhooks; public events go to all.
+func (d *Dispatcher) Enqueue(event events.Event) {
+	if !isWebhookEvent(event.Topic) {
+		return
+	}
+
+	payload, err := json.Marshal(Payload{Event: event.Topic, Time: event.Time, User: event.User, Data: event.Data})
+	if err != nil {
+		log.Printf("[ERROR] webhooks: encoding %s: %v\n", event.Topic, err)
+		return
+	}
+
+	now := d.now().UnixMilli()
+	res, err := d.db.Exec(`
+		INSERT INTO deliveries (webhook_id, event, payload, created_at, next_attempt_at)
+		SELECT id, ?, ?, ?, ? FROM webhooks
+		WHERE (user = ? OR ? = '')
+			AND EXISTS (SELECT 1 FROM json_each(webhooks.events) WHERE value = ?)
+	`, event.Topic, string(payload), now, now, event.User, event.User, event.Topic)
+	if err != nil {
+		log.Printf("[ERROR] webhooks: queueing %s: %v\n", event.Topic, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		d.notify()
+	}
+}
+
+// Wakes the worker without blocking if it is already due to run
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the delivery worker until Stop is called.
+func (d *Dispatcher) Start() {
+	go func() {
+		defer close(d.done)
+
+		poll := time.NewTicker(time.Second)
+		defer poll.Stop()
+		for {
+			d.deliverDue()
+			select {
+			case <-d.stop:
+				return
+			case <-d.wake:
+			case <-poll.C:
+			}
+		}
+	}()
+}
+
+// Stop waits for the delivery in flight to finish and closes the database.
+// Undelivered events stay queued for the next run.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	close(d.stop)
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return d.db.Close()
+}
+
+type pendingDelivery struct {
+	id       int64
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+// Sends every delivery whose next attempt is due
+func (d *Dispatcher) deliverDue() {
+	for {
+		rows, err := d.db.Query(`
+			SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+			FROM deliveries d JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT 20
+		`, d.now().UnixMilli())
+		if err != nil {
+			log.Printf("[ERROR] webhooks: loading due deliveries: %v\n", err)
+			return
+		}
+
+		var due []pendingDelivery
+		for rows.Next() {
+			var p pendingDelivery
+			if err := rows.Scan(&p.id, &p.event, &p.payload, &p.attempts, &p.url, &p.secret); err != nil {
+				log.Printf("[ERROR] webhooks: %v\n", err)
+				continue
+			}
+			due = append(due, p)
+		}
+		rows.Close()
+
+		if len(due) == 0 {
+			return
+		}
+		for _, p := range due {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+			d.deliver(p)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(p pendingDelivery) {
+	code, err := d.post(p)
+	attempts := p.attempts + 1
+	now := d.now()
+
+	if err == nil {
+		_, err = d.db.Exec(`
+			UPDATE deliveries SET status = 'delivered', attempts = ?, response_code = ?,
+				last_error = NULL, delivered_at = ?
+			WHERE id = ?
+		`, attempts, code, now.UnixMilli(), p.id)
+		if err != nil {
+			log.Printf("[ERROR] webhooks: recording delivery %d: %v\n", p.id, err)
+		}
+		return
+	}
+
+	status := "pending"
+	if attempts >= d.MaxAttempts {
+		status = "failed"
+		log.Printf("[WARN] webhooks: giving up on delivery %d to %s after %d attempts: %v\n", p.id, p.url, attempts, err)
+	}
+	var responseCode *int
+	if code != 0 {
+		responseCode = &code
+	}
+	_, dbErr := d.db.Exec(`
+		UPDATE deliveries SET status = ?, attempts = ?, response_code = ?, last_error = ?,
+			next_attempt_at = ?
+		WHERE id = ?
+	`, status, attempts, responseCode, err.Error(), now.Add(d.backoff(attempts)).UnixMilli(), p.id)
+	if dbErr != nil {
+		log.Printf("[ERROR] webhooks: recording failed delivery %d: %v\n", p.id, dbErr)
+	}
+}
+
+// How long finished deliveries stay in the delivery log
+const deliveryRetention = 30 * 24 * time.Hour
+
+// Deletes delivered and failed deliveries older than deliveryRetention
+func (d *Dispatcher) pruneDeliveries(ctx context.Context) error {
+	cutoff := d.now().Add(-deliveryRetention).UnixMilli()
+	res, err := d.db.ExecContext(ctx, "DELETE FROM deliveries WHERE status != 'pending' AND created_at < ?", cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to prune deliveries: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[INFO] webhooks: pruned %d old deliveries\n", n)
+	}
+	return nil
+}
+
+// Delay before retrying a delivery that has failed attempts times
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.RetryBase
+	for i := 1; i < attempts && delay < d.RetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, d.RetryMax)
+}
+
+// Posts the payload and returns the response status, erroring unless it is
+// a 2xx
+func (d *Dispatcher) post(p pendingDelivery) (int, error) {
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(p.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "NbirdHttp-Webhooks")
+	req.Header.Set("X-Nbird-Event", p.event)
+	req.Header.Set("X-Nbird-Delivery", strconv.FormatInt(p.id, 10))
+	req.Header.Set("X-Nbird-Timestamp", timestamp)
+	req.Header.Set("X-Nbird-Signature", "sha256="+Sign(p.secret, timestamp, p.payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "timestamp.body" under the webhook's
+// secret, as sent in the X-Nbird-Signature header. Receivers recompute it to
+// check a delivery came from this server, and check the timestamp is recent
+// to reject replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,342 @@
+// Package webhooks lets users register URLs that are sent a signed JSON POST
+// whenever one of their punch, sprint or book events is published.
+package webhooks
+
+import (
+	"NbirdHttp/api"
+	"NbirdHttp/apierror"
+	"NbirdHttp/auth"
+	"NbirdHttp/events"
+	"NbirdHttp/lifecycle"
+	"NbirdHttp/scheduler"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Events a webhook may subscribe to
+var Events = []string{
+	"punch.in",
+	"punch.out",
+	"punch.break.start",
+	"punch.break.end",
+	"sprint.saved",
+	"book.created",
+}
+
+func isWebhookEvent(topic string) bool {
+	return slices.Contains(Events, topic)
+}
+
+// Webhook is a URL registered to receive events.
+type Webhook struct {
+	ID     int64    `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Key the payloads are signed with, only included when the webhook is
+	// created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Fields accepted when registering a webhook
+type webhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// Delivery is one event queued for a webhook and the outcome of sending it.
+type Delivery struct {
+	ID    int64  `json:"id"`
+	Event string `json:"event"`
+	// pending, delivered or failed
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// Status code of the last response, if one was received
+	ResponseCode  *int       `json:"response_code"`
+	LastError     *string    `json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+}
+
+func WebhooksController() {
+	dataDir := filepath.Join(".", "webhooks", "data")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		log.Printf("[ERROR] Failed to create webhooks data directory: %v\n", err)
+		return
+	}
+	d, err := Open(filepath.Join(dataDir, "webhooks.db"))
+	if err != nil {
+		log.Printf("[ERROR] Failed to open webhooks database: %v\n", err)
+		return
+	}
+
+	events.Listen(d.Enqueue)
+	d.Start()
+	lifecycle.OnShutdown("webhooks: stop deliveries", d.Stop)
+	scheduler.Register("webhooks.prune-deliveries", "15 3 * * *", d.pruneDeliveries)
+
+	d.routes()
+}
+
+func (d *Dispatcher) routes() {
+	api.Handle(api.Route{
+		Method:   "GET",
+		Pattern:  "/api/webhooks",
+		Tag:      "webhooks",
+		Summary:  "List the user's webhooks",
+		SignedIn: true,
+		Response: []Webhook{},
+		Handler:  d.handleListWebhooks,
+	})
+	api.Handle(api.Route{
+		Method:  "POST",
+		Pattern: "/api/webhooks",
+		Tag:     "webhooks",
+		Summary: "Register a webhook",
+		Description: "Each event is POSTed to the URL as a Payload. The X-Nbird-Signature header holds " +
+			`"sha256=" and the hex HMAC-SHA256 of the X-Nbird-Timestamp header, ".", and the body, ` +
+			"keyed with the secret returned here. Deliveries that fail are retried with " +
+			"exponential backoff. Events: punch.in, punch.out, punch.break.start, " +
+			"punch.break.end, sprint.saved, book.created.",
+		SignedIn: true,
+		Request:  webhookInput{},
+		Status:   http.StatusCreated,
+		Response: Webhook{},
+		Handler:  d.handleCreateWebhook,
+	})
+	api.Handle(api.Route{
+		Method:   "DELETE",
+		Pattern:  "/api/webhooks/{id}",
+		Tag:      "webhooks",
+		Summary:  "Delete a webhook and its delivery log",
+		SignedIn: true,
+		Status:   http.StatusNoContent,
+		Handler:  d.handleDeleteWebhook,
+	})
+	api.Handle(api.Route{
+		Method:   "GET",
+		Pattern:  "/api/webhooks/{id}/deliveries",
+		Tag:      "webhooks",
+		Summary:  "Recent deliveries to a webhook, newest first",
+		SignedIn: true,
+		Params: []api.Param{
+			{Name: "limit", In: "query", Description: "Number of deliveries to return (default 50, at most 500)"},
+		},
+		Response: []Delivery{},
+		Handler:  d.handleListDeliveries,
+	})
+}
+
+func (d *Dispatcher) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	rows, err := d.db.Query("SELECT id, url, events, created_at FROM webhooks WHERE user = ? ORDER BY id", user)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to query webhooks: %w", err))
+		return
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var hook Webhook
+		var eventsJSON string
+		var createdAt int64
+		if err := rows.Scan(&hook.ID, &hook.URL, &eventsJSON, &createdAt); err != nil {
+			apierror.Write(w, r, fmt.Errorf("failed to scan webhook: %w", err))
+			return
+		}
+		json.Unmarshal([]byte(eventsJSON), &hook.Events)
+		hook.CreatedAt = time.UnixMilli(createdAt).UTC()
+		webhooks = append(webhooks, hook)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+func (d *Dispatcher) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var input webhookInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		apierror.Write(w, r, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	target, err := url.Parse(input.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		apierror.Write(w, r, apierror.Validation("URL must be an absolute http or https URL").
+			WithDetails(map[string]string{"field": "url"}))
+		return
+	}
+	if len(input.Events) == 0 {
+		apierror.Write(w, r, apierror.Validation("At least one event is required").
+			WithDetails(map[string]any{"field": "events", "allowed": Events}))
+		return
+	}
+	for _, event := range input.Events {
+		if !isWebhookEvent(event) {
+			apierror.Write(w, r, apierror.Validation(fmt.Sprintf("Unknown event %q", event)).
+				WithDetails(map[string]any{"field": "events", "allowed": Events}))
+			return
+		}
+	}
+	slices.Sort(input.Events)
+	input.Events = slices.Compact(input.Events)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	hook := Webhook{
+		URL:       target.String(),
+		Events:    input.Events,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: d.now().UTC(),
+	}
+	eventsJSON, _ := json.Marshal(hook.Events)
+	res, err := d.db.Exec(
+		"INSERT INTO webhooks (user, url, events, secret, created_at) VALUES (?, ?, ?, ?, ?)",
+		user, hook.URL, string(eventsJSON), hook.Secret, hook.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to create webhook: %w", err))
+		return
+	}
+	hook.ID, _ = res.LastInsertId()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// Returns the ID of the webhook named in the path, if it belongs to user
+func (d *Dispatcher) userWebhook(r *http.Request, user string) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, apierror.Validation("Invalid webhook ID")
+	}
+	var owner string
+	err = d.db.QueryRow("SELECT user FROM webhooks WHERE id = ?", id).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != user) {
+		return 0, apierror.NotFound("Webhook not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	return id, nil
+}
+
+func (d *Dispatcher) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	id, err := d.userWebhook(r, user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to delete webhook: %w", err))
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM deliveries WHERE webhook_id = ?", id); err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to delete deliveries: %w", err))
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id); err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to delete webhook: %w", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to delete webhook: %w", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *Dispatcher) handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.RequireUser(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	id, err := d.userWebhook(r, user)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 500 {
+			apierror.Write(w, r, apierror.Validation("limit must be between 1 and 500").
+				WithDetails(map[string]string{"parameter": "limit"}))
+			return
+		}
+	}
+
+	rows, err := d.db.Query(`
+		SELECT id, event, status, attempts, response_code, last_error, created_at, next_attempt_at, delivered_at
+		FROM deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?
+	`, id, limit)
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("failed to query deliveries: %w", err))
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var del Delivery
+		var createdAt, nextAttemptAt int64
+		var deliveredAt sql.NullInt64
+		err := rows.Scan(&del.ID, &del.Event, &del.Status, &del.Attempts, &del.ResponseCode,
+			&del.LastError, &createdAt, &nextAttemptAt, &deliveredAt)
+		if err != nil {
+			apierror.Write(w, r, fmt.Errorf("failed to scan delivery: %w", err))
+			return
+		}
+		del.CreatedAt = time.UnixMilli(createdAt).UTC()
+		if del.Status == "pending" {
+			next := time.UnixMilli(nextAttemptAt).UTC()
+			del.NextAttemptAt = &next
+		}
+		if deliveredAt.Valid {
+			at := time.UnixMilli(deliveredAt.Int64).UTC()
+			del.DeliveredAt = &at
+		}
+		deliveries = append(deliveries, del)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
@@ -0,0 +1,225 @@
+package webhooks
+
+import (
+	"NbirdHttp/auth"
+	"NbirdHttp/events"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	failures int // requests to fail before succeeding
+}
+
+func (rec *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.requests = append(rec.requests, r)
+	rec.bodies = append(rec.bodies, body)
+	if rec.failures > 0 {
+		rec.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func setup(t *testing.T) (*Dispatcher, *receiver, *httptest.Server, *time.Time) {
+	t.Helper()
+	d, err := Open(filepath.Join(t.TempDir(), "webhooks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.db.Close() })
+
+	// Everyone's password is secret
+	auth.AUTH_FILE = filepath.Join(t.TempDir(), ".auth")
+	t.Cleanup(func() { auth.AUTH_FILE = "./auth/.auth" })
+	hash := sha256.Sum256([]byte("secret"))
+	os.WriteFile(auth.AUTH_FILE, []byte(fmt.Sprintf("alice,%x\nbob,%x\n", hash, hash)), 0600)
+
+	now := time.Unix(1700000000, 0)
+	d.now = func() time.Time { return now }
+
+	rec := &receiver{}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+	return d, rec, server, &now
+}
+
+func createWebhook(t *testing.T, d *Dispatcher, user, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(body))
+	if user != "" {
+		req.SetBasicAuth(user, "secret")
+	}
+	rr := httptest.NewRecorder()
+	d.handleCreateWebhook(rr, req)
+	return rr
+}
+
+func listDeliveries(t *testing.T, d *Dispatcher, user, id string) []Delivery {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/webhooks/"+id+"/deliveries", nil)
+	req.SetBasicAuth(user, "secret")
+	req.SetPathValue("id", id)
+	rr := httptest.NewRecorder()
+	d.handleListDeliveries(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("deliveries: status = %d, body = %s", rr.Code, rr.Body)
+	}
+	var deliveries []Delivery
+	json.NewDecoder(rr.Body).Decode(&deliveries)
+	return deliveries
+}
+
+func TestDeliverySigned(t *testing.T) {
+	d, rec, server, now := setup(t)
+
+	rr := createWebhook(t, d, "alice", `{"url": "`+server.URL+`", "events": ["punch.in"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, body = %s", rr.Code, rr.Body)
+	}
+	var hook Webhook
+	json.NewDecoder(rr.Body).Decode(&hook)
+	if len(hook.Secret) != 64 {
+		t.Fatalf("secret = %q, want 32 hex encoded bytes", hook.Secret)
+	}
+
+	d.Enqueue(events.Event{Topic: "punch.in", User: "alice", Time: *now, Data: map[string]string{"at": "09:00"}})
+	d.Enqueue(events.Event{Topic: "punch.in", User: "bob", Time: *now})
+	d.Enqueue(events.Event{Topic: "punch.out", User: "alice", Time: *now})
+	d.deliverDue()
+
+	if len(rec.requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(rec.requests))
+	}
+	req, body := rec.requests[0], rec.bodies[0]
+	if got := req.Header.Get("X-Nbird-Event"); got != "punch.in" {
+		t.Errorf("X-Nbird-Event = %q", got)
+	}
+	want := "sha256=" + Sign(hook.Secret, req.Header.Get("X-Nbird-Timestamp"), body)
+	if got := req.Header.Get("X-Nbird-Signature"); got != want {
+		t.Errorf("X-Nbird-Signature = %q, want %q", got, want)
+	}
+
+	var payload Payload
+	json.Unmarshal(body, &payload)
+	if payload.Event != "punch.in" || payload.User != "alice" || !payload.Time.Equal(*now) {
+		t.Errorf("payload = %s", body)
+	}
+
+	deliveries := listDeliveries(t, d, "alice", "1")
+	if len(deliveries) != 1 || deliveries[0].Status != "delivered" || *deliveries[0].ResponseCode != 200 {
+		t.Errorf("deliveries = %+v", deliveries)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	d, rec, server, now := setup(t)
+	d.RetryBase = time.Minute
+	d.MaxAttempts = 3
+	rec.failures = 5
+
+	createWebhook(t, d, "alice", `{"url": "`+server.URL+`", "events": ["book.created"]}`)
+	d.Enqueue(events.Event{Topic: "book.created", Time: *now})
+
+	// Each failure doubles the wait before the next attempt
+	for _, wait := range []time.Duration{0, time.Minute, 2 * time.Minute} {
+		*now = now.Add(wait - time.Second)
+		d.deliverDue()
+		*now = now.Add(time.Second)
+		d.deliverDue()
+	}
+	if len(rec.requests) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(rec.requests))
+	}
+
+	deliveries := listDeliveries(t, d, "alice", "1")
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	del := deliveries[0]
+	if del.Status != "failed" || del.Attempts != 3 || *del.ResponseCode != 503 || del.LastError == nil {
+		t.Errorf("delivery = %+v", del)
+	}
+
+	// Failed deliveries are not retried again
+	*now = now.Add(time.Hour)
+	d.deliverDue()
+	if len(rec.requests) != 3 {
+		t.Errorf("receiver got %d requests after giving up, want 3", len(rec.requests))
+	}
+}
+
+func TestCreateWebhookValidation(t *testing.T) {
+	d, _, _, _ := setup(t)
+
+	tests := map[string]string{
+		"relative url":  `{"url": "/hook", "events": ["punch.in"]}`,
+		"ftp url":       `{"url": "ftp://example.com", "events": ["punch.in"]}`,
+		"no events":     `{"url": "http://example.com", "events": []}`,
+		"unknown event": `{"url": "http://example.com", "events": ["punch.sideways"]}`,
+	}
+	for name, body := range tests {
+		if rr := createWebhook(t, d, "alice", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, rr.Code)
+		}
+	}
+	if rr := createWebhook(t, d, "", tests["relative url"]); rr.Code != http.StatusUnauthorized {
+		t.Errorf("no user: status = %d, want 401", rr.Code)
+	}
+}
+
+func TestWebhooksPrivateToUser(t *testing.T) {
+	d, _, server, _ := setup(t)
+	createWebhook(t, d, "alice", `{"url": "`+server.URL+`", "events": ["punch.in"]}`)
+
+	req := httptest.NewRequest("GET", "/api/webhooks/1/deliveries", nil)
+	req.SetBasicAuth("bob", "secret")
+	req.SetPathValue("id", "1")
+	rr := httptest.NewRecorder()
+	d.handleListDeliveries(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("other user's deliveries: status = %d, want 404", rr.Code)
+	}
+
+	// Naming alice isn't enough to see her webhooks or register one for her
+	req = httptest.NewRequest("GET", "/api/webhooks/1/deliveries", nil)
+	req.Header.Set("Authorization", "Bearer alice")
+	req.SetPathValue("id", "1")
+	rr = httptest.NewRecorder()
+	d.handleListDeliveries(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("forged user's deliveries: status = %d, want 401", rr.Code)
+	}
+	req = httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(`{"url": "`+server.URL+`", "events": ["book.created"]}`))
+	req.Header.Set("Authorization", "Bearer alice")
+	rr = httptest.NewRecorder()
+	d.handleCreateWebhook(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("forged user's new webhook: status = %d, want 401", rr.Code)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{RetryBase: 30 * time.Second, RetryMax: 5 * time.Minute}
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, w := range want {
+		if got := d.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}